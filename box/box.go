@@ -2,7 +2,9 @@ package box
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"time"
 
 	"github.com/advancedlogic/box/configuration/viper"
 	"github.com/advancedlogic/box/interfaces"
@@ -28,6 +30,10 @@ type Box struct {
 	authN         interfaces.AuthN
 	authZ         interfaces.AuthZ
 	store         interfaces.Store
+	tracer        interfaces.Tracer
+	metrics       interfaces.Metrics
+	health        interfaces.Health
+	secrets       interfaces.Secrets
 	processors    []interfaces.Processor
 }
 
@@ -163,6 +169,52 @@ func WithStore(store interfaces.Store) Option {
 	}
 }
 
+func WithTracer(tracer interfaces.Tracer) Option {
+	return func(box *Box) error {
+		if tracer != nil {
+
+			box.tracer = tracer
+			return nil
+		}
+		return errors.New("tracer cannot be nil")
+	}
+}
+
+func WithMetrics(metrics interfaces.Metrics) Option {
+	return func(box *Box) error {
+		if metrics != nil {
+
+			box.metrics = metrics
+			return nil
+		}
+		return errors.New("metrics cannot be nil")
+	}
+}
+
+//WithHealth sets the health checks of the µs.
+//On Run the components implementing interfaces.Checker add their readiness checks.
+func WithHealth(health interfaces.Health) Option {
+	return func(box *Box) error {
+		if health != nil {
+
+			box.health = health
+			return nil
+		}
+		return errors.New("health cannot be nil")
+	}
+}
+
+//WithSecrets sets the manager of the short-lived credentials, closed by Stop revoking their leases
+func WithSecrets(secrets interfaces.Secrets) Option {
+	return func(box *Box) error {
+		if secrets != nil {
+			box.secrets = secrets
+			return nil
+		}
+		return errors.New("secrets cannot be nil")
+	}
+}
+
 func WithCache(cache interfaces.Cache) Option {
 	return func(box *Box) error {
 		if cache != nil {
@@ -301,6 +353,11 @@ func (b *Box) Run() {
 		}
 	}
 
+	if b.health != nil {
+		b.logger.Info("health setup")
+		b.checks()
+	}
+
 	if b.transport != nil {
 		b.logger.Info("transport setup")
 		err := b.transport.Listen()
@@ -315,6 +372,26 @@ func (b *Box) Run() {
 	go_shutdown_hook.Wait()
 }
 
+//checks adds the readiness checks of the components.
+//Broker and store are critical, cache and registry are not.
+func (b *Box) checks() {
+	components := []struct {
+		name      string
+		component interface{}
+		critical  bool
+	}{
+		{"broker", b.broker, true},
+		{"store", b.store, true},
+		{"cache", b.cache, false},
+		{"registry", b.registry, false},
+	}
+	for _, c := range components {
+		if checker, ok := c.component.(interfaces.Checker); ok {
+			b.health.AddReadinessCheck(c.name, checker.Check, c.critical)
+		}
+	}
+}
+
 func (b *Box) Stop() {
 	if b.broker != nil {
 		b.broker.Close()
@@ -327,6 +404,14 @@ func (b *Box) Stop() {
 	if b.cache != nil {
 		b.cache.Close()
 	}
+
+	if b.secrets != nil {
+		b.secrets.Close()
+	}
+
+	if b.tracer != nil {
+		b.tracer.Close()
+	}
 }
 
 func (b *Box) Logger() interfaces.Logger {
@@ -369,6 +454,53 @@ func (b *Box) Store() interfaces.Store {
 	return b.store
 }
 
+func (b *Box) Tracer() interfaces.Tracer {
+	return b.tracer
+}
+
+func (b *Box) Health() interfaces.Health {
+	return b.health
+}
+
+func (b *Box) Metrics() interfaces.Metrics {
+	return b.metrics
+}
+
+func (b *Box) Secrets() interfaces.Secrets {
+	return b.secrets
+}
+
+//Processors returns the processors of the µs.
+//If metrics are set, every call to Process is counted and measured.
 func (b *Box) Processors() []interfaces.Processor {
-	return b.Processors()
+	if b.metrics == nil {
+		return b.processors
+	}
+	processors := make([]interfaces.Processor, len(b.processors))
+	for i, processor := range b.processors {
+		processors[i] = &meteredProcessor{
+			Processor: processor,
+			metrics:   b.metrics,
+			name:      fmt.Sprintf("%T", processor),
+		}
+	}
+	return processors
+}
+
+type meteredProcessor struct {
+	interfaces.Processor
+	metrics interfaces.Metrics
+	name    string
+}
+
+func (p *meteredProcessor) Process(data interface{}) (interface{}, error) {
+	start := time.Now()
+	result, err := p.Processor.Process(data)
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	p.metrics.Counter("processor_processed_total", 1, map[string]string{"processor": p.name, "result": status})
+	p.metrics.Histogram("processor_duration_seconds", time.Since(start).Seconds(), map[string]string{"processor": p.name})
+	return result, err
 }
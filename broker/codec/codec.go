@@ -0,0 +1,143 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	errorUnknownType     = "unknown content type %s"
+	errorUnknownEncoding = "unknown content encoding %s"
+	errorProtoMessage    = "%T is not a proto.Message"
+)
+
+//Codec converts values to and from the payload of a message
+type Codec interface {
+	ContentType() string
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+//Compressor compresses the payload of a message
+type Compressor interface {
+	Encoding() string
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+var (
+	lock        sync.RWMutex
+	codecs      = make(map[string]Codec)
+	compressors = make(map[string]Compressor)
+)
+
+func init() {
+	Register(JSON{})
+	Register(Proto{})
+	Register(Msgpack{})
+	RegisterCompressor(Gzip{})
+	RegisterCompressor(NewZstd())
+}
+
+//Register makes a codec available to decode the messages with its content type
+func Register(codec Codec) {
+	lock.Lock()
+	defer lock.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+//RegisterCompressor makes a compressor available to decode the messages with its encoding
+func RegisterCompressor(compressor Compressor) {
+	lock.Lock()
+	defer lock.Unlock()
+	compressors[compressor.Encoding()] = compressor
+}
+
+//Lookup returns the codec registered for a content type
+func Lookup(contentType string) (Codec, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if codec, ok := codecs[contentType]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf(errorUnknownType, contentType)
+}
+
+//LookupCompressor returns the compressor registered for an encoding
+func LookupCompressor(encoding string) (Compressor, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if compressor, ok := compressors[encoding]; ok {
+		return compressor, nil
+	}
+	return nil, fmt.Errorf(errorUnknownEncoding, encoding)
+}
+
+//JSON encodes the values with encoding/json
+type JSON struct{}
+
+func (JSON) ContentType() string {
+	return "application/json"
+}
+
+func (JSON) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+//Msgpack encodes the values with MessagePack
+type Msgpack struct{}
+
+func (Msgpack) ContentType() string {
+	return "application/msgpack"
+}
+
+func (Msgpack) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (Msgpack) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+//Proto encodes protocol buffers messages.
+//Unmarshal accepts a proto.Message or a pointer to a nil proto.Message,
+//which is allocated.
+type Proto struct{}
+
+func (Proto) ContentType() string {
+	return "application/protobuf"
+}
+
+func (Proto) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf(errorProtoMessage, v)
+	}
+	return proto.Marshal(m)
+}
+
+func (Proto) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Ptr {
+		if m, ok := reflect.New(value.Elem().Type().Elem()).Interface().(proto.Message); ok {
+			if err := proto.Unmarshal(data, m); err != nil {
+				return err
+			}
+			value.Elem().Set(reflect.ValueOf(m))
+			return nil
+		}
+	}
+	return fmt.Errorf(errorProtoMessage, v)
+}
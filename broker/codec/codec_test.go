@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID    string  `json:"id" msgpack:"id"`
+	Total float64 `json:"total" msgpack:"total"`
+}
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{JSON{}, Msgpack{}} {
+		data, err := c.Marshal(order{ID: "1", Total: 9.5})
+		assert.Nil(t, err)
+		o := order{}
+		assert.Nil(t, c.Unmarshal(data, &o))
+		assert.Equal(t, order{ID: "1", Total: 9.5}, o)
+
+		registered, err := Lookup(c.ContentType())
+		assert.Nil(t, err)
+		assert.Equal(t, c, registered)
+	}
+	_, err := Lookup("text/plain")
+	assert.NotNil(t, err)
+}
+
+func TestProto(t *testing.T) {
+	c := Proto{}
+	data, err := c.Marshal(wrapperspb.String("hello"))
+	assert.Nil(t, err)
+
+	m := &wrapperspb.StringValue{}
+	assert.Nil(t, c.Unmarshal(data, m))
+	assert.Equal(t, "hello", m.GetValue())
+
+	var p *wrapperspb.StringValue
+	assert.Nil(t, c.Unmarshal(data, &p))
+	assert.Equal(t, "hello", p.GetValue())
+
+	_, err = c.Marshal(order{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, c.Unmarshal(data, &order{}))
+}
+
+func TestCompressors(t *testing.T) {
+	payload := []byte("a payload repeated, a payload repeated, a payload repeated")
+	for _, c := range []Compressor{Gzip{}, NewZstd()} {
+		compressed, err := c.Compress(payload)
+		assert.Nil(t, err)
+		data, err := c.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, data)
+
+		_, err = LookupCompressor(c.Encoding())
+		assert.Nil(t, err)
+	}
+	_, err := LookupCompressor("br")
+	assert.NotNil(t, err)
+}
+
+func TestCompressors_Bomb(t *testing.T) {
+	//a payload of zeros compresses to a tiny fraction of its size
+	bomb := make([]byte, 1<<20)
+	for _, c := range []Compressor{Gzip{Max: 1 << 19}, NewZstdWithMax(1 << 19)} {
+		compressed, err := c.Compress(bomb)
+		assert.Nil(t, err)
+		_, err = c.Decompress(compressed)
+		assert.True(t, errors.Is(err, ErrTooLarge), c.Encoding())
+	}
+	//without the size in the frame, stopped while decoding
+	stream := bytes.Buffer{}
+	writer, _ := zstd.NewWriter(&stream)
+	_, err := writer.Write(bomb)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Close())
+	_, err = NewZstdWithMax(1 << 19).Decompress(stream.Bytes())
+	assert.True(t, errors.Is(err, ErrTooLarge))
+}
@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//DefaultMaxSize is the default maximum size of a decompressed payload, 64MB
+const DefaultMaxSize = 64 << 20
+
+//ErrTooLarge is returned decompressing a payload larger than the maximum size,
+//like a compression bomb
+var ErrTooLarge = errors.New("decompressed payload too large")
+
+//Gzip compresses the payload with gzip
+type Gzip struct {
+	//Max is the maximum size of a decompressed payload. Default DefaultMaxSize.
+	Max int64
+}
+
+func (Gzip) Encoding() string {
+	return "gzip"
+}
+
+func (Gzip) Compress(data []byte) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	w := gzip.NewWriter(&buffer)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (g Gzip) Decompress(data []byte) ([]byte, error) {
+	max := g.Max
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	//a byte more than the maximum tells a payload too large
+	decompressed, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decompressed)) > max {
+		return nil, ErrTooLarge
+	}
+	return decompressed, nil
+}
+
+//Zstd compresses the payload with zstandard
+type Zstd struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	max     uint64
+}
+
+//NewZstd returns a Zstd decompressing payloads up to DefaultMaxSize
+func NewZstd() *Zstd {
+	return NewZstdWithMax(DefaultMaxSize)
+}
+
+//NewZstdWithMax returns a Zstd decompressing payloads up to max bytes
+func NewZstdWithMax(max uint64) *Zstd {
+	if max == 0 {
+		max = DefaultMaxSize
+	}
+	//without a writer or a reader the constructors cannot fail
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(max))
+	return &Zstd{encoder: encoder, decoder: decoder, max: max}
+}
+
+func (z *Zstd) Encoding() string {
+	return "zstd"
+}
+
+func (z *Zstd) Compress(data []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+func (z *Zstd) Decompress(data []byte) ([]byte, error) {
+	//refused before allocating when the frame announces its size
+	header := zstd.Header{}
+	if err := header.Decode(data); err == nil && header.HasFCS && header.FrameContentSize > z.max {
+		return nil, ErrTooLarge
+	}
+	decompressed, err := z.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	return decompressed, err
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	DeadLetterTopicHeader    = "Box-Dead-Letter-Topic"
+	DeadLetterErrorHeader    = "Box-Dead-Letter-Error"
+	DeadLetterAttemptsHeader = "Box-Dead-Letter-Attempts"
+	DeadLetterTimeHeader     = "Box-Dead-Letter-Time"
+
+	errorNotDeadLetter = "message is not a dead letter"
+)
+
+//DeadLetter is a message whose handler failed
+type DeadLetter struct {
+	//Sequence identifies the dead letter in the broker storing it, if any
+	Sequence uint64
+	Topic    string
+	Error    string
+	Attempts int
+	Failed   time.Time
+	//Header contains the original headers of the message
+	Header map[string]string
+	Data   []byte
+}
+
+//Headers returns the original headers plus the failure metadata
+func (d *DeadLetter) Headers() map[string]string {
+	header := make(map[string]string, len(d.Header)+4)
+	for key, value := range d.Header {
+		header[key] = value
+	}
+	header[DeadLetterTopicHeader] = d.Topic
+	//headers cannot contain new lines, the stack of a panic is flattened
+	header[DeadLetterErrorHeader] = strings.Replace(strings.Replace(d.Error, "\r", "", -1), "\n", " | ", -1)
+	header[DeadLetterAttemptsHeader] = strconv.Itoa(d.Attempts)
+	header[DeadLetterTimeHeader] = d.Failed.UTC().Format(time.RFC3339Nano)
+	return header
+}
+
+//ParseDeadLetter rebuilds a dead letter from the headers and the payload of a message
+func ParseDeadLetter(header map[string]string, data []byte) (*DeadLetter, error) {
+	topic, ok := header[DeadLetterTopicHeader]
+	if !ok {
+		return nil, errors.New(errorNotDeadLetter)
+	}
+	d := &DeadLetter{
+		Topic:  topic,
+		Error:  header[DeadLetterErrorHeader],
+		Header: make(map[string]string),
+		Data:   data,
+	}
+	d.Attempts, _ = strconv.Atoi(header[DeadLetterAttemptsHeader])
+	d.Failed, _ = time.Parse(time.RFC3339Nano, header[DeadLetterTimeHeader])
+	for key, value := range header {
+		switch key {
+		case DeadLetterTopicHeader, DeadLetterErrorHeader, DeadLetterAttemptsHeader, DeadLetterTimeHeader:
+		default:
+			d.Header[key] = value
+		}
+	}
+	return d, nil
+}
+
+//Replay publishes the message again on its original topic with its original headers
+func (d *DeadLetter) Replay(ctx context.Context, b interfaces.HeaderBroker) error {
+	if d.Topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	return b.PublishWithHeader(ctx, d.Topic, d.Header, d.Data)
+}
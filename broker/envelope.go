@@ -0,0 +1,257 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/advancedlogic/box/broker/codec"
+	"github.com/advancedlogic/box/commons"
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	IDHeader            = "Box-Message-Id"
+	ContentTypeHeader   = "Content-Type"
+	EncodingHeader      = "Content-Encoding"
+	TimestampHeader     = "Box-Timestamp"
+	CorrelationIDHeader = "Box-Correlation-Id"
+	SchemaVersionHeader = "Box-Schema-Version"
+	//KeyHeader carries the partitioning key, e.g. the aggregate, for the brokers ordering by key
+	KeyHeader = "Box-Key"
+
+	errorCodecNil      = "codec cannot be nil"
+	errorCompressorNil = "compressor cannot be nil"
+	errorHeaderEmpty   = "header name cannot be empty"
+	errorHandlerNil    = "handler cannot be nil"
+)
+
+//Envelope is a message with its metadata
+type Envelope struct {
+	ID            string
+	ContentType   string
+	Encoding      string
+	Timestamp     time.Time
+	CorrelationID string
+	SchemaVersion string
+	Header        map[string]string
+	Body          []byte
+}
+
+//Headers returns the metadata of the envelope as message headers
+func (e *Envelope) Headers() map[string]string {
+	header := make(map[string]string, len(e.Header)+6)
+	for key, value := range e.Header {
+		header[key] = value
+	}
+	set := func(key, value string) {
+		if value != "" {
+			header[key] = value
+		}
+	}
+	set(IDHeader, e.ID)
+	set(ContentTypeHeader, e.ContentType)
+	set(EncodingHeader, e.Encoding)
+	set(CorrelationIDHeader, e.CorrelationID)
+	set(SchemaVersionHeader, e.SchemaVersion)
+	if !e.Timestamp.IsZero() {
+		header[TimestampHeader] = e.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return header
+}
+
+//Open rebuilds an envelope from the headers and the payload of a message.
+//The remaining headers are kept in Header.
+func Open(header map[string]string, body []byte) *Envelope {
+	e := &Envelope{
+		Header: make(map[string]string),
+		Body:   body,
+	}
+	for key, value := range header {
+		switch key {
+		case IDHeader:
+			e.ID = value
+		case ContentTypeHeader:
+			e.ContentType = value
+		case EncodingHeader:
+			e.Encoding = value
+		case CorrelationIDHeader:
+			e.CorrelationID = value
+		case SchemaVersionHeader:
+			e.SchemaVersion = value
+		case TimestampHeader:
+			e.Timestamp, _ = time.Parse(time.RFC3339Nano, value)
+		default:
+			e.Header[key] = value
+		}
+	}
+	return e
+}
+
+//Decode decompresses the body and decodes it into v with the codec of its content type.
+//Envelopes without content type are decoded as JSON.
+func (e *Envelope) Decode(v interface{}) error {
+	body := e.Body
+	if e.Encoding != "" {
+		compressor, err := codec.LookupCompressor(e.Encoding)
+		if err != nil {
+			return err
+		}
+		if body, err = compressor.Decompress(body); err != nil {
+			return err
+		}
+	}
+	contentType := e.ContentType
+	if contentType == "" {
+		contentType = codec.JSON{}.ContentType()
+	}
+	c, err := codec.Lookup(contentType)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(body, v)
+}
+
+type envelopeKey struct{}
+
+//ContextWithEnvelope returns a context carrying the envelope being handled
+func ContextWithEnvelope(ctx context.Context, e *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, e)
+}
+
+//EnvelopeFromContext returns the envelope being handled, if any
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	e, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return e, ok
+}
+
+//EnvelopeOption customizes the envelope of a published message
+type EnvelopeOption func(*sealer) error
+
+type sealer struct {
+	envelope   *Envelope
+	codec      codec.Codec
+	compressor codec.Compressor
+}
+
+//WithCodec encodes the message with codec. Default JSON.
+func WithCodec(c codec.Codec) EnvelopeOption {
+	return func(s *sealer) error {
+		if c != nil {
+			s.codec = c
+			return nil
+		}
+		return errors.New(errorCodecNil)
+	}
+}
+
+//WithCompression compresses the encoded message
+func WithCompression(compressor codec.Compressor) EnvelopeOption {
+	return func(s *sealer) error {
+		if compressor != nil {
+			s.compressor = compressor
+			return nil
+		}
+		return errors.New(errorCompressorNil)
+	}
+}
+
+//WithID sets the message id, useful to deduplicate the messages. Default a new UUID.
+func WithID(id string) EnvelopeOption {
+	return func(s *sealer) error {
+		s.envelope.ID = id
+		return nil
+	}
+}
+
+//WithCorrelationID sets the correlation id. By default it is propagated from
+//the envelope found in the context, if any.
+func WithCorrelationID(id string) EnvelopeOption {
+	return func(s *sealer) error {
+		s.envelope.CorrelationID = id
+		return nil
+	}
+}
+
+//WithSchemaVersion sets the version of the schema of the message
+func WithSchemaVersion(version string) EnvelopeOption {
+	return func(s *sealer) error {
+		s.envelope.SchemaVersion = version
+		return nil
+	}
+}
+
+//WithHeader adds a custom header
+func WithHeader(key, value string) EnvelopeOption {
+	return func(s *sealer) error {
+		if key == "" {
+			return errors.New(errorHeaderEmpty)
+		}
+		s.envelope.Header[key] = value
+		return nil
+	}
+}
+
+//Seal encodes v into an envelope
+func Seal(ctx context.Context, v interface{}, options ...EnvelopeOption) (*Envelope, error) {
+	s := &sealer{
+		envelope: &Envelope{
+			ID:        commons.UUID(),
+			Timestamp: time.Now(),
+			Header:    make(map[string]string),
+		},
+		codec: codec.JSON{},
+	}
+	if parent, ok := EnvelopeFromContext(ctx); ok {
+		s.envelope.CorrelationID = parent.CorrelationID
+		if s.envelope.CorrelationID == "" {
+			s.envelope.CorrelationID = parent.ID
+		}
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s.envelope.ContentType = s.codec.ContentType()
+	if s.compressor != nil {
+		if body, err = s.compressor.Compress(body); err != nil {
+			return nil, err
+		}
+		s.envelope.Encoding = s.compressor.Encoding()
+	}
+	s.envelope.Body = body
+	return s.envelope, nil
+}
+
+//Publish encodes v in an envelope and publishes it on topic
+func Publish[T any](ctx context.Context, b interfaces.HeaderBroker, topic string, v T, options ...EnvelopeOption) error {
+	e, err := Seal(ctx, v, options...)
+	if err != nil {
+		return err
+	}
+	return b.PublishWithHeader(ctx, topic, e.Headers(), e.Body)
+}
+
+//Subscribe registers a handler receiving the messages of topic decoded as T,
+//wrapped with the middlewares. The context passed to the handler carries the
+//envelope, so the messages published while handling it share its correlation id.
+//Messages that cannot be decoded fail with a permanent error.
+func Subscribe[T any](b interfaces.HeaderBroker, topic string, handler func(context.Context, *Envelope, T) error, middlewares ...Middleware) error {
+	if handler == nil {
+		return errors.New(errorHandlerNil)
+	}
+	return Handle(b, topic, func(ctx context.Context, header map[string]string, body []byte) error {
+		e := Open(header, body)
+		var v T
+		if err := e.Decode(&v); err != nil {
+			return Permanent(err)
+		}
+		return handler(ContextWithEnvelope(ctx, e), e, v)
+	}, middlewares...)
+}
@@ -0,0 +1,91 @@
+package broker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/broker/codec"
+	"github.com/advancedlogic/box/broker/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	ID    string  `json:"id" msgpack:"id"`
+	Total float64 `json:"total" msgpack:"total"`
+}
+
+func TestEnvelope_Headers(t *testing.T) {
+	e, err := broker.Seal(context.Background(), order{ID: "1"},
+		broker.WithID("message-1"),
+		broker.WithSchemaVersion("v2"),
+		broker.WithHeader("Tenant", "acme"),
+	)
+	assert.Nil(t, err)
+	header := e.Headers()
+	assert.Equal(t, "message-1", header[broker.IDHeader])
+	assert.Equal(t, "application/json", header[broker.ContentTypeHeader])
+	assert.Equal(t, "v2", header[broker.SchemaVersionHeader])
+	assert.Equal(t, "acme", header["Tenant"])
+
+	opened := broker.Open(header, e.Body)
+	assert.Equal(t, "message-1", opened.ID)
+	assert.Equal(t, "v2", opened.SchemaVersion)
+	assert.Equal(t, map[string]string{"Tenant": "acme"}, opened.Header)
+	assert.True(t, e.Timestamp.Equal(opened.Timestamp))
+
+	o := order{}
+	assert.Nil(t, opened.Decode(&o))
+	assert.Equal(t, "1", o.ID)
+
+	_, err = broker.Seal(context.Background(), order{}, broker.WithHeader("", "value"))
+	assert.NotNil(t, err)
+	_, err = broker.Seal(context.Background(), order{}, broker.WithCodec(nil))
+	assert.NotNil(t, err)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b, _ := memory.New()
+	assert.Nil(t, b.Connect())
+	defer b.Close()
+
+	type received struct {
+		envelope *broker.Envelope
+		order    order
+	}
+	orders := make(chan received, 1)
+	err := broker.Subscribe(b, "orders", func(ctx context.Context, e *broker.Envelope, o order) error {
+		orders <- received{envelope: e, order: o}
+		return broker.Publish(ctx, b, "invoices", o.Total)
+	})
+	assert.Nil(t, err)
+	invoices := make(chan *broker.Envelope, 1)
+	err = broker.Subscribe(b, "invoices", func(ctx context.Context, e *broker.Envelope, total float64) error {
+		invoices <- e
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = broker.Publish(context.Background(), b, "orders", order{ID: "1", Total: 9.5},
+		broker.WithCodec(codec.Msgpack{}),
+		broker.WithCompression(codec.NewZstd()),
+		broker.WithCorrelationID("checkout-1"),
+	)
+	assert.Nil(t, err)
+
+	select {
+	case r := <-orders:
+		assert.Equal(t, order{ID: "1", Total: 9.5}, r.order)
+		assert.Equal(t, "application/msgpack", r.envelope.ContentType)
+		assert.Equal(t, "zstd", r.envelope.Encoding)
+	case <-time.After(time.Second):
+		t.Fatal("order not received")
+	}
+	select {
+	case e := <-invoices:
+		assert.Equal(t, "checkout-1", e.CorrelationID)
+	case <-time.After(time.Second):
+		t.Fatal("invoice not received")
+	}
+}
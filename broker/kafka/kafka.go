@@ -0,0 +1,491 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	//retryDelay is the wait before consuming again a record whose handler failed
+	retryDelay = time.Second
+
+	errorBrokersEmpty   = "brokers cannot be empty"
+	errorGroupEmpty     = "group cannot be empty"
+	errorLoggerNil      = "logger cannot be nil"
+	errorLingerNegative = "linger cannot be negative"
+	errorBatchZero      = "batch size must be greater than zero"
+	errorIntervalShort  = "commit interval must be at least 100ms"
+	errorTopicEmpty     = "topic cannot be empty"
+	errorHandlerNil     = "handler cannot be nil"
+	errorNotConnected   = "not connected"
+	errorAlreadyClosed  = "already closed"
+	errorConnected      = "already connected"
+	errorHandlerType    = "handler must be func([]byte), func(*kgo.Record) or func(context.Context, *kgo.Record) error"
+)
+
+//Partitions maps the topics to their partitions
+type Partitions map[string][]int32
+
+func WithBrokers(brokers ...string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if len(brokers) > 0 {
+			k := i.(*Kafka)
+			k.brokers = brokers
+			return nil
+		}
+		return errors.New(errorBrokersEmpty)
+	}
+}
+
+//WithGroup consumes the topics as a member of a consumer group, sharing
+//the partitions with the other members and committing the offsets
+func WithGroup(group string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if group != "" {
+			k := i.(*Kafka)
+			k.group = group
+			return nil
+		}
+		return errors.New(errorGroupEmpty)
+	}
+}
+
+func WithLogger(logger interfaces.Logger) broker.Option {
+	return func(i interfaces.Broker) error {
+		if logger != nil {
+			k := i.(*Kafka)
+			k.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//WithLinger sets how long PublishAsync waits to fill a batch. Default 10ms.
+func WithLinger(linger time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if linger >= 0 {
+			k := i.(*Kafka)
+			k.linger = linger
+			return nil
+		}
+		return errors.New(errorLingerNegative)
+	}
+}
+
+//WithBatchSize sets the maximum size in bytes of a batch of records. Default 1MB.
+func WithBatchSize(size int32) broker.Option {
+	return func(i interfaces.Broker) error {
+		if size > 0 {
+			k := i.(*Kafka)
+			k.batchSize = size
+			return nil
+		}
+		return errors.New(errorBatchZero)
+	}
+}
+
+//WithManualCommit disables the automatic commit: the offsets of the records
+//are committed only by Commit. By default the offsets of the handled records
+//are committed every commit interval.
+func WithManualCommit() broker.Option {
+	return func(i interfaces.Broker) error {
+		k := i.(*Kafka)
+		k.manual = true
+		return nil
+	}
+}
+
+//WithCommitInterval sets how often the offsets of the handled records are committed. Default 5s.
+func WithCommitInterval(interval time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if interval >= 100*time.Millisecond {
+			k := i.(*Kafka)
+			k.interval = interval
+			return nil
+		}
+		return errors.New(errorIntervalShort)
+	}
+}
+
+//FromBeginning consumes the partitions without a committed offset from the
+//first record. By default only the records published after joining are consumed.
+func FromBeginning() broker.Option {
+	return func(i interfaces.Broker) error {
+		k := i.(*Kafka)
+		k.beginning = true
+		return nil
+	}
+}
+
+//WithRebalance sets the callbacks called when the group assigns partitions
+//to this member and when it revokes them. With the automatic commit the
+//offsets of the handled records are committed before calling onRevoked.
+func WithRebalance(onAssigned, onRevoked func(Partitions)) broker.Option {
+	return func(i interfaces.Broker) error {
+		k := i.(*Kafka)
+		k.onAssigned = onAssigned
+		k.onRevoked = onRevoked
+		return nil
+	}
+}
+
+//Kafka implements the Broker interface on a Kafka cluster
+type Kafka struct {
+	interfaces.Logger
+
+	client     *kgo.Client
+	brokers    []string
+	group      string
+	linger     time.Duration
+	batchSize  int32
+	manual     bool
+	interval   time.Duration
+	beginning  bool
+	onAssigned func(Partitions)
+	onRevoked  func(Partitions)
+
+	lock     sync.RWMutex
+	handlers map[string]func(context.Context, *kgo.Record) error
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func New(options ...broker.Option) (*Kafka, error) {
+	k := &Kafka{
+		brokers:   []string{"localhost:9092"},
+		linger:    10 * time.Millisecond,
+		batchSize: 1024 * 1024,
+		interval:  5 * time.Second,
+		handlers:  make(map[string]func(context.Context, *kgo.Record) error),
+	}
+	for _, option := range options {
+		if err := option(k); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+//Instance returns the *kgo.Client
+func (k *Kafka) Instance() interface{} {
+	return k.client
+}
+
+func (k *Kafka) options() []kgo.Opt {
+	topics := make([]string, 0, len(k.handlers))
+	for topic := range k.handlers {
+		topics = append(topics, topic)
+	}
+	options := []kgo.Opt{
+		kgo.SeedBrokers(k.brokers...),
+		kgo.ProducerLinger(k.linger),
+		kgo.ProducerBatchMaxBytes(k.batchSize),
+		kgo.ConsumeTopics(topics...),
+	}
+	if k.beginning {
+		options = append(options, kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	}
+	if k.group == "" {
+		return options
+	}
+
+	options = append(options,
+		kgo.ConsumerGroup(k.group),
+		kgo.OnPartitionsAssigned(func(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			if k.onAssigned != nil {
+				k.onAssigned(assigned)
+			}
+		}),
+		kgo.OnPartitionsRevoked(func(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+			if !k.manual {
+				if err := client.CommitMarkedOffsets(ctx); err != nil && k.Logger != nil {
+					k.Logger.Warn(fmt.Sprintf("kafka commit: %s", err.Error()))
+				}
+			}
+			if k.onRevoked != nil {
+				k.onRevoked(revoked)
+			}
+		}),
+	)
+	if k.group != "" {
+		//the partitions stay assigned while a fetch is handled, so that the
+		//failed records can be consumed again
+		options = append(options, kgo.BlockRebalanceOnPoll())
+	}
+	if k.manual {
+		options = append(options, kgo.DisableAutoCommit())
+	} else {
+		options = append(options, kgo.AutoCommitMarks(), kgo.AutoCommitInterval(k.interval))
+	}
+	return options
+}
+
+//Connect creates the client and starts consuming the subscribed topics
+func (k *Kafka) Connect() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.client != nil {
+		return errors.New(errorConnected)
+	}
+	client, err := kgo.NewClient(k.options()...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	k.client = client
+	k.cancel = cancel
+	k.done = make(chan struct{})
+	go k.poll(ctx, client, k.done)
+	return nil
+}
+
+func (k *Kafka) poll(ctx context.Context, client *kgo.Client, done chan struct{}) {
+	defer close(done)
+	//the last poll blocks the rebalance of leaving the group on Close
+	defer client.AllowRebalance()
+	for {
+		fetches := client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if k.Logger != nil {
+				k.Logger.Warn(fmt.Sprintf("kafka fetch %s/%d: %s", topic, partition, err.Error()))
+			}
+		})
+		//the partitions of the failed records are consumed again from them
+		rewind := make(map[string]map[int32]kgo.EpochOffset)
+		fetches.EachRecord(func(record *kgo.Record) {
+			if _, failed := rewind[record.Topic][record.Partition]; failed {
+				return
+			}
+			if err := k.handle(ctx, record); err != nil {
+				if rewind[record.Topic] == nil {
+					rewind[record.Topic] = make(map[int32]kgo.EpochOffset)
+				}
+				rewind[record.Topic][record.Partition] = kgo.EpochOffset{Epoch: record.LeaderEpoch, Offset: record.Offset}
+				return
+			}
+			if k.group != "" && !k.manual {
+				client.MarkCommitRecords(record)
+			}
+		})
+		if len(rewind) > 0 {
+			client.SetOffsets(rewind)
+		}
+		client.AllowRebalance()
+		if len(rewind) > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
+
+//handle calls the handler of the record stopping its panics from killing the
+//process, a panic fails the record like an error
+func (k *Kafka) handle(ctx context.Context, record *kgo.Record) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			if k.Logger != nil {
+				k.Logger.Error(fmt.Sprintf("%s: panic: %v\n%s", record.Topic, r, debug.Stack()))
+			}
+		}
+	}()
+	k.lock.RLock()
+	handler, ok := k.handlers[record.Topic]
+	k.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := handler(ctx, record); err != nil {
+		if k.Logger != nil {
+			k.Logger.Warn(fmt.Sprintf("%s: %s", record.Topic, err.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+func (k *Kafka) connection() (*kgo.Client, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if k.client == nil {
+		return nil, errors.New(errorNotConnected)
+	}
+	return k.client, nil
+}
+
+func (k *Kafka) produce(ctx context.Context, record *kgo.Record) error {
+	if record.Topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	client, err := k.connection()
+	if err != nil {
+		return err
+	}
+	return client.ProduceSync(ctx, record).FirstErr()
+}
+
+//Publish sends a string or []byte message waiting for the acknowledgement of the cluster
+func (k *Kafka) Publish(topic string, message interface{}) error {
+	return k.PublishWithKey(context.Background(), topic, nil, message)
+}
+
+//PublishWithKey sends a message with a key waiting for the acknowledgement of the
+//cluster. Messages with the same key go to the same partition, keeping their order.
+func (k *Kafka) PublishWithKey(ctx context.Context, topic string, key []byte, message interface{}) error {
+	data, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	return k.produce(ctx, &kgo.Record{Topic: topic, Key: key, Value: data})
+}
+
+//PublishAsync queues a message to be sent in a batch, calling callback, if not nil,
+//with the result. Use Flush to wait for the queued messages.
+func (k *Kafka) PublishAsync(ctx context.Context, topic string, key []byte, message interface{}, callback func(error)) error {
+	if topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	data, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	client, err := k.connection()
+	if err != nil {
+		return err
+	}
+	client.Produce(ctx, &kgo.Record{Topic: topic, Key: key, Value: data}, func(_ *kgo.Record, err error) {
+		if callback != nil {
+			callback(err)
+		} else if err != nil && k.Logger != nil {
+			k.Logger.Warn(fmt.Sprintf("kafka publish %s: %s", topic, err.Error()))
+		}
+	})
+	return nil
+}
+
+//Flush waits until the messages queued by PublishAsync are sent
+func (k *Kafka) Flush(ctx context.Context) error {
+	client, err := k.connection()
+	if err != nil {
+		return err
+	}
+	return client.Flush(ctx)
+}
+
+//PublishWithHeader sends a payload with its headers, the key is the broker.KeyHeader header if any
+func (k *Kafka) PublishWithHeader(ctx context.Context, topic string, header map[string]string, data []byte) error {
+	record := &kgo.Record{Topic: topic, Value: data}
+	if key := header[broker.KeyHeader]; key != "" {
+		record.Key = []byte(key)
+	}
+	for key, value := range header {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+	}
+	return k.produce(ctx, record)
+}
+
+//Subscribe registers a handler for the topic, consuming it immediately if
+//already connected. The handler can be a func([]byte), a func(*kgo.Record)
+//or a func(context.Context, *kgo.Record) error. A record whose handler returns
+//an error or panics is not committed and is consumed again after a second,
+//the following records of its partition waiting for it.
+func (k *Kafka) Subscribe(topic string, handler interface{}) error {
+	var f func(context.Context, *kgo.Record) error
+	switch h := handler.(type) {
+	case func([]byte):
+		f = func(_ context.Context, record *kgo.Record) error {
+			h(record.Value)
+			return nil
+		}
+	case func(*kgo.Record):
+		f = func(_ context.Context, record *kgo.Record) error {
+			h(record)
+			return nil
+		}
+	case func(context.Context, *kgo.Record) error:
+		f = h
+	default:
+		return errors.New(errorHandlerType)
+	}
+	return k.subscribe(topic, f)
+}
+
+//SubscribeWithHeader registers a handler receiving the headers and the payload of the records
+func (k *Kafka) SubscribeWithHeader(topic string, handler func(context.Context, map[string]string, []byte) error) error {
+	if handler == nil {
+		return errors.New(errorHandlerNil)
+	}
+	return k.subscribe(topic, func(ctx context.Context, record *kgo.Record) error {
+		header := make(map[string]string, len(record.Headers))
+		for _, h := range record.Headers {
+			header[h.Key] = string(h.Value)
+		}
+		return handler(ctx, header, record.Value)
+	})
+}
+
+func (k *Kafka) subscribe(topic string, handler func(context.Context, *kgo.Record) error) error {
+	if topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.handlers[topic] = handler
+	if k.client != nil {
+		k.client.AddConsumeTopics(topic)
+	}
+	return nil
+}
+
+//Commit commits the offsets of the records, to be used with WithManualCommit
+func (k *Kafka) Commit(ctx context.Context, records ...*kgo.Record) error {
+	client, err := k.connection()
+	if err != nil {
+		return err
+	}
+	return client.CommitRecords(ctx, records...)
+}
+
+//Check verifies the connection to the cluster
+func (k *Kafka) Check(ctx context.Context) error {
+	client, err := k.connection()
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx)
+}
+
+//Close stops consuming, commits the offsets of the handled records
+//with the automatic commit, and leaves the group
+func (k *Kafka) Close() error {
+	k.lock.Lock()
+	client := k.client
+	k.client = nil
+	k.lock.Unlock()
+	if client == nil {
+		return errors.New(errorAlreadyClosed)
+	}
+	k.cancel()
+	<-k.done
+	if k.group != "" && !k.manual {
+		if err := client.CommitMarkedOffsets(context.Background()); err != nil && k.Logger != nil {
+			k.Logger.Warn(fmt.Sprintf("kafka commit: %s", err.Error()))
+		}
+	}
+	client.Close()
+	return nil
+}
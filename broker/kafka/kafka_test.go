@@ -0,0 +1,252 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+//runCluster starts an in-process Kafka-protocol cluster
+func runCluster(t *testing.T, topics ...string) []string {
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, topics...))
+	assert.Nil(t, err)
+	t.Cleanup(c.Close)
+	return c.ListenAddrs()
+}
+
+func connect(t *testing.T, k *Kafka) *Kafka {
+	assert.Nil(t, k.Connect())
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithBrokers())
+	assert.NotNil(t, err)
+	_, err = New(WithBatchSize(0))
+	assert.NotNil(t, err)
+	k, err := New()
+	assert.Nil(t, err)
+	assert.NotNil(t, k.Publish("orders", "order"))
+	assert.NotNil(t, k.Subscribe("orders", "handler"))
+	assert.NotNil(t, k.Close())
+}
+
+func TestKafka_PublishSubscribe(t *testing.T) {
+	brokers := runCluster(t, "orders")
+	k, _ := New(WithBrokers(brokers...), FromBeginning())
+	received := make(chan string, 10)
+	assert.Nil(t, k.Subscribe("orders", func(data []byte) { received <- string(data) }))
+	connect(t, k)
+	assert.Nil(t, k.Check(context.Background()))
+
+	assert.Nil(t, k.Publish("orders", "order-1"))
+	assert.NotNil(t, k.Publish("orders", 42))
+	select {
+	case r := <-received:
+		assert.Equal(t, "order-1", r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not received")
+	}
+}
+
+func TestKafka_KeyedPartitioning(t *testing.T) {
+	brokers := runCluster(t, "payments")
+	k, _ := New(WithBrokers(brokers...), FromBeginning(), WithLinger(50*time.Millisecond))
+	records := make(chan *kgo.Record, 20)
+	assert.Nil(t, k.Subscribe("payments", func(r *kgo.Record) { records <- r }))
+	connect(t, k)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		key := []byte("customer-1")
+		if i%2 == 1 {
+			key = []byte("customer-2")
+		}
+		assert.Nil(t, k.PublishAsync(context.Background(), "payments", key, []byte{byte(i)}, func(err error) {
+			assert.Nil(t, err)
+			wg.Done()
+		}))
+	}
+	assert.Nil(t, k.Flush(context.Background()))
+	wg.Wait()
+
+	partitions := map[string]int32{}
+	order := map[string][]byte{}
+	for i := 0; i < 10; i++ {
+		select {
+		case r := <-records:
+			key := string(r.Key)
+			if p, ok := partitions[key]; ok {
+				assert.Equal(t, p, r.Partition)
+			}
+			partitions[key] = r.Partition
+			order[key] = append(order[key], r.Value[0])
+		case <-time.After(5 * time.Second):
+			t.Fatal("record not received")
+		}
+	}
+	assert.Equal(t, []byte{0, 2, 4, 6, 8}, order["customer-1"])
+	assert.Equal(t, []byte{1, 3, 5, 7, 9}, order["customer-2"])
+}
+
+func TestKafka_ConsumerGroup(t *testing.T) {
+	brokers := runCluster(t, "jobs")
+	assigned := make(chan Partitions, 1)
+	received := make(chan string, 10)
+	k, _ := New(WithBrokers(brokers...), WithGroup("workers"), FromBeginning(), WithCommitInterval(100*time.Millisecond),
+		WithRebalance(func(p Partitions) { assigned <- p }, nil))
+	assert.Nil(t, k.Subscribe("jobs", func(data []byte) { received <- string(data) }))
+	assert.Nil(t, k.Connect())
+
+	select {
+	case p := <-assigned:
+		assert.ElementsMatch(t, []int32{0, 1, 2}, p["jobs"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("partitions not assigned")
+	}
+	assert.Nil(t, k.Publish("jobs", "job-1"))
+	select {
+	case r := <-received:
+		assert.Equal(t, "job-1", r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not received")
+	}
+	assert.Nil(t, k.Close())
+
+	//the offset has been committed, a new member does not receive job-1 again
+	k, _ = New(WithBrokers(brokers...), WithGroup("workers"), FromBeginning())
+	assert.Nil(t, k.Subscribe("jobs", func(data []byte) { received <- string(data) }))
+	connect(t, k)
+	assert.Nil(t, k.Publish("jobs", "job-2"))
+	select {
+	case r := <-received:
+		assert.Equal(t, "job-2", r)
+	case <-time.After(10 * time.Second):
+		t.Fatal("record not received")
+	}
+}
+
+func TestKafka_ManualCommit(t *testing.T) {
+	brokers := runCluster(t, "invoices")
+	received := make(chan *kgo.Record, 10)
+	k, _ := New(WithBrokers(brokers...), WithGroup("billing"), WithManualCommit(), FromBeginning())
+	assert.Nil(t, k.Subscribe("invoices", func(ctx context.Context, r *kgo.Record) error {
+		received <- r
+		return nil
+	}))
+	assert.Nil(t, k.Connect())
+	assert.Nil(t, k.Publish("invoices", "invoice-1"))
+	assert.Nil(t, k.Publish("invoices", "invoice-2"))
+
+	var first *kgo.Record
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-received:
+			if string(r.Value) == "invoice-1" {
+				first = r
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("record not received")
+		}
+	}
+	assert.Nil(t, k.Commit(context.Background(), first))
+	assert.Nil(t, k.Close())
+
+	//only invoice-1 has been committed
+	k, _ = New(WithBrokers(brokers...), WithGroup("billing"), WithManualCommit(), FromBeginning())
+	assert.Nil(t, k.Subscribe("invoices", func(ctx context.Context, r *kgo.Record) error {
+		received <- r
+		return nil
+	}))
+	connect(t, k)
+	select {
+	case r := <-received:
+		assert.Equal(t, "invoice-2", string(r.Value))
+	case <-time.After(10 * time.Second):
+		t.Fatal("record not received again")
+	}
+}
+
+func TestKafka_Envelope(t *testing.T) {
+	brokers := runCluster(t, "events")
+	k, _ := New(WithBrokers(brokers...), FromBeginning())
+	type event struct {
+		Name string `json:"name"`
+	}
+	received := make(chan *broker.Envelope, 1)
+	assert.Nil(t, broker.Subscribe(k, "events", func(ctx context.Context, e *broker.Envelope, ev event) error {
+		assert.Equal(t, "signup", ev.Name)
+		received <- e
+		return nil
+	}))
+	connect(t, k)
+	assert.Nil(t, broker.Publish(context.Background(), k, "events", event{Name: "signup"}, broker.WithID("event-1")))
+	select {
+	case e := <-received:
+		assert.Equal(t, "event-1", e.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("envelope not received")
+	}
+}
+
+func TestKafka_KeyHeader(t *testing.T) {
+	brokers := runCluster(t, "orders")
+	k, _ := New(WithBrokers(brokers...), FromBeginning())
+	records := make(chan *kgo.Record, 2)
+	assert.Nil(t, k.Subscribe("orders", func(r *kgo.Record) { records <- r }))
+	connect(t, k)
+
+	assert.Nil(t, k.PublishWithHeader(context.Background(), "orders", map[string]string{broker.IDHeader: "event-1", broker.KeyHeader: "order-1"}, []byte("created")))
+	assert.Nil(t, k.PublishWithHeader(context.Background(), "orders", map[string]string{broker.IDHeader: "event-2"}, []byte("priced")))
+	keys := map[string]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-records:
+			keys[string(r.Value)] = string(r.Key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("record not received")
+		}
+	}
+	assert.Equal(t, "order-1", keys["created"])
+	assert.Empty(t, keys["priced"])
+}
+
+func TestKafka_Redelivery(t *testing.T) {
+	brokers := runCluster(t, "shipments")
+	k, _ := New(WithBrokers(brokers...), WithGroup("shipping"), FromBeginning())
+	received := make(chan string, 10)
+	failures := 0
+	assert.Nil(t, k.Subscribe("shipments", func(ctx context.Context, r *kgo.Record) error {
+		received <- string(r.Value)
+		if string(r.Value) == "shipment-1" && failures == 0 {
+			failures++
+			return errors.New("carrier unavailable")
+		}
+		return nil
+	}))
+	connect(t, k)
+	assert.NotNil(t, k.Connect())
+	assert.Nil(t, k.PublishWithKey(context.Background(), "shipments", []byte("order"), "shipment-1"))
+	assert.Nil(t, k.PublishWithKey(context.Background(), "shipments", []byte("order"), "shipment-2"))
+
+	//the failed record comes again before the following ones of its partition
+	order := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		select {
+		case value := <-received:
+			order = append(order, value)
+		case <-time.After(10 * time.Second):
+			t.Fatal("record not received")
+		}
+	}
+	assert.Equal(t, []string{"shipment-1", "shipment-1", "shipment-2"}, order)
+}
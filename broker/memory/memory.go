@@ -0,0 +1,395 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/commons"
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	errorNotConnected = "not connected"
+	errorHandlerType  = "handler must be func(*memory.Message) or func([]byte)"
+	errorTopicEmpty   = "topic cannot be empty"
+	errorProcessorNil = "processor cannot be nil"
+	errorTimeoutZero  = "timeout must be greater than zero"
+	errorBufferZero   = "buffer must be greater than zero"
+	errorLoggerNil    = "logger cannot be nil"
+	errorHandlerNil   = "handler cannot be nil"
+)
+
+//Message is a message delivered by the in-process broker
+type Message struct {
+	Subject string
+	Reply   string
+	Header  map[string]string
+	Data    []byte
+
+	broker *Memory
+}
+
+//Respond publishes a reply to the sender of a request
+func (m *Message) Respond(data []byte, header map[string]string) error {
+	if m.Reply == "" {
+		return nil
+	}
+	return m.broker.deliver(&Message{Subject: m.Reply, Header: header, Data: data})
+}
+
+type subscriber struct {
+	topic    string
+	handler  func(*Message)
+	messages chan *Message
+	done     chan struct{}
+}
+
+//WithTimeout sets the timeout of Request and Gather when the context has no deadline. Default 5s.
+func WithTimeout(timeout time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if timeout > 0 {
+			m := i.(*Memory)
+			m.timeout = timeout
+			return nil
+		}
+		return errors.New(errorTimeoutZero)
+	}
+}
+
+//WithBuffer sets how many messages can wait to be handled by each subscriber. Default 1024.
+func WithBuffer(buffer int) broker.Option {
+	return func(i interfaces.Broker) error {
+		if buffer > 0 {
+			m := i.(*Memory)
+			m.buffer = buffer
+			return nil
+		}
+		return errors.New(errorBufferZero)
+	}
+}
+
+func WithLogger(logger interfaces.Logger) broker.Option {
+	return func(i interfaces.Broker) error {
+		if logger != nil {
+			m := i.(*Memory)
+			m.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//Memory is an in-process broker, useful for tests and single process services.
+//Topics support the nats wildcards: * matches a token, > matches the remaining tokens.
+//Each subscriber receives the messages in order on its own goroutine.
+type Memory struct {
+	interfaces.Logger
+
+	timeout time.Duration
+	buffer  int
+
+	lock        sync.RWMutex
+	connected   bool
+	subscribers []*subscriber
+}
+
+func New(options ...broker.Option) (*Memory, error) {
+	m := &Memory{
+		timeout:     5 * time.Second,
+		buffer:      1024,
+		subscribers: make([]*subscriber, 0),
+	}
+	for _, option := range options {
+		if err := option(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Memory) Instance() interface{} {
+	return nil
+}
+
+//Connect starts delivering the messages to the subscribers
+func (m *Memory) Connect() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.connected {
+		return nil
+	}
+	m.connected = true
+	for _, s := range m.subscribers {
+		m.run(s)
+	}
+	return nil
+}
+
+//run starts the goroutine of a subscriber. Must be called holding the lock.
+func (m *Memory) run(s *subscriber) {
+	s.messages = make(chan *Message, m.buffer)
+	s.done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-s.done:
+				return
+			case msg := <-s.messages:
+				m.handle(s, msg)
+			}
+		}
+	}()
+}
+
+//handle calls the handler of a subscriber stopping its panics from killing the process
+func (m *Memory) handle(s *subscriber, msg *Message) {
+	defer func() {
+		if r := recover(); r != nil && m.Logger != nil {
+			m.Logger.Error(fmt.Sprintf("%s: panic: %v\n%s", s.topic, r, debug.Stack()))
+		}
+	}()
+	s.handler(msg)
+}
+
+//Close stops delivering messages
+func (m *Memory) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.connected {
+		return errors.New(errorNotConnected)
+	}
+	m.connected = false
+	for _, s := range m.subscribers {
+		close(s.done)
+	}
+	return nil
+}
+
+//Publish sends a string or []byte message to the subscribers of the topic
+func (m *Memory) Publish(topic string, message interface{}) error {
+	data, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	return m.deliver(&Message{Subject: topic, Data: data})
+}
+
+func (m *Memory) deliver(msg *Message) error {
+	if msg.Subject == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	msg.broker = m
+	m.lock.RLock()
+	if !m.connected {
+		m.lock.RUnlock()
+		return errors.New(errorNotConnected)
+	}
+	subscribers := make([]*subscriber, 0)
+	for _, s := range m.subscribers {
+		if Match(s.topic, msg.Subject) {
+			subscribers = append(subscribers, s)
+		}
+	}
+	m.lock.RUnlock()
+
+	for _, s := range subscribers {
+		select {
+		case s.messages <- msg:
+		case <-s.done:
+		}
+	}
+	return nil
+}
+
+//Subscribe registers a handler for the topic.
+//The handler can be a func(*memory.Message) or a func([]byte).
+func (m *Memory) Subscribe(topic string, handler interface{}) error {
+	var f func(*Message)
+	switch h := handler.(type) {
+	case func(*Message):
+		f = h
+	case func([]byte):
+		f = func(msg *Message) { h(msg.Data) }
+	default:
+		return errors.New(errorHandlerType)
+	}
+	_, err := m.subscribe(topic, f)
+	return err
+}
+
+//PublishWithHeader publishes a payload with its headers
+func (m *Memory) PublishWithHeader(ctx context.Context, topic string, header map[string]string, data []byte) error {
+	h := make(map[string]string, len(header))
+	for key, value := range header {
+		h[key] = value
+	}
+	return m.deliver(&Message{Subject: topic, Header: h, Data: data})
+}
+
+//SubscribeWithHeader registers a handler receiving the headers and the payload of the messages
+func (m *Memory) SubscribeWithHeader(topic string, handler func(context.Context, map[string]string, []byte) error) error {
+	if handler == nil {
+		return errors.New(errorHandlerNil)
+	}
+	_, err := m.subscribe(topic, func(msg *Message) {
+		if err := handler(context.Background(), msg.Header, msg.Data); err != nil && m.Logger != nil {
+			m.Logger.Warn(fmt.Sprintf("%s: %s", topic, err.Error()))
+		}
+	})
+	return err
+}
+
+func (m *Memory) subscribe(topic string, handler func(*Message)) (*subscriber, error) {
+	if topic == "" {
+		return nil, errors.New(errorTopicEmpty)
+	}
+	s := &subscriber{topic: topic, handler: handler}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.subscribers = append(m.subscribers, s)
+	if m.connected {
+		m.run(s)
+	}
+	return s, nil
+}
+
+func (m *Memory) unsubscribe(s *subscriber) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, subscriber := range m.subscribers {
+		if subscriber == s {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			if m.connected {
+				close(s.done)
+			}
+			return
+		}
+	}
+}
+
+//Match returns true if the subject matches the topic, which can contain wildcards
+func Match(topic, subject string) bool {
+	topics := strings.Split(topic, ".")
+	subjects := strings.Split(subject, ".")
+	for i, t := range topics {
+		if t == ">" {
+			return len(subjects) > i
+		}
+		if i >= len(subjects) || (t != "*" && t != subjects[i]) {
+			return false
+		}
+	}
+	return len(topics) == len(subjects)
+}
+
+func (m *Memory) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.timeout)
+}
+
+//scatter publishes a request and forwards the replies on a channel until ctx expires
+func (m *Memory) scatter(ctx context.Context, subject string, message interface{}) (<-chan *Message, func(), error) {
+	data, err := broker.Payload(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	replies := make(chan *Message, m.buffer)
+	inbox := "_INBOX." + commons.UUID()
+	s, err := m.subscribe(inbox, func(msg *Message) {
+		select {
+		case replies <- msg:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.deliver(&Message{Subject: subject, Reply: inbox, Data: data}); err != nil {
+		m.unsubscribe(s)
+		return nil, nil, err
+	}
+	return replies, func() { m.unsubscribe(s) }, nil
+}
+
+func payload(msg *Message) ([]byte, error) {
+	if e := msg.Header[broker.ErrorHeader]; e != "" {
+		return nil, errors.New(e)
+	}
+	return msg.Data, nil
+}
+
+//Request publishes a message and waits for the first reply
+func (m *Memory) Request(ctx context.Context, subject string, message interface{}) ([]byte, error) {
+	ctx, cancel := m.deadline(ctx)
+	defer cancel()
+	replies, unsubscribe, err := m.scatter(ctx, subject, message)
+	if err != nil {
+		return nil, err
+	}
+	defer unsubscribe()
+	select {
+	case reply := <-replies:
+		return payload(reply)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//Gather publishes a message and collects the replies until max replies are received
+//or the context expires. Replies carrying an error are discarded.
+func (m *Memory) Gather(ctx context.Context, subject string, message interface{}, max int) ([][]byte, error) {
+	ctx, cancel := m.deadline(ctx)
+	defer cancel()
+	replies, unsubscribe, err := m.scatter(ctx, subject, message)
+	if err != nil {
+		return nil, err
+	}
+	defer unsubscribe()
+
+	results := make([][]byte, 0)
+	for max <= 0 || len(results) < max {
+		select {
+		case reply := <-replies:
+			if data, err := payload(reply); err == nil {
+				results = append(results, data)
+			}
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	if len(results) == 0 {
+		return nil, broker.ErrNoReplies
+	}
+	return results, nil
+}
+
+//Respond replies to the requests on subject with the result of the processor.
+//The processor receives the payload as []byte; its error, if any, is returned
+//to the requester.
+func (m *Memory) Respond(subject string, processor interfaces.Processor) error {
+	if processor == nil {
+		return errors.New(errorProcessorNil)
+	}
+	_, err := m.subscribe(subject, func(msg *Message) {
+		result, err := processor.Process(msg.Data)
+		var data []byte
+		if err == nil {
+			data, err = broker.Reply(result)
+		}
+		var header map[string]string
+		if err != nil {
+			header = map[string]string{broker.ErrorHeader: err.Error()}
+		}
+		msg.Respond(data, header)
+	})
+	return err
+}
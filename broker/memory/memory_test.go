@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+type echo struct {
+	prefix string
+	err    error
+}
+
+func (e *echo) Init(interfaces.Micro) error { return nil }
+func (e *echo) Close() error                { return nil }
+func (e *echo) Process(data interface{}) (interface{}, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.prefix + string(data.([]byte)), nil
+}
+
+func newMemory(t *testing.T) *Memory {
+	m, err := New(WithTimeout(100 * time.Millisecond))
+	assert.Nil(t, err)
+	return m
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithTimeout(0))
+	assert.NotNil(t, err)
+	_, err = New(WithBuffer(0))
+	assert.NotNil(t, err)
+	var _ interfaces.Broker = newMemory(t)
+	var _ interfaces.Requester = newMemory(t)
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("orders.created", "orders.created"))
+	assert.False(t, Match("orders.created", "orders.deleted"))
+	assert.True(t, Match("orders.*", "orders.created"))
+	assert.False(t, Match("orders.*", "orders.created.eu"))
+	assert.True(t, Match("orders.>", "orders.created.eu"))
+	assert.False(t, Match("orders.>", "orders"))
+	assert.False(t, Match("orders", "orders.created"))
+}
+
+func TestMemory_PublishSubscribe(t *testing.T) {
+	m := newMemory(t)
+	assert.NotNil(t, m.Publish("orders", "early"))
+	assert.NotNil(t, m.Subscribe("orders", "handler"))
+	assert.NotNil(t, m.Subscribe("", func([]byte) {}))
+
+	received := make(chan string, 10)
+	assert.Nil(t, m.Subscribe("orders.*", func(data []byte) {
+		received <- string(data)
+	}))
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+	assert.Nil(t, m.Subscribe("orders.>", func(msg *Message) {
+		received <- msg.Subject
+	}))
+
+	assert.Nil(t, m.Publish("orders.created", []byte("first")))
+	assert.Nil(t, m.Publish("orders.created", "second"))
+	assert.NotNil(t, m.Publish("orders.created", 42))
+
+	got := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		select {
+		case r := <-received:
+			got = append(got, r)
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	assert.ElementsMatch(t, []string{"first", "second", "orders.created", "orders.created"}, got)
+}
+
+func TestMemory_Request(t *testing.T) {
+	m := newMemory(t)
+	assert.Nil(t, m.Respond("echo", &echo{prefix: "echo "}))
+	assert.Nil(t, m.Respond("fail", &echo{err: errors.New("boom")}))
+	assert.NotNil(t, m.Respond("nil", nil))
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+
+	reply, err := m.Request(context.Background(), "echo", "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "echo hello", string(reply))
+
+	_, err = m.Request(context.Background(), "fail", "hello")
+	assert.EqualError(t, err, "boom")
+
+	_, err = m.Request(context.Background(), "nobody", "hello")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Request(ctx, "echo", "hello")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestMemory_Gather(t *testing.T) {
+	m := newMemory(t)
+	for _, prefix := range []string{"a", "b", "c"} {
+		assert.Nil(t, m.Respond("census", &echo{prefix: prefix}))
+	}
+	assert.Nil(t, m.Respond("census", &echo{err: errors.New("boom")}))
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+
+	replies, err := m.Gather(context.Background(), "census", "", 0)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, replies)
+
+	replies, err = m.Gather(context.Background(), "census", "", 2)
+	assert.Nil(t, err)
+	assert.Len(t, replies, 2)
+
+	_, err = m.Gather(context.Background(), "nobody", "", 0)
+	assert.Equal(t, broker.ErrNoReplies, err)
+}
+
+func TestMemory_RecoverPanic(t *testing.T) {
+	m := newMemory(t)
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+	received := make(chan string, 1)
+	assert.Nil(t, m.Subscribe("crash", func(data []byte) {
+		if string(data) == "first" {
+			panic("boom")
+		}
+		received <- string(data)
+	}))
+	assert.Nil(t, m.Publish("crash", "first"))
+	assert.Nil(t, m.Publish("crash", "second"))
+	select {
+	case r := <-received:
+		assert.Equal(t, "second", r)
+	case <-time.After(time.Second):
+		t.Fatal("subscriber died after a panic")
+	}
+}
@@ -0,0 +1,211 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	errorTopicEmpty   = "topic cannot be empty"
+	errorSubjectEmpty = "dead letter subject cannot be empty"
+	errorBrokerNil    = "dead letter broker cannot be nil"
+)
+
+//Handler handles the headers and the payload of a message
+type Handler func(context.Context, map[string]string, []byte) error
+
+//Middleware wraps a handler adding a behaviour
+type Middleware func(Handler) Handler
+
+//Chain wraps handler with the middlewares, the first one being the outermost
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+//Handle subscribes to topic a handler wrapped with the middlewares
+func Handle(b interfaces.HeaderBroker, topic string, handler Handler, middlewares ...Middleware) error {
+	if handler == nil {
+		return errors.New(errorHandlerNil)
+	}
+	h := Chain(handler, middlewares...)
+	return b.SubscribeWithHeader(topic, func(ctx context.Context, header map[string]string, data []byte) error {
+		return h(ContextWithTopic(ctx, topic), header, data)
+	})
+}
+
+type topicKey struct{}
+
+//ContextWithTopic returns a context carrying the topic of the message being handled
+func ContextWithTopic(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, topicKey{}, topic)
+}
+
+//TopicFromContext returns the topic of the message being handled, if any
+func TopicFromContext(ctx context.Context) string {
+	topic, _ := ctx.Value(topicKey{}).(string)
+	return topic
+}
+
+type permanent struct {
+	err error
+}
+
+func (p *permanent) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanent) Unwrap() error {
+	return p.err
+}
+
+//Permanent marks an error that retrying cannot fix, like a message that cannot be decoded
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanent{err: err}
+}
+
+//IsPermanent returns true if err has been marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanent
+	return errors.As(err, &p)
+}
+
+//RetryError is returned by Retry when every attempt failed
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (r *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %s", r.Attempts, r.Err.Error())
+}
+
+func (r *RetryError) Unwrap() error {
+	return r.Err
+}
+
+//Recover turns the panics of the handler into permanent errors, a message
+//crashing its handler is not retried
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, header map[string]string, data []byte) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = Permanent(fmt.Errorf("panic: %v\n%s", r, debug.Stack()))
+				}
+			}()
+			return next(ctx, header, data)
+		}
+	}
+}
+
+//Backoff computes the delay between the attempts.
+//The delay starts from Initial and is multiplied by Multiplier at every
+//attempt up to Max. Jitter, between 0 and 1, randomizes it by that fraction.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+//DefaultBackoff starts from 100ms doubling up to 10s
+var DefaultBackoff = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+//Delay returns the delay before the given retry, starting from 1
+func (b Backoff) Delay(retry int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < retry; i++ {
+		delay *= b.Multiplier
+		if b.Max > 0 && delay > float64(b.Max) {
+			delay = float64(b.Max)
+			break
+		}
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+//Retry calls the handler up to attempts times waiting the backoff between them.
+//Permanent errors and the cancellation of the context stop the retries.
+//Attempts lower than 1 count as 1.
+func Retry(attempts int, backoff Backoff) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, header map[string]string, data []byte) error {
+			var err error
+			attempt := 1
+			for ; ; attempt++ {
+				if err = next(ctx, header, data); err == nil {
+					return nil
+				}
+				if attempt >= attempts || IsPermanent(err) {
+					break
+				}
+				timer := time.NewTimer(backoff.Delay(attempt))
+				select {
+				case <-timer.C:
+					continue
+				case <-ctx.Done():
+					timer.Stop()
+				}
+				break
+			}
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+	}
+}
+
+//DeadLetterQueue publishes the messages whose handler failed on subject, with the
+//original headers and payload plus the failure metadata, and acknowledges them.
+//Use it as the outermost middleware.
+func DeadLetterQueue(b interfaces.HeaderBroker, subject string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, header map[string]string, data []byte) error {
+			err := next(ctx, header, data)
+			if err == nil {
+				return nil
+			}
+			if b == nil {
+				return fmt.Errorf("%s: %w", errorBrokerNil, err)
+			}
+			if subject == "" {
+				return fmt.Errorf("%s: %w", errorSubjectEmpty, err)
+			}
+			letter := &DeadLetter{
+				Topic:    TopicFromContext(ctx),
+				Error:    err.Error(),
+				Attempts: 1,
+				Failed:   time.Now(),
+				Header:   header,
+				Data:     data,
+			}
+			var retry *RetryError
+			if errors.As(err, &retry) {
+				letter.Attempts = retry.Attempts
+				letter.Error = retry.Err.Error()
+			}
+			if e := b.PublishWithHeader(ctx, subject, letter.Headers(), data); e != nil {
+				return fmt.Errorf("dead letter: %s: %w", e.Error(), err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/broker/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+var fast = broker.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := broker.Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 400*time.Millisecond, b.Delay(3))
+	assert.Equal(t, time.Second, b.Delay(10))
+
+	b.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		delay := b.Delay(1)
+		assert.True(t, delay >= 50*time.Millisecond && delay <= 150*time.Millisecond)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	h := broker.Chain(func(context.Context, map[string]string, []byte) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, broker.Retry(5, fast))
+	assert.Nil(t, h(context.Background(), nil, nil))
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	h = broker.Chain(func(context.Context, map[string]string, []byte) error {
+		calls++
+		return errors.New("unavailable")
+	}, broker.Retry(3, fast))
+	err := h(context.Background(), nil, nil)
+	retry := &broker.RetryError{}
+	assert.True(t, errors.As(err, &retry))
+	assert.Equal(t, 3, retry.Attempts)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	h = broker.Chain(func(context.Context, map[string]string, []byte) error {
+		calls++
+		return broker.Permanent(errors.New("malformed"))
+	}, broker.Retry(3, fast))
+	assert.NotNil(t, h(context.Background(), nil, nil))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRecover(t *testing.T) {
+	h := broker.Chain(func(context.Context, map[string]string, []byte) error {
+		panic("boom")
+	}, broker.Recover(), broker.Retry(3, fast))
+	err := h(context.Background(), nil, nil)
+	assert.NotNil(t, err)
+	assert.True(t, broker.IsPermanent(err))
+	assert.Contains(t, err.Error(), "panic: boom")
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	b, _ := memory.New()
+	assert.Nil(t, b.Connect())
+	defer b.Close()
+
+	letters := make(chan *broker.DeadLetter, 1)
+	err := b.SubscribeWithHeader("orders.dlq", func(ctx context.Context, header map[string]string, data []byte) error {
+		letter, err := broker.ParseDeadLetter(header, data)
+		assert.Nil(t, err)
+		letters <- letter
+		return nil
+	})
+	assert.Nil(t, err)
+
+	type order struct {
+		ID string `json:"id"`
+	}
+	replayed := make(chan order, 1)
+	failing := true
+	err = broker.Subscribe(b, "orders", func(ctx context.Context, e *broker.Envelope, o order) error {
+		if failing {
+			return errors.New("database down")
+		}
+		replayed <- o
+		return nil
+	}, broker.DeadLetterQueue(b, "orders.dlq"), broker.Recover(), broker.Retry(2, fast))
+	assert.Nil(t, err)
+	assert.Nil(t, broker.Publish(context.Background(), b, "orders", order{ID: "1"}, broker.WithID("order-1")))
+
+	var letter *broker.DeadLetter
+	select {
+	case letter = <-letters:
+	case <-time.After(time.Second):
+		t.Fatal("dead letter not received")
+	}
+	assert.Equal(t, "orders", letter.Topic)
+	assert.Equal(t, "database down", letter.Error)
+	assert.Equal(t, 2, letter.Attempts)
+	assert.Equal(t, "order-1", letter.Header[broker.IDHeader])
+	assert.JSONEq(t, `{"id":"1"}`, string(letter.Data))
+
+	_, err = broker.ParseDeadLetter(map[string]string{}, nil)
+	assert.NotNil(t, err)
+
+	failing = false
+	assert.Nil(t, letter.Replay(context.Background(), b))
+	select {
+	case o := <-replayed:
+		assert.Equal(t, "1", o.ID)
+	case <-time.After(time.Second):
+		t.Fatal("dead letter not replayed")
+	}
+}
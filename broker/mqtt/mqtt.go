@@ -0,0 +1,417 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/commons"
+	"github.com/advancedlogic/box/interfaces"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	errorEndpointEmpty = "endpoint cannot be empty"
+	errorClientIDEmpty = "client id cannot be empty"
+	errorLoggerNil     = "logger cannot be nil"
+	errorQoS           = "qos must be 0, 1 or 2"
+	errorTimeoutZero   = "timeout must be greater than zero"
+	errorTopicEmpty    = "topic cannot be empty"
+	errorGroupEmpty    = "group cannot be empty"
+	errorDirEmpty      = "session directory cannot be empty"
+	errorNotConnected  = "not connected"
+	errorHandlerType   = "handler must be func(paho.Message) or func([]byte)"
+)
+
+func WithEndpoint(endpoint string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if endpoint != "" {
+			m := i.(*Mqtt)
+			m.endpoint = endpoint
+			return nil
+		}
+		return errors.New(errorEndpointEmpty)
+	}
+}
+
+//WithClientID sets the id identifying the session on the server. Default a random id.
+//Set it to resume the session after a restart.
+func WithClientID(id string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if id != "" {
+			m := i.(*Mqtt)
+			m.clientID = id
+			return nil
+		}
+		return errors.New(errorClientIDEmpty)
+	}
+}
+
+func WithCredentials(username, password string) broker.Option {
+	return func(i interfaces.Broker) error {
+		m := i.(*Mqtt)
+		m.username = username
+		m.password = password
+		return nil
+	}
+}
+
+func WithLogger(logger interfaces.Logger) broker.Option {
+	return func(i interfaces.Broker) error {
+		if logger != nil {
+			m := i.(*Mqtt)
+			m.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//WithQoS sets the quality of service of Publish and Subscribe. Default 1.
+func WithQoS(qos byte) broker.Option {
+	return func(i interfaces.Broker) error {
+		if qos <= 2 {
+			m := i.(*Mqtt)
+			m.qos = qos
+			return nil
+		}
+		return errors.New(errorQoS)
+	}
+}
+
+//WithRetained makes the server keep the last message of every topic published
+//with Publish and deliver it to the new subscribers
+func WithRetained() broker.Option {
+	return func(i interfaces.Broker) error {
+		m := i.(*Mqtt)
+		m.retained = true
+		return nil
+	}
+}
+
+//WithWill sets the last will, the message published by the server when the
+//connection is lost without disconnecting
+func WithWill(topic string, message []byte, qos byte, retained bool) broker.Option {
+	return func(i interfaces.Broker) error {
+		if topic == "" {
+			return errors.New(errorTopicEmpty)
+		}
+		if qos > 2 {
+			return errors.New(errorQoS)
+		}
+		m := i.(*Mqtt)
+		m.will = &will{topic: Topic(topic), message: message, qos: qos, retained: retained}
+		return nil
+	}
+}
+
+//WithGroup makes the subscriptions shared among the clients of the same group,
+//every message being delivered to only one of them
+func WithGroup(group string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if group != "" {
+			m := i.(*Mqtt)
+			m.group = group
+			return nil
+		}
+		return errors.New(errorGroupEmpty)
+	}
+}
+
+//WithCleanSession discards the session on the server when connecting.
+//By default the session is resumed: the subscriptions and the messages
+//with QoS 1 and 2 sent while disconnected survive a reconnection.
+func WithCleanSession() broker.Option {
+	return func(i interfaces.Broker) error {
+		m := i.(*Mqtt)
+		m.clean = true
+		return nil
+	}
+}
+
+//WithSessionStore keeps the in-flight messages in a directory so that they
+//survive a restart of the process. By default they are kept in memory.
+func WithSessionStore(dir string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if dir != "" {
+			m := i.(*Mqtt)
+			m.store = paho.NewFileStore(dir)
+			return nil
+		}
+		return errors.New(errorDirEmpty)
+	}
+}
+
+//WithTimeout sets how long connecting, publishing and subscribing can take. Default 5s.
+func WithTimeout(timeout time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if timeout > 0 {
+			m := i.(*Mqtt)
+			m.timeout = timeout
+			return nil
+		}
+		return errors.New(errorTimeoutZero)
+	}
+}
+
+type will struct {
+	topic    string
+	message  []byte
+	qos      byte
+	retained bool
+}
+
+//Mqtt implements the Broker interface on an MQTT 3.1.1 server.
+//Topics use the box notation, translated by Topic: "sensors.*.temperature"
+//becomes "sensors/+/temperature" and "sensors.>" becomes "sensors/#".
+//The client reconnects automatically subscribing again to its topics.
+type Mqtt struct {
+	interfaces.Logger
+
+	client   paho.Client
+	endpoint string
+	clientID string
+	username string
+	password string
+	qos      byte
+	retained bool
+	clean    bool
+	group    string
+	will     *will
+	store    paho.Store
+	timeout  time.Duration
+
+	lock     sync.RWMutex
+	handlers map[string]paho.MessageHandler
+}
+
+func New(options ...broker.Option) (*Mqtt, error) {
+	m := &Mqtt{
+		endpoint: "tcp://localhost:1883",
+		clientID: "box-" + commons.UUID(),
+		qos:      1,
+		timeout:  5 * time.Second,
+		handlers: make(map[string]paho.MessageHandler),
+	}
+	for _, option := range options {
+		if err := option(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Mqtt) Instance() interface{} {
+	return m.client
+}
+
+//Topic translates a topic from the box notation to the MQTT one.
+//Topics already containing a / are returned as they are.
+func Topic(topic string) string {
+	if strings.Contains(topic, "/") {
+		return topic
+	}
+	levels := strings.Split(topic, ".")
+	for i, level := range levels {
+		switch level {
+		case "*":
+			levels[i] = "+"
+		case ">":
+			levels[i] = "#"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+//filter returns the subscription filter of a topic, shared if a group is set
+func (m *Mqtt) filter(topic string) string {
+	if m.group != "" {
+		return fmt.Sprintf("$share/%s/%s", m.group, topic)
+	}
+	return topic
+}
+
+func (m *Mqtt) wait(token paho.Token) error {
+	if !token.WaitTimeout(m.timeout) {
+		return fmt.Errorf("timeout after %s", m.timeout)
+	}
+	return token.Error()
+}
+
+func (m *Mqtt) Connect() error {
+	options := paho.NewClientOptions().
+		AddBroker(m.endpoint).
+		SetClientID(m.clientID).
+		SetCleanSession(m.clean).
+		SetAutoReconnect(true).
+		SetConnectTimeout(m.timeout).
+		SetMaxReconnectInterval(10 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(m.resubscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			if m.Logger != nil {
+				m.Logger.Warn(fmt.Sprintf("mqtt connection lost: %s", err.Error()))
+			}
+		})
+	if m.username != "" {
+		options.SetUsername(m.username).SetPassword(m.password)
+	}
+	if m.will != nil {
+		options.SetBinaryWill(m.will.topic, m.will.message, m.will.qos, m.will.retained)
+	}
+	if m.store != nil {
+		options.SetStore(m.store)
+	}
+	client := paho.NewClient(options)
+	//the messages of a resumed session arrive before the subscriptions are renewed
+	m.lock.RLock()
+	for topic, handler := range m.handlers {
+		client.AddRoute(topic, handler)
+	}
+	m.lock.RUnlock()
+	if err := m.wait(client.Connect()); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.client = client
+	for topic, handler := range m.handlers {
+		if err := m.wait(client.Subscribe(m.filter(topic), m.qos, handler)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//resubscribe subscribes the handlers again on a reconnection, a resumed session
+//already has them but a new one, or a server restart, would lose them
+func (m *Mqtt) resubscribe(client paho.Client) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.client == nil {
+		//first connection, Connect subscribes
+		return
+	}
+	for topic, handler := range m.handlers {
+		token := client.Subscribe(m.filter(topic), m.qos, handler)
+		go func(topic string) {
+			if err := m.wait(token); err != nil && m.Logger != nil {
+				m.Logger.Error(fmt.Sprintf("mqtt subscribe %s: %s", topic, err.Error()))
+			}
+		}(topic)
+	}
+}
+
+func (m *Mqtt) connection() (paho.Client, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.client == nil {
+		return nil, errors.New(errorNotConnected)
+	}
+	return m.client, nil
+}
+
+//Publish sends a string or []byte message with the default QoS and retain flag
+func (m *Mqtt) Publish(topic string, message interface{}) error {
+	return m.PublishWithQoS(topic, message, m.qos, m.retained)
+}
+
+//PublishWithQoS sends a message with the given QoS and retain flag.
+//It returns when the message has been delivered as required by the QoS.
+func (m *Mqtt) PublishWithQoS(topic string, message interface{}, qos byte, retained bool) error {
+	if topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	if qos > 2 {
+		return errors.New(errorQoS)
+	}
+	data, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	client, err := m.connection()
+	if err != nil {
+		return err
+	}
+	return m.wait(client.Publish(Topic(topic), qos, retained, data))
+}
+
+//Subscribe registers a handler for the topic, subscribing immediately if
+//already connected. The handler can be a func(paho.Message) or a func([]byte).
+func (m *Mqtt) Subscribe(topic string, handler interface{}) error {
+	if topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	var f func(paho.Message)
+	switch h := handler.(type) {
+	case func(paho.Message):
+		f = h
+	case func([]byte):
+		f = func(msg paho.Message) { h(msg.Payload()) }
+	default:
+		return errors.New(errorHandlerType)
+	}
+	topic = Topic(topic)
+	h := func(_ paho.Client, msg paho.Message) {
+		defer m.recover(topic)
+		f(msg)
+	}
+
+	m.lock.Lock()
+	m.handlers[topic] = h
+	client := m.client
+	m.lock.Unlock()
+	if client == nil {
+		return nil
+	}
+	return m.wait(client.Subscribe(m.filter(topic), m.qos, h))
+}
+
+//Unsubscribe removes the handler of the topic
+func (m *Mqtt) Unsubscribe(topic string) error {
+	topic = Topic(topic)
+	m.lock.Lock()
+	delete(m.handlers, topic)
+	client := m.client
+	m.lock.Unlock()
+	if client == nil {
+		return nil
+	}
+	return m.wait(client.Unsubscribe(m.filter(topic)))
+}
+
+//recover stops the panics of a handler from killing the process
+func (m *Mqtt) recover(topic string) {
+	if r := recover(); r != nil && m.Logger != nil {
+		m.Logger.Error(fmt.Sprintf("%s: panic: %v\n%s", topic, r, debug.Stack()))
+	}
+}
+
+//Check verifies the connection to the server
+func (m *Mqtt) Check(ctx context.Context) error {
+	client, err := m.connection()
+	if err != nil {
+		return err
+	}
+	if !client.IsConnectionOpen() {
+		return errors.New("connection is not open")
+	}
+	return nil
+}
+
+//Close disconnects from the server, the last will is not published
+func (m *Mqtt) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.client == nil {
+		return errors.New(errorNotConnected)
+	}
+	m.client.Disconnect(250)
+	m.client = nil
+	return nil
+}
@@ -0,0 +1,160 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/stretchr/testify/assert"
+)
+
+// runServer starts an embedded mqtt server returning its endpoint
+func runServer(t *testing.T) (*server.Server, string) {
+	s := server.New(&server.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.Nil(t, s.AddHook(new(auth.AllowHook), nil))
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	assert.Nil(t, s.AddListener(tcp))
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s, "tcp://" + tcp.Address()
+}
+
+func connect(t *testing.T, endpoint string) *Mqtt {
+	m, err := New(WithEndpoint(endpoint), WithTimeout(2*time.Second))
+	assert.Nil(t, err)
+	assert.Nil(t, m.Connect())
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func receive(t *testing.T, messages chan string) string {
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+	return ""
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithQoS(3))
+	assert.NotNil(t, err)
+	_, err = New(WithWill("", nil, 0, false))
+	assert.NotNil(t, err)
+	m, err := New()
+	assert.Nil(t, err)
+	assert.NotNil(t, m.Publish("topic", "message"))
+	assert.NotNil(t, m.Check(context.Background()))
+}
+
+func TestTopic(t *testing.T) {
+	assert.Equal(t, "sensors/kitchen/temperature", Topic("sensors.kitchen.temperature"))
+	assert.Equal(t, "sensors/+/temperature", Topic("sensors.*.temperature"))
+	assert.Equal(t, "sensors/#", Topic("sensors.>"))
+	assert.Equal(t, "sensors/+/v1.2", Topic("sensors/+/v1.2"))
+}
+
+func TestMqtt_PublishSubscribe(t *testing.T) {
+	_, endpoint := runServer(t)
+	m := connect(t, endpoint)
+	assert.Nil(t, m.Check(context.Background()))
+	assert.NotNil(t, m.Subscribe("sensors", "handler"))
+
+	messages := make(chan string, 10)
+	assert.Nil(t, m.Subscribe("sensors.*.temperature", func(data []byte) {
+		messages <- string(data)
+	}))
+	assert.Nil(t, m.Subscribe("alarms.>", func(msg paho.Message) {
+		messages <- msg.Topic()
+	}))
+
+	assert.Nil(t, m.Publish("sensors.kitchen.temperature", "21"))
+	assert.Equal(t, "21", receive(t, messages))
+	assert.Nil(t, m.PublishWithQoS("alarms.kitchen.smoke", []byte("on"), 2, false))
+	assert.Equal(t, "alarms/kitchen/smoke", receive(t, messages))
+	assert.NotNil(t, m.PublishWithQoS("alarms", "on", 3, false))
+	assert.NotNil(t, m.Publish("alarms", 42))
+
+	assert.Nil(t, m.Unsubscribe("alarms.>"))
+	assert.Nil(t, m.Publish("alarms.kitchen.smoke", "off"))
+	assert.Nil(t, m.Publish("sensors.kitchen.temperature", "22"))
+	assert.Equal(t, "22", receive(t, messages))
+}
+
+func TestMqtt_Retained(t *testing.T) {
+	_, endpoint := runServer(t)
+	m := connect(t, endpoint)
+	assert.Nil(t, m.PublishWithQoS("devices.lamp.state", "on", 1, true))
+
+	late := connect(t, endpoint)
+	messages := make(chan string, 1)
+	assert.Nil(t, late.Subscribe("devices.lamp.state", func(data []byte) {
+		messages <- string(data)
+	}))
+	assert.Equal(t, "on", receive(t, messages))
+}
+
+func TestMqtt_Will(t *testing.T) {
+	s, endpoint := runServer(t)
+	watcher := connect(t, endpoint)
+	messages := make(chan string, 1)
+	assert.Nil(t, watcher.Subscribe("devices.lamp.status", func(data []byte) {
+		messages <- string(data)
+	}))
+
+	m, _ := New(WithEndpoint(endpoint), WithClientID("lamp"), WithWill("devices.lamp.status", []byte("offline"), 1, false))
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+	//the server drops the connection without a disconnect
+	client, ok := s.Clients.Get("lamp")
+	assert.True(t, ok)
+	client.Stop(errors.New("connection lost"))
+	assert.Equal(t, "offline", receive(t, messages))
+}
+
+func TestMqtt_SharedSubscription(t *testing.T) {
+	_, endpoint := runServer(t)
+	var received int32
+	for i := 0; i < 2; i++ {
+		m, _ := New(WithEndpoint(endpoint), WithGroup("workers"))
+		assert.Nil(t, m.Subscribe("jobs", func([]byte) { atomic.AddInt32(&received, 1) }))
+		assert.Nil(t, m.Connect())
+		defer m.Close()
+	}
+
+	publisher := connect(t, endpoint)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, publisher.Publish("jobs", "job"))
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 10 }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&received))
+}
+
+func TestMqtt_SessionResumption(t *testing.T) {
+	_, endpoint := runServer(t)
+	messages := make(chan string, 10)
+	m, _ := New(WithEndpoint(endpoint), WithClientID("reader"))
+	assert.Nil(t, m.Subscribe("orders", func(data []byte) { messages <- string(data) }))
+	assert.Nil(t, m.Connect())
+	assert.Nil(t, m.Close())
+
+	publisher := connect(t, endpoint)
+	assert.Nil(t, publisher.Publish("orders", "sent while offline"))
+
+	assert.Nil(t, m.Connect())
+	defer m.Close()
+	assert.Equal(t, "sent while offline", receive(t, messages))
+}
@@ -0,0 +1,227 @@
+package nats
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateReconnected  = "reconnected"
+	StateClosed       = "closed"
+
+	errorServersEmpty   = "servers cannot be empty"
+	errorTLSNil         = "tls config cannot be nil"
+	errorTokenEmpty     = "token cannot be empty"
+	errorCredsEmpty     = "credentials file cannot be empty"
+	errorUserEmpty      = "user cannot be empty"
+	errorWaitNegative   = "reconnect wait cannot be negative"
+	errorBufferNegative = "reconnect buffer cannot be negative"
+	errorStateNil       = "state handler cannot be nil"
+)
+
+//WithServers sets the seed servers, the client connects to a random one
+//and reconnects to the others, and to the ones discovered from the cluster
+func WithServers(servers ...string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if len(servers) == 0 {
+			return errors.New(errorServersEmpty)
+		}
+		for _, server := range servers {
+			if server == "" {
+				return errors.New(errorServersEmpty)
+			}
+		}
+		n := i.(*Nats)
+		n.endpoint = strings.Join(servers, ",")
+		return nil
+	}
+}
+
+//WithTLS connects with TLS, the config can carry a client certificate
+func WithTLS(config *tls.Config) broker.Option {
+	return func(i interfaces.Broker) error {
+		if config != nil {
+			n := i.(*Nats)
+			n.options = append(n.options, nats.Secure(config))
+			return nil
+		}
+		return errors.New(errorTLSNil)
+	}
+}
+
+//WithToken authenticates with a token
+func WithToken(token string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if token != "" {
+			n := i.(*Nats)
+			n.options = append(n.options, nats.Token(token))
+			return nil
+		}
+		return errors.New(errorTokenEmpty)
+	}
+}
+
+//WithUserInfo authenticates with user and password
+func WithUserInfo(user, password string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if user != "" {
+			n := i.(*Nats)
+			n.options = append(n.options, nats.UserInfo(user, password))
+			return nil
+		}
+		return errors.New(errorUserEmpty)
+	}
+}
+
+//WithCredentials authenticates with a JWT and its nkey seed read from a .creds file
+func WithCredentials(file string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if file != "" {
+			n := i.(*Nats)
+			n.options = append(n.options, nats.UserCredentials(file))
+			return nil
+		}
+		return errors.New(errorCredsEmpty)
+	}
+}
+
+//WithNkey authenticates with the nkey seed read from file
+func WithNkey(file string) broker.Option {
+	return func(i interfaces.Broker) error {
+		option, err := nats.NkeyOptionFromSeed(file)
+		if err != nil {
+			return err
+		}
+		n := i.(*Nats)
+		n.options = append(n.options, option)
+		return nil
+	}
+}
+
+//WithReconnect sets how many times the client tries to reconnect, forever if
+//negative, waiting wait plus a random jitter between the attempts.
+//Default 60 times every 2s.
+func WithReconnect(attempts int, wait, jitter time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if wait < 0 || jitter < 0 {
+			return errors.New(errorWaitNegative)
+		}
+		n := i.(*Nats)
+		n.options = append(n.options,
+			nats.MaxReconnects(attempts),
+			nats.ReconnectWait(wait),
+			nats.ReconnectJitter(jitter, jitter),
+		)
+		return nil
+	}
+}
+
+//WithReconnectBuffer sets how many bytes of published messages are kept while
+//reconnecting, once it is full publishing fails until reconnected. Zero fails
+//every publish while reconnecting. Default 8MB.
+func WithReconnectBuffer(size int) broker.Option {
+	return func(i interfaces.Broker) error {
+		if size < 0 {
+			return errors.New(errorBufferNegative)
+		}
+		n := i.(*Nats)
+		if size == 0 {
+			//nats uses the default size for zero, a negative size disables the buffer
+			size = -1
+		}
+		n.options = append(n.options, nats.ReconnectBufSize(size))
+		return nil
+	}
+}
+
+//WithRetryOnFailedConnect makes Connect succeed when no server is reachable,
+//the client keeps connecting in background with the reconnect policy
+func WithRetryOnFailedConnect() broker.Option {
+	return func(i interfaces.Broker) error {
+		n := i.(*Nats)
+		n.options = append(n.options, nats.RetryOnFailedConnect(true))
+		return nil
+	}
+}
+
+//WithStateHandler sets a function called on every change of the connection
+//state, with the error causing a disconnection if any
+func WithStateHandler(handler func(state string, err error)) broker.Option {
+	return func(i interfaces.Broker) error {
+		if handler != nil {
+			n := i.(*Nats)
+			n.onState = handler
+			return nil
+		}
+		return errors.New(errorStateNil)
+	}
+}
+
+//connectOptions returns the options of the connection, with the handlers
+//tracking its state
+func (n *Nats) connectOptions() []nats.Option {
+	options := append([]nats.Option{}, n.options...)
+	return append(options,
+		nats.ConnectHandler(func(conn *nats.Conn) {
+			n.changeState(StateConnected, nil, conn.ConnectedUrlRedacted())
+		}),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			n.changeState(StateDisconnected, err, "")
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			n.changeState(StateReconnected, nil, conn.ConnectedUrlRedacted())
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			n.changeState(StateClosed, conn.LastError(), "")
+		}),
+		nats.ErrorHandler(func(conn *nats.Conn, subscription *nats.Subscription, err error) {
+			if errors.Is(err, nats.ErrSlowConsumer) && subscription != nil {
+				n.slowConsumer(subscription)
+				return
+			}
+			if n.Logger != nil {
+				subject := ""
+				if subscription != nil {
+					subject = subscription.Subject
+				}
+				n.Logger.Error(fmt.Sprintf("nats %s: %s", subject, err.Error()))
+			}
+		}),
+	)
+}
+
+func (n *Nats) changeState(state string, err error, server string) {
+	n.lock.Lock()
+	switch state {
+	case StateDisconnected:
+		if n.disconnected.IsZero() {
+			n.disconnected = time.Now()
+		}
+	case StateConnected, StateReconnected:
+		n.disconnected = time.Time{}
+	}
+	n.lock.Unlock()
+
+	if n.Logger != nil {
+		switch {
+		case err != nil:
+			n.Logger.Warn(fmt.Sprintf("nats %s: %s", state, err.Error()))
+		case server != "":
+			n.Logger.Info(fmt.Sprintf("nats %s to %s", state, server))
+		default:
+			n.Logger.Info(fmt.Sprintf("nats %s", state))
+		}
+	}
+	if n.onState != nil {
+		n.onState(state, err)
+	}
+}
@@ -0,0 +1,142 @@
+package nats
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+//runServerOnPort starts an embedded nats server on a given port, to restart it
+func runServerOnPort(t *testing.T, port int) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   port,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestNats_ConnectionOptions(t *testing.T) {
+	_, err := New(WithServers())
+	assert.Error(t, err)
+	_, err = New(WithServers("nats://a:4222", ""))
+	assert.Error(t, err)
+	_, err = New(WithTLS(nil))
+	assert.Error(t, err)
+	_, err = New(WithToken(""))
+	assert.Error(t, err)
+	_, err = New(WithUserInfo("", "password"))
+	assert.Error(t, err)
+	_, err = New(WithCredentials(""))
+	assert.Error(t, err)
+	_, err = New(WithNkey("missing.nk"))
+	assert.Error(t, err)
+	_, err = New(WithReconnect(10, -time.Second, 0))
+	assert.Error(t, err)
+	_, err = New(WithReconnectBuffer(-1))
+	assert.Error(t, err)
+	_, err = New(WithStateHandler(nil))
+	assert.Error(t, err)
+
+	n, err := New(WithServers("nats://a:4222", "nats://b:4222"))
+	assert.NoError(t, err)
+	assert.Equal(t, "nats://a:4222,nats://b:4222", n.endpoint)
+}
+
+func TestNats_WithToken(t *testing.T) {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true, Authorization: "secret"})
+	assert.NoError(t, err)
+	go s.Start()
+	assert.True(t, s.ReadyForConnections(5*time.Second))
+	defer s.Shutdown()
+
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.Error(t, n.Connect())
+
+	n, _ = New(WithEndpoint(s.ClientURL()), WithToken("secret"))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+}
+
+func TestNats_SubscribeAfterConnect(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	received := make(chan string, 1)
+	assert.NoError(t, n.Subscribe("late", func(msg *nats.Msg) {
+		received <- string(msg.Data)
+	}))
+	assert.NoError(t, n.Publish("late", "hello"))
+	select {
+	case data := <-received:
+		assert.Equal(t, "hello", data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestNats_Reconnect(t *testing.T) {
+	s := runServerOnPort(t, -1)
+	port := s.Addr().(*net.TCPAddr).Port
+
+	var lock sync.Mutex
+	var states []string
+	n, _ := New(
+		WithServers(s.ClientURL()),
+		WithReconnect(-1, 50*time.Millisecond, 0),
+		WithReconnectBuffer(1024),
+		WithStateHandler(func(state string, err error) {
+			lock.Lock()
+			states = append(states, state)
+			lock.Unlock()
+		}),
+	)
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	received := make(chan string, 10)
+	assert.NoError(t, n.Subscribe("buffered", func(msg *nats.Msg) {
+		received <- string(msg.Data)
+	}))
+	assert.NoError(t, n.conn.Flush())
+
+	s.Shutdown()
+	assert.Eventually(t, func() bool { return n.Check(context.Background()) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	//kept in the reconnect buffer
+	assert.NoError(t, n.Publish("buffered", "while disconnected"))
+	//filling the reconnect buffer
+	assert.NoError(t, n.Publish("buffered", make([]byte, 2048)))
+	assert.Error(t, n.Publish("buffered", "buffer full"))
+
+	runServerOnPort(t, port)
+	select {
+	case data := <-received:
+		assert.Equal(t, "while disconnected", data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered message not delivered")
+	}
+	assert.NoError(t, n.Check(context.Background()))
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Contains(t, states, StateDisconnected)
+	assert.Contains(t, states, StateReconnected)
+}
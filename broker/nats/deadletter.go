@@ -0,0 +1,76 @@
+package nats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/nats-io/nats.go"
+)
+
+const errorLimitNegative = "limit cannot be negative"
+
+//DeadLetters lists up to limit dead letters, all of them if limit is zero,
+//stored in a JetStream stream bound to the dead letter subject
+func (n *Nats) DeadLetters(stream string, limit int) ([]*broker.DeadLetter, error) {
+	if n.js == nil {
+		return nil, errors.New(errorJetStream)
+	}
+	if limit < 0 {
+		return nil, errors.New(errorLimitNegative)
+	}
+	info, err := n.js.StreamInfo(stream)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*broker.DeadLetter, 0)
+	if info.State.Msgs == 0 {
+		return letters, nil
+	}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		if limit > 0 && len(letters) >= limit {
+			break
+		}
+		msg, err := n.js.GetMsg(stream, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letter, err := n.letter(msg)
+		if err != nil {
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (n *Nats) letter(msg *nats.RawStreamMsg) (*broker.DeadLetter, error) {
+	letter, err := broker.ParseDeadLetter(header(msg.Header), msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	letter.Sequence = msg.Sequence
+	return letter, nil
+}
+
+//Replay publishes a dead letter again on its original topic and removes it from the stream
+func (n *Nats) Replay(ctx context.Context, stream string, sequence uint64) error {
+	if n.js == nil {
+		return errors.New(errorJetStream)
+	}
+	msg, err := n.js.GetMsg(stream, sequence)
+	if err != nil {
+		return err
+	}
+	letter, err := n.letter(msg)
+	if err != nil {
+		return err
+	}
+	if err := letter.Replay(ctx, n); err != nil {
+		return err
+	}
+	return n.js.DeleteMsg(stream, sequence)
+}
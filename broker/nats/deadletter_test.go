@@ -0,0 +1,85 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNats_DeadLetters(t *testing.T) {
+	s := runServer(t)
+	n, err := New(
+		WithEndpoint(s.ClientURL()),
+		WithStream(&nats.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}}),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, n.Connect())
+	defer n.Close()
+
+	replayed := make(chan []byte, 1)
+	failing := true
+	err = broker.Handle(n, "payments", func(ctx context.Context, header map[string]string, data []byte) error {
+		if failing {
+			panic("nil pointer")
+		}
+		replayed <- data
+		return nil
+	}, broker.DeadLetterQueue(n, "dlq.payments"), broker.Recover())
+	assert.Nil(t, err)
+	assert.Nil(t, n.PublishWithHeader(context.Background(), "payments", map[string]string{"Tenant": "acme"}, []byte("payment-1")))
+
+	var letters []*broker.DeadLetter
+	assert.Eventually(t, func() bool {
+		letters, err = n.DeadLetters("DLQ", 0)
+		return err == nil && len(letters) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "payments", letters[0].Topic)
+	assert.Contains(t, letters[0].Error, "panic: nil pointer")
+	assert.Equal(t, "acme", letters[0].Header["Tenant"])
+
+	failing = false
+	assert.Nil(t, n.Replay(context.Background(), "DLQ", letters[0].Sequence))
+	select {
+	case data := <-replayed:
+		assert.Equal(t, "payment-1", string(data))
+	case <-time.After(time.Second):
+		t.Fatal("dead letter not replayed")
+	}
+	letters, err = n.DeadLetters("DLQ", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, letters)
+
+	_, err = n.DeadLetters("DLQ", -1)
+	assert.NotNil(t, err)
+}
+
+func TestNats_RecoverPanic(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.Nil(t, n.Connect())
+	defer n.Close()
+
+	done := make(chan struct{})
+	calls := 0
+	err := n.SubscribeWithHeader("crash", func(context.Context, map[string]string, []byte) error {
+		calls++
+		if calls == 1 {
+			panic(errors.New("boom"))
+		}
+		close(done)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Publish("crash", "first"))
+	assert.Nil(t, n.Publish("crash", "second"))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscriber died after a panic")
+	}
+}
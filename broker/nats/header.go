@@ -0,0 +1,40 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+//PublishWithHeader publishes a payload with its headers
+func (n *Nats) PublishWithHeader(ctx context.Context, topic string, header map[string]string, data []byte) error {
+	return n.publish(ctx, topic, data, func(msg *nats.Msg) error {
+		for key, value := range header {
+			msg.Header.Set(key, value)
+		}
+		return n.conn.PublishMsg(msg)
+	})
+}
+
+//SubscribeWithHeader registers a handler receiving the headers and the payload of
+//the messages. If the broker is already connected it subscribes immediately.
+func (n *Nats) SubscribeWithHeader(topic string, handler func(context.Context, map[string]string, []byte) error) error {
+	if handler == nil {
+		return errors.New(errorHandlerNil)
+	}
+	return n.subscribe(topic, n.wrap(topic, func(ctx context.Context, msg *nats.Msg) {
+		if err := handler(ctx, header(msg.Header), msg.Data); err != nil && n.Logger != nil {
+			n.Logger.Warn(fmt.Sprintf("%s: %s", topic, err.Error()))
+		}
+	}), nil)
+}
+
+func header(h nats.Header) map[string]string {
+	header := make(map[string]string, len(h))
+	for key := range h {
+		header[key] = h.Get(key)
+	}
+	return header
+}
@@ -0,0 +1,38 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNats_PublishSubscribeEnvelope(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.Nil(t, n.Connect())
+	defer n.Close()
+	assert.NotNil(t, n.SubscribeWithHeader("orders", nil))
+
+	type order struct {
+		ID string `json:"id"`
+	}
+	received := make(chan *broker.Envelope, 1)
+	err := broker.Subscribe(n, "orders", func(ctx context.Context, e *broker.Envelope, o order) error {
+		assert.Equal(t, "1", o.ID)
+		received <- e
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, broker.Publish(context.Background(), n, "orders", order{ID: "1"}, broker.WithID("order-1")))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, "order-1", e.ID)
+		assert.Equal(t, "application/json", e.ContentType)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
@@ -0,0 +1,203 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	errorStreamNil       = "stream config cannot be nil"
+	errorStreamName      = "stream name cannot be empty"
+	errorConsumerNil     = "consumer cannot be nil"
+	errorConsumerDurable = "consumer durable name cannot be empty"
+	errorConsumerSubject = "consumer subject cannot be empty"
+	errorJetStream       = "jetstream is not enabled, use EnableJetStream or WithStream"
+)
+
+//Consumer describes a durable JetStream consumer.
+//Messages are not acknowledged automatically: the handler must call
+//msg.Ack(), msg.Nak(), msg.NakWithDelay(), msg.InProgress() or msg.Term().
+//Unacknowledged messages are redelivered after AckWait, waiting BackOff
+//between attempts, up to MaxDeliver times.
+type Consumer struct {
+	Stream        string
+	Durable       string
+	Subject       string
+	Queue         string
+	Pull          bool
+	Batch         int
+	MaxDeliver    int
+	BackOff       []time.Duration
+	AckWait       time.Duration
+	MaxAckPending int
+}
+
+type consumer struct {
+	*Consumer
+	handler      func(*nats.Msg)
+	subscription *nats.Subscription
+}
+
+//EnableJetStream uses JetStream for PublishWithID and Consume
+//without provisioning any stream
+func EnableJetStream() broker.Option {
+	return func(i interfaces.Broker) error {
+		n := i.(*Nats)
+		n.jetstream = true
+		return nil
+	}
+}
+
+//WithStream creates the stream on Connect, or updates it if it already exists.
+//It enables JetStream.
+func WithStream(config *nats.StreamConfig) broker.Option {
+	return func(i interfaces.Broker) error {
+		if config == nil {
+			return errors.New(errorStreamNil)
+		}
+		if config.Name == "" {
+			return errors.New(errorStreamName)
+		}
+		n := i.(*Nats)
+		n.jetstream = true
+		n.streams = append(n.streams, config)
+		return nil
+	}
+}
+
+//provision creates or updates the configured streams
+func (n *Nats) provision() error {
+	for _, config := range n.streams {
+		_, err := n.js.StreamInfo(config.Name)
+		switch {
+		case errors.Is(err, nats.ErrStreamNotFound):
+			_, err = n.js.AddStream(config)
+		case err == nil:
+			_, err = n.js.UpdateStream(config)
+		}
+		if err != nil {
+			return fmt.Errorf("stream %s: %w", config.Name, err)
+		}
+	}
+	return nil
+}
+
+//Consume registers a durable consumer. The handler can be a func(*nats.Msg)
+//or a func(context.Context, *nats.Msg). If the broker is already connected
+//the consumer starts immediately, otherwise on Connect.
+func (n *Nats) Consume(c *Consumer, handler interface{}) error {
+	if c == nil {
+		return errors.New(errorConsumerNil)
+	}
+	if c.Durable == "" {
+		return errors.New(errorConsumerDurable)
+	}
+	if c.Subject == "" {
+		return errors.New(errorConsumerSubject)
+	}
+	f, err := handlerFunc(handler)
+	if err != nil {
+		return err
+	}
+	if c.Batch <= 0 {
+		c.Batch = 10
+	}
+	n.jetstream = true
+	cons := &consumer{
+		Consumer: c,
+		handler:  n.wrap(c.Subject, f),
+	}
+	n.consumers = append(n.consumers, cons)
+	if n.js != nil {
+		return n.start(cons)
+	}
+	return nil
+}
+
+func (c *consumer) options() []nats.SubOpt {
+	options := []nats.SubOpt{nats.ManualAck(), nats.AckExplicit()}
+	if c.Stream != "" {
+		options = append(options, nats.BindStream(c.Stream))
+	}
+	if c.MaxDeliver > 0 {
+		options = append(options, nats.MaxDeliver(c.MaxDeliver))
+	}
+	if len(c.BackOff) > 0 {
+		options = append(options, nats.BackOff(c.BackOff))
+	}
+	if c.AckWait > 0 {
+		options = append(options, nats.AckWait(c.AckWait))
+	}
+	if c.MaxAckPending > 0 {
+		options = append(options, nats.MaxAckPending(c.MaxAckPending))
+	}
+	return options
+}
+
+//start subscribes a consumer, push consumers receive the messages on the
+//nats callback while pull consumers fetch them in batches
+func (n *Nats) start(c *consumer) error {
+	var err error
+	if c.Pull {
+		c.subscription, err = n.js.PullSubscribe(c.Subject, c.Durable, c.options()...)
+		if err != nil {
+			return err
+		}
+		go n.pull(c)
+		return nil
+	}
+
+	options := append(c.options(), nats.Durable(c.Durable))
+	if c.Queue != "" {
+		c.subscription, err = n.js.QueueSubscribe(c.Subject, c.Queue, c.handler, options...)
+	} else {
+		c.subscription, err = n.js.Subscribe(c.Subject, c.handler, options...)
+	}
+	return err
+}
+
+func (n *Nats) pull(c *consumer) {
+	for c.subscription.IsValid() {
+		msgs, err := c.subscription.Fetch(c.Batch, nats.MaxWait(time.Second))
+		switch {
+		case err == nil:
+			for _, msg := range msgs {
+				c.handler(msg)
+			}
+		case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		case errors.Is(err, nats.ErrConnectionClosed), errors.Is(err, nats.ErrBadSubscription):
+			return
+		default:
+			if n.Logger != nil {
+				n.Logger.Warn(fmt.Sprintf("consumer %s: %s", c.Durable, err.Error()))
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+//PublishWithID publishes a message on a JetStream stream waiting for the
+//acknowledgement of the server. Messages with the same id published within
+//the duplicates window of the stream are stored only once.
+func (n *Nats) PublishWithID(ctx context.Context, topic, id string, message interface{}) error {
+	if n.js == nil {
+		return errors.New(errorJetStream)
+	}
+	return n.publish(ctx, topic, message, func(msg *nats.Msg) error {
+		options := make([]nats.PubOpt, 0)
+		if _, ok := ctx.Deadline(); ok {
+			options = append(options, nats.Context(ctx))
+		}
+		if id != "" {
+			options = append(options, nats.MsgId(id))
+		}
+		_, err := n.js.PublishMsg(msg, options...)
+		return err
+	})
+}
@@ -0,0 +1,127 @@
+package nats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJetStream(t *testing.T) *Nats {
+	s := runServer(t)
+	n, err := New(
+		WithEndpoint(s.ClientURL()),
+		WithStream(&nats.StreamConfig{
+			Name:       "ORDERS",
+			Subjects:   []string{"orders.>"},
+			Duplicates: time.Minute,
+		}),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, n.Connect())
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestNats_WithStream(t *testing.T) {
+	_, err := New(WithStream(nil))
+	assert.NotNil(t, err)
+	_, err = New(WithStream(&nats.StreamConfig{}))
+	assert.NotNil(t, err)
+
+	n := newJetStream(t)
+	info, err := n.js.StreamInfo("ORDERS")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders.>"}, info.Config.Subjects)
+
+	// provisioning twice updates the existing stream
+	assert.Nil(t, n.provision())
+}
+
+func TestNats_PublishWithID(t *testing.T) {
+	n := newJetStream(t)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, n.PublishWithID(context.Background(), "orders.created", "order-1", "payload"))
+	}
+	assert.Nil(t, n.PublishWithID(context.Background(), "orders.created", "order-2", "payload"))
+	info, err := n.js.StreamInfo("ORDERS")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+
+	plain, _ := New()
+	assert.NotNil(t, plain.PublishWithID(context.Background(), "orders.created", "order-3", "payload"))
+}
+
+func TestNats_ConsumePush(t *testing.T) {
+	n := newJetStream(t)
+	var deliveries int32
+	done := make(chan struct{})
+	err := n.Consume(&Consumer{
+		Stream:     "ORDERS",
+		Durable:    "push",
+		Subject:    "orders.created",
+		MaxDeliver: 3,
+		BackOff:    []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+	}, func(msg *nats.Msg) {
+		if atomic.AddInt32(&deliveries, 1) < 3 {
+			msg.Nak()
+			return
+		}
+		msg.Ack()
+		close(done)
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, n.PublishWithID(context.Background(), "orders.created", "order-1", "payload"))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not redelivered")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&deliveries))
+}
+
+func TestNats_ConsumePull(t *testing.T) {
+	n := newJetStream(t)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, n.Publish("orders.shipped", "payload"))
+	}
+
+	received := make(chan *nats.Msg, 5)
+	err := n.Consume(&Consumer{
+		Stream:  "ORDERS",
+		Durable: "pull",
+		Subject: "orders.shipped",
+		Pull:    true,
+		Batch:   2,
+	}, func(ctx context.Context, msg *nats.Msg) {
+		assert.Nil(t, msg.InProgress())
+		assert.Nil(t, msg.AckSync())
+		received <- msg
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		select {
+		case msg := <-received:
+			assert.Equal(t, "payload", string(msg.Data))
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+
+	info, err := n.js.ConsumerInfo("ORDERS", "pull")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, info.NumAckPending)
+}
+
+func TestNats_ConsumeInvalid(t *testing.T) {
+	n, _ := New()
+	assert.NotNil(t, n.Consume(nil, func(*nats.Msg) {}))
+	assert.NotNil(t, n.Consume(&Consumer{Subject: "orders"}, func(*nats.Msg) {}))
+	assert.NotNil(t, n.Consume(&Consumer{Durable: "durable"}, func(*nats.Msg) {}))
+	assert.NotNil(t, n.Consume(&Consumer{Durable: "durable", Subject: "orders"}, "handler"))
+}
@@ -1,10 +1,16 @@
 package nats
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/advancedlogic/box/broker"
 	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
 	"github.com/nats-io/nats.go"
 )
 
@@ -12,6 +18,14 @@ const (
 	errorEndpointEmpty         = "endpoint cannot be empty"
 	errorLoggerNil             = "logger cannot be nil"
 	errorCannotCloseConnection = "cannot close connection"
+	errorNotConnected          = "not connected"
+	errorQueueEmpty            = "queue cannot be empty"
+	errorTimeoutZero           = "timeout must be greater than zero"
+	errorProcessorNil          = "processor cannot be nil"
+	errorHandlerNil            = "handler cannot be nil"
+	errorTracerNil             = "tracer cannot be nil"
+	errorMetricsNil            = "metrics cannot be nil"
+	errorHandlerType           = "handler must be func(*nats.Msg) or func(context.Context, *nats.Msg)"
 )
 
 type Nats struct {
@@ -19,8 +33,23 @@ type Nats struct {
 
 	conn          *nats.Conn
 	endpoint      string
+	queue         string
+	timeout       time.Duration
 	handlers      map[string]func(*nats.Msg)
 	subscriptions map[string]*nats.Subscription
+	tracer        interfaces.Tracer
+	metrics       interfaces.Metrics
+	js            nats.JetStreamContext
+	jetstream     bool
+	streams       []*nats.StreamConfig
+	consumers     []*consumer
+	options       []nats.Option
+	onState       func(string, error)
+	onSlow        func(string, int)
+	pools         map[string]*pool
+
+	lock         sync.RWMutex
+	disconnected time.Time
 }
 
 func WithEndpoint(endpoint string) broker.Option {
@@ -45,11 +74,63 @@ func WithLogger(logger interfaces.Logger) broker.Option {
 	}
 }
 
+//WithTracer starts a producer span for every published message and a consumer
+//span for every received one, propagating the trace context in the message headers
+func WithTracer(tracer interfaces.Tracer) broker.Option {
+	return func(i interfaces.Broker) error {
+		if tracer != nil {
+			n := i.(*Nats)
+			n.tracer = tracer
+			return nil
+		}
+		return errors.New(errorTracerNil)
+	}
+}
+
+//WithMetrics counts the published and consumed messages and measures the handlers duration
+func WithMetrics(metrics interfaces.Metrics) broker.Option {
+	return func(i interfaces.Broker) error {
+		if metrics != nil {
+			n := i.(*Nats)
+			n.metrics = metrics
+			return nil
+		}
+		return errors.New(errorMetricsNil)
+	}
+}
+
+//WithQueue sets the queue group used by Subscribe and Respond. Default "default".
+func WithQueue(queue string) broker.Option {
+	return func(i interfaces.Broker) error {
+		if queue != "" {
+			n := i.(*Nats)
+			n.queue = queue
+			return nil
+		}
+		return errors.New(errorQueueEmpty)
+	}
+}
+
+//WithTimeout sets the timeout of Request and Gather when the context has no deadline. Default 5s.
+func WithTimeout(timeout time.Duration) broker.Option {
+	return func(i interfaces.Broker) error {
+		if timeout > 0 {
+			n := i.(*Nats)
+			n.timeout = timeout
+			return nil
+		}
+		return errors.New(errorTimeoutZero)
+	}
+}
+
 func New(options ...broker.Option) (*Nats, error) {
 	nats := &Nats{
 		endpoint:      "localhost:4222",
+		queue:         "default",
+		timeout:       5 * time.Second,
 		handlers:      make(map[string]func(*nats.Msg)),
 		subscriptions: make(map[string]*nats.Subscription),
+		pools:         make(map[string]*pool),
 	}
 	for _, option := range options {
 		if err := option(nats); err != nil {
@@ -63,42 +144,158 @@ func (n *Nats) Instance() interface{} {
 	return n.conn
 }
 
+//Connect connects to the servers and subscribes the registered handlers
 func (n *Nats) Connect() error {
-	conn, err := nats.Connect(n.endpoint)
+	conn, err := nats.Connect(n.endpoint, n.connectOptions()...)
 	if err != nil {
 		return err
 	}
+	n.lock.Lock()
 	n.conn = conn
 	for topic, handler := range n.handlers {
-		subscription, err := n.conn.QueueSubscribe(topic, "default", handler)
-		if err != nil {
+		if err := n.queueSubscribe(topic, handler); err != nil {
+			n.lock.Unlock()
+			return err
+		}
+	}
+	n.lock.Unlock()
+	if n.jetstream {
+		if n.js, err = conn.JetStream(); err != nil {
+			return err
+		}
+		if err := n.provision(); err != nil {
 			return err
 		}
-		n.subscriptions[topic] = subscription
+		for _, c := range n.consumers {
+			if err := n.start(c); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (n Nats) Publish(topic string, message interface{}) error {
-	var m []byte
-	switch message.(type) {
-	case string:
-		m = []byte(message.(string))
-	default:
-		m = message.([]byte)
+func (n *Nats) Publish(topic string, message interface{}) error {
+	return n.PublishWithContext(context.Background(), topic, message)
+}
+
+//PublishWithContext publishes a message continuing the trace found in ctx
+func (n *Nats) PublishWithContext(ctx context.Context, topic string, message interface{}) error {
+	return n.publish(ctx, topic, message, n.conn.PublishMsg)
+}
+
+//publish builds the message, with the trace context in the headers, and sends it
+func (n *Nats) publish(ctx context.Context, topic string, message interface{}, send func(*nats.Msg) error) error {
+	m, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	if n.metrics != nil {
+		n.metrics.Counter("broker_published_total", 1, map[string]string{"topic": topic})
 	}
-	return n.conn.Publish(topic, m)
+	msg := nats.NewMsg(topic)
+	msg.Data = m
+	if n.tracer == nil {
+		return send(msg)
+	}
+
+	ctx, span := n.tracer.Start(ctx, fmt.Sprintf("%s publish", topic), tracer.KindProducer)
+	defer span.End()
+	span.SetAttribute("messaging.system", "nats")
+	span.SetAttribute("messaging.destination", topic)
+	carrier := make(map[string]string)
+	n.tracer.Inject(ctx, carrier)
+	for key, value := range carrier {
+		msg.Header.Set(key, value)
+	}
+	err = send(msg)
+	span.SetError(err)
+	return err
 }
 
+//Subscribe registers a handler for the topic, subscribing immediately if already connected.
+//The handler can be a func(*nats.Msg) or a func(context.Context, *nats.Msg),
+//the latter receiving the context carrying the consumer span.
+//Messages are processed one at a time, see SubscribeConcurrent.
 func (n *Nats) Subscribe(topic string, handler interface{}) error {
-	f := handler.(func(msg *nats.Msg))
-	n.handlers[topic] = f
+	f, err := handlerFunc(handler)
+	if err != nil {
+		return err
+	}
+	return n.subscribe(topic, n.wrap(topic, f), nil)
+}
+
+func handlerFunc(handler interface{}) (func(context.Context, *nats.Msg), error) {
+	switch h := handler.(type) {
+	case func(*nats.Msg):
+		return func(_ context.Context, msg *nats.Msg) { h(msg) }, nil
+	case func(context.Context, *nats.Msg):
+		return h, nil
+	}
+	return nil, errors.New(errorHandlerType)
+}
+
+func (n *Nats) wrap(topic string, handler func(context.Context, *nats.Msg)) func(*nats.Msg) {
+	if n.metrics != nil {
+		h := handler
+		handler = func(ctx context.Context, msg *nats.Msg) {
+			start := time.Now()
+			h(ctx, msg)
+			labels := map[string]string{"topic": topic}
+			n.metrics.Counter("broker_consumed_total", 1, labels)
+			n.metrics.Histogram("broker_consume_duration_seconds", time.Since(start).Seconds(), labels)
+		}
+	}
+	return func(msg *nats.Msg) {
+		defer n.recover(topic)
+		if n.tracer == nil {
+			handler(context.Background(), msg)
+			return
+		}
+		ctx := n.tracer.Extract(context.Background(), header(msg.Header))
+		ctx, span := n.tracer.Start(ctx, fmt.Sprintf("%s receive", topic), tracer.KindConsumer)
+		defer span.End()
+		span.SetAttribute("messaging.system", "nats")
+		span.SetAttribute("messaging.destination", msg.Subject)
+		handler(ctx, msg)
+	}
+}
+
+//recover stops the panics of a handler from killing the process
+func (n *Nats) recover(topic string) {
+	if r := recover(); r != nil && n.Logger != nil {
+		n.Logger.Error(fmt.Sprintf("%s: panic: %v\n%s", topic, r, debug.Stack()))
+	}
+}
+
+//Check verifies the connection to the nats server
+func (n *Nats) Check(ctx context.Context) error {
+	if n.conn == nil {
+		return errors.New(errorNotConnected)
+	}
+	if status := n.conn.Status(); status != nats.CONNECTED {
+		n.lock.RLock()
+		disconnected := n.disconnected
+		n.lock.RUnlock()
+		if !disconnected.IsZero() {
+			return fmt.Errorf("connection is %s since %s", status, time.Since(disconnected).Round(time.Second))
+		}
+		return fmt.Errorf("connection is %s", status)
+	}
 	return nil
 }
 
-func (n Nats) Close() error {
+//Close closes the connection and stops the worker pools, waiting for the
+//messages being processed
+func (n *Nats) Close() error {
 	if n.conn != nil {
 		n.conn.Close()
+		n.lock.Lock()
+		for topic, p := range n.pools {
+			p.stop()
+			delete(n.pools, topic)
+		}
+		n.lock.Unlock()
 		return nil
 	}
 	return errors.New(errorCannotCloseConnection)
@@ -3,25 +3,50 @@ package nats
 import (
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 )
 
+//runServer starts an embedded nats server with JetStream enabled
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
 func TestNewNats(t *testing.T) {
 	n, _ := New()
 	assert.NotEqual(t, n, nil)
 }
 
 func TestNats_Connect(t *testing.T) {
-	n, _ := New()
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
 	err := n.Connect()
 	defer n.Close()
 	assert.Equal(t, err, nil)
 }
 
 func TestNats_Close(t *testing.T) {
-	n, _ := New()
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
 	err := n.Connect()
 	assert.Equal(t, err, nil)
 	err = n.Close()
@@ -30,12 +55,14 @@ func TestNats_Close(t *testing.T) {
 
 func TestNats_PublishSubscribe(t *testing.T) {
 	wg := sync.WaitGroup{}
-	n, _ := New()
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
 	err := n.Connect()
 	assert.Equal(t, err, nil)
 	defer n.Close()
+	wg.Add(1)
 	err = n.Subscribe("test", func(msg *nats.Msg) {
-		assert.Equal(t, string(msg.Data), []byte("test"))
+		assert.Equal(t, "test", string(msg.Data))
 		wg.Done()
 	})
 	assert.Equal(t, err, nil)
@@ -0,0 +1,184 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	errorConcurrencyNil = "concurrency cannot be nil"
+	errorWorkers        = "workers cannot be negative"
+	errorInFlight       = "max in flight cannot be lower than workers"
+	errorSlowHandlerNil = "slow consumer handler cannot be nil"
+)
+
+//WithSlowConsumerHandler sets a function called when a subscription drops messages
+//because its pending limits are exceeded, with the number of messages dropped so far.
+//Slow consumers are logged and counted in broker_slow_consumer_total anyway.
+func WithSlowConsumerHandler(handler func(subject string, dropped int)) broker.Option {
+	return func(i interfaces.Broker) error {
+		if handler != nil {
+			n := i.(*Nats)
+			n.onSlow = handler
+			return nil
+		}
+		return errors.New(errorSlowHandlerNil)
+	}
+}
+
+//Concurrency describes how the messages of a subscription are processed.
+//
+//Messages are dispatched to Workers goroutines. At most MaxInFlight messages are
+//dispatched and not yet processed: when the limit is reached the messages queue up
+//in the client, up to PendingMessages or PendingBytes, beyond which they are dropped
+//and the slow consumer is reported.
+//
+//Without Key the messages are processed in any order. With Key the messages with
+//the same key are processed in order by the same worker.
+type Concurrency struct {
+	//Workers processing the messages. Default 1.
+	Workers int
+	//MaxInFlight limits the messages dispatched to the workers. Default Workers.
+	MaxInFlight int
+	//PendingMessages limits the messages waiting in the client. Default 512k, negative for no limit.
+	PendingMessages int
+	//PendingBytes limits the bytes waiting in the client. Default 64MB, negative for no limit.
+	PendingBytes int
+	//Key partitions the messages among the workers, for example by a header
+	Key func(*nats.Msg) string
+}
+
+//SubscribeConcurrent registers a handler for the topic processing the messages
+//concurrently as described by c, subscribing immediately if already connected.
+//The handler can be a func(*nats.Msg) or a func(context.Context, *nats.Msg).
+func (n *Nats) SubscribeConcurrent(topic string, handler interface{}, c *Concurrency) error {
+	if c == nil {
+		return errors.New(errorConcurrencyNil)
+	}
+	//the defaults are not written to the configuration of the caller
+	copied := *c
+	c = &copied
+	if c.Workers < 0 {
+		return errors.New(errorWorkers)
+	}
+	if c.Workers == 0 {
+		c.Workers = 1
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = c.Workers
+	}
+	if c.MaxInFlight < c.Workers {
+		return errors.New(errorInFlight)
+	}
+	f, err := handlerFunc(handler)
+	if err != nil {
+		return err
+	}
+	p := newPool(c, n.wrap(topic, f))
+	return n.subscribe(topic, p.dispatch, p)
+}
+
+//pool processes the messages of a subscription with a fixed number of workers
+type pool struct {
+	*Concurrency
+	handler  func(*nats.Msg)
+	queues   []chan *nats.Msg
+	inflight chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+func newPool(c *Concurrency, handler func(*nats.Msg)) *pool {
+	p := &pool{
+		Concurrency: c,
+		handler:     handler,
+		inflight:    make(chan struct{}, c.MaxInFlight),
+		done:        make(chan struct{}),
+	}
+	//without key the workers share a queue, with key every worker has its own
+	queues := 1
+	if c.Key != nil {
+		queues = c.Workers
+	}
+	for i := 0; i < queues; i++ {
+		//the in flight limit guarantees the queues never block
+		p.queues = append(p.queues, make(chan *nats.Msg, c.MaxInFlight))
+	}
+	p.wg.Add(c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		go p.work(p.queues[i%queues])
+	}
+	return p
+}
+
+//dispatch is the nats callback, it blocks while MaxInFlight messages are being processed
+func (p *pool) dispatch(msg *nats.Msg) {
+	select {
+	case p.inflight <- struct{}{}:
+	case <-p.done:
+		return
+	}
+	queue := p.queues[0]
+	if p.Key != nil {
+		h := fnv.New32a()
+		h.Write([]byte(p.Key(msg)))
+		queue = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+	queue <- msg
+}
+
+func (p *pool) work(queue chan *nats.Msg) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case msg := <-queue:
+			p.handler(msg)
+			<-p.inflight
+		}
+	}
+}
+
+//stop stops the workers waiting for the messages being processed,
+//the messages still queued are dropped
+func (p *pool) stop() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+}
+
+//slowConsumer reports a subscription dropping messages
+func (n *Nats) slowConsumer(subscription *nats.Subscription) {
+	dropped, _ := subscription.Dropped()
+	messages, bytes, _ := subscription.Pending()
+	if n.Logger != nil {
+		n.Logger.Warn(fmt.Sprintf("nats %s: slow consumer, %d messages dropped, %d messages and %d bytes pending",
+			subscription.Subject, dropped, messages, bytes))
+	}
+	if n.metrics != nil {
+		n.metrics.Counter("broker_slow_consumer_total", 1, map[string]string{"topic": subscription.Subject})
+	}
+	if n.onSlow != nil {
+		n.onSlow(subscription.Subject, dropped)
+	}
+}
+
+//Pending returns the messages and bytes of topic waiting in the client to be dispatched
+func (n *Nats) Pending(topic string) (int, int, error) {
+	n.lock.RLock()
+	subscription, ok := n.subscriptions[topic]
+	n.lock.RUnlock()
+	if !ok {
+		return 0, 0, errors.New(errorNotConnected)
+	}
+	return subscription.Pending()
+}
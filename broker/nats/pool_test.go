@@ -0,0 +1,153 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNats_SubscribeConcurrentInvalid(t *testing.T) {
+	n, _ := New()
+	assert.Error(t, n.SubscribeConcurrent("test", func(*nats.Msg) {}, nil))
+	assert.Error(t, n.SubscribeConcurrent("test", func(*nats.Msg) {}, &Concurrency{Workers: -1}))
+	assert.Error(t, n.SubscribeConcurrent("test", func(*nats.Msg) {}, &Concurrency{Workers: 4, MaxInFlight: 2}))
+	assert.Error(t, n.SubscribeConcurrent("test", "handler", &Concurrency{}))
+	_, err := New(WithSlowConsumerHandler(nil))
+	assert.Error(t, err)
+}
+
+func TestNats_SubscribeConcurrent(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	var running, peak int32
+	wg := sync.WaitGroup{}
+	wg.Add(8)
+	err := n.SubscribeConcurrent("work", func(msg *nats.Msg) {
+		defer wg.Done()
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&peak)
+			if current <= max || atomic.CompareAndSwapInt32(&peak, max, current) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}, &Concurrency{Workers: 4})
+	assert.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, n.Publish("work", "job"))
+	}
+	wg.Wait()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&peak))
+}
+
+func TestNats_SubscribeConcurrentOrdered(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	var lock sync.Mutex
+	received := make(map[string][]string)
+	wg := sync.WaitGroup{}
+	wg.Add(30)
+	err := n.SubscribeConcurrent("orders", func(msg *nats.Msg) {
+		defer wg.Done()
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		key := msg.Header.Get("key")
+		received[key] = append(received[key], string(msg.Data))
+		lock.Unlock()
+	}, &Concurrency{
+		Workers:     3,
+		MaxInFlight: 10,
+		Key:         func(msg *nats.Msg) string { return msg.Header.Get("key") },
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			msg := nats.NewMsg("orders")
+			msg.Header.Set("key", key)
+			msg.Data = []byte{byte('0' + i)}
+			assert.NoError(t, n.conn.PublishMsg(msg))
+		}
+	}
+	wg.Wait()
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, received[key])
+	}
+}
+
+func TestNats_SlowConsumer(t *testing.T) {
+	s := runServer(t)
+	slow := make(chan int, 10)
+	n, _ := New(WithEndpoint(s.ClientURL()), WithSlowConsumerHandler(func(subject string, dropped int) {
+		assert.Equal(t, "slow", subject)
+		slow <- dropped
+	}))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	release := make(chan struct{})
+	err := n.SubscribeConcurrent("slow", func(msg *nats.Msg) {
+		<-release
+	}, &Concurrency{PendingMessages: 2})
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, n.Publish("slow", "message"))
+	}
+	select {
+	case dropped := <-slow:
+		assert.True(t, dropped > 0)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+	close(release)
+}
+
+func TestNats_Resubscribe(t *testing.T) {
+	s := runServer(t)
+	n, _ := New(WithEndpoint(s.ClientURL()))
+	assert.NoError(t, n.Connect())
+	defer n.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := &Concurrency{}
+	assert.NoError(t, n.SubscribeConcurrent("work", func(msg *nats.Msg) {
+		started <- struct{}{}
+		<-release
+	}, c))
+	assert.Equal(t, 0, c.Workers)
+	assert.NoError(t, n.Publish("work", "job"))
+	<-started
+
+	//replacing the handler waits for the message being processed without locking the broker
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- n.SubscribeConcurrent("work", func(msg *nats.Msg) {}, c)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		n.Pending("work")
+		n.Check(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broker locked while replacing the handler")
+	}
+	close(release)
+	assert.NoError(t, <-replaced)
+}
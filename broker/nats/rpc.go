@@ -0,0 +1,156 @@
+package nats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/nats-io/nats.go"
+)
+
+//deadline applies the default timeout if ctx has no deadline
+func (n *Nats) deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, n.timeout)
+}
+
+func payload(msg *nats.Msg) ([]byte, error) {
+	if e := msg.Header.Get(broker.ErrorHeader); e != "" {
+		return nil, errors.New(e)
+	}
+	return msg.Data, nil
+}
+
+//Request publishes a message and waits for the first reply
+func (n *Nats) Request(ctx context.Context, subject string, message interface{}) ([]byte, error) {
+	ctx, cancel := n.deadline(ctx)
+	defer cancel()
+	var reply *nats.Msg
+	err := n.publish(ctx, subject, message, func(msg *nats.Msg) error {
+		var err error
+		reply, err = n.conn.RequestMsgWithContext(ctx, msg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payload(reply)
+}
+
+//Gather publishes a message and collects the replies until max replies are received
+//or the context expires. Replies carrying an error are discarded.
+//Responders sharing a queue group reply once per group.
+func (n *Nats) Gather(ctx context.Context, subject string, message interface{}, max int) ([][]byte, error) {
+	ctx, cancel := n.deadline(ctx)
+	defer cancel()
+	inbox := n.conn.NewRespInbox()
+	subscription, err := n.conn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer subscription.Unsubscribe()
+
+	err = n.publish(ctx, subject, message, func(msg *nats.Msg) error {
+		msg.Reply = inbox
+		return n.conn.PublishMsg(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([][]byte, 0)
+	for max <= 0 || len(replies) < max {
+		msg, err := subscription.NextMsgWithContext(ctx)
+		if err != nil {
+			break
+		}
+		if data, err := payload(msg); err == nil {
+			replies = append(replies, data)
+		}
+	}
+	if len(replies) == 0 {
+		return nil, broker.ErrNoReplies
+	}
+	return replies, nil
+}
+
+//Respond replies to the requests on subject with the result of the processor.
+//The processor receives the payload as []byte; its error, if any, is returned
+//to the requester.
+func (n *Nats) Respond(subject string, processor interfaces.Processor) error {
+	if processor == nil {
+		return errors.New(errorProcessorNil)
+	}
+	return n.subscribe(subject, n.wrap(subject, func(ctx context.Context, msg *nats.Msg) {
+		result, err := processor.Process(msg.Data)
+		if msg.Reply == "" {
+			return
+		}
+		reply := nats.NewMsg(msg.Reply)
+		if err == nil {
+			reply.Data, err = broker.Reply(result)
+		}
+		if err != nil {
+			reply.Header.Set(broker.ErrorHeader, err.Error())
+		}
+		if err := msg.RespondMsg(reply); err != nil && n.Logger != nil {
+			n.Logger.Error(err.Error())
+		}
+	}), nil)
+}
+
+//subscribe registers a handler, subscribing it immediately if already connected.
+//The pool, if any, dispatches the messages to its workers and replaces the
+//pool of a previous handler of the topic, stopped once the lock is released
+//as it waits for the messages being processed.
+func (n *Nats) subscribe(topic string, handler func(*nats.Msg), p *pool) error {
+	n.lock.Lock()
+	if subscription, ok := n.subscriptions[topic]; ok {
+		if err := subscription.Unsubscribe(); err != nil {
+			n.lock.Unlock()
+			return err
+		}
+		delete(n.subscriptions, topic)
+	}
+	old := n.pools[topic]
+	delete(n.pools, topic)
+	n.handlers[topic] = handler
+	if p != nil {
+		n.pools[topic] = p
+	}
+	var err error
+	if n.conn != nil {
+		err = n.queueSubscribe(topic, handler)
+	}
+	n.lock.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return err
+}
+
+//queueSubscribe subscribes a handler applying the pending limits of its pool,
+//the lock must be held
+func (n *Nats) queueSubscribe(topic string, handler func(*nats.Msg)) error {
+	subscription, err := n.conn.QueueSubscribe(topic, n.queue, handler)
+	if err != nil {
+		return err
+	}
+	if p, ok := n.pools[topic]; ok && (p.PendingMessages != 0 || p.PendingBytes != 0) {
+		messages, bytes := p.PendingMessages, p.PendingBytes
+		if messages == 0 {
+			messages = nats.DefaultSubPendingMsgsLimit
+		}
+		if bytes == 0 {
+			bytes = nats.DefaultSubPendingBytesLimit
+		}
+		if err := subscription.SetPendingLimits(messages, bytes); err != nil {
+			return err
+		}
+	}
+	n.subscriptions[topic] = subscription
+	return nil
+}
@@ -0,0 +1,89 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+type echo struct {
+	prefix string
+	err    error
+}
+
+func (e *echo) Init(interfaces.Micro) error { return nil }
+func (e *echo) Close() error                { return nil }
+func (e *echo) Process(data interface{}) (interface{}, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.prefix + string(data.([]byte)), nil
+}
+
+func newRPC(t *testing.T, url string) *Nats {
+	n, err := New(WithEndpoint(url), WithTimeout(200*time.Millisecond))
+	assert.Nil(t, err)
+	assert.Nil(t, n.Connect())
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestNats_Request(t *testing.T) {
+	s := runServer(t)
+	n := newRPC(t, s.ClientURL())
+	assert.Nil(t, n.Respond("echo", &echo{prefix: "echo "}))
+	assert.Nil(t, n.Respond("fail", &echo{err: errors.New("boom")}))
+	assert.NotNil(t, n.Respond("nil", nil))
+
+	reply, err := n.Request(context.Background(), "echo", "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "echo hello", string(reply))
+
+	_, err = n.Request(context.Background(), "fail", "hello")
+	assert.EqualError(t, err, "boom")
+
+	_, err = n.Request(context.Background(), "echo", 42)
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = n.Request(ctx, "echo", "hello")
+	assert.NotNil(t, err)
+
+	start := time.Now()
+	_, err = n.Request(context.Background(), "nobody", "hello")
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestNats_Gather(t *testing.T) {
+	s := runServer(t)
+	n := newRPC(t, s.ClientURL())
+	for _, queue := range []string{"a", "b", "c"} {
+		r, err := New(WithEndpoint(s.ClientURL()), WithQueue(queue))
+		assert.Nil(t, err)
+		assert.Nil(t, r.Respond("census", &echo{prefix: queue}))
+		assert.Nil(t, r.Connect())
+		defer r.Close()
+	}
+	failing, _ := New(WithEndpoint(s.ClientURL()), WithQueue("d"))
+	assert.Nil(t, failing.Respond("census", &echo{err: errors.New("boom")}))
+	assert.Nil(t, failing.Connect())
+	defer failing.Close()
+
+	replies, err := n.Gather(context.Background(), "census", "", 0)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, replies)
+
+	replies, err = n.Gather(context.Background(), "census", "", 2)
+	assert.Nil(t, err)
+	assert.Len(t, replies, 2)
+
+	_, err = n.Gather(context.Background(), "nobody", "", 0)
+	assert.Equal(t, broker.ErrNoReplies, err)
+}
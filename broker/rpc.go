@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	//ErrorHeader carries the error returned by the processor of a responder
+	ErrorHeader = "Box-Error"
+
+	errorMessageType = "message must be string or []byte, got %T"
+	errorNoReplies   = "no replies received"
+)
+
+//ErrNoReplies is returned by Gather when nobody replied before the deadline
+var ErrNoReplies = errors.New(errorNoReplies)
+
+//Payload converts a message into bytes
+func Payload(message interface{}) ([]byte, error) {
+	switch m := message.(type) {
+	case []byte:
+		return m, nil
+	case string:
+		return []byte(m), nil
+	case nil:
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf(errorMessageType, message)
+}
+
+//Reply converts the result of a processor into the payload of a reply.
+//Values other than string and []byte are encoded as JSON.
+func Reply(result interface{}) ([]byte, error) {
+	if data, err := Payload(result); err == nil {
+		return data, nil
+	}
+	return json.Marshal(result)
+}
@@ -3,6 +3,8 @@ package ledis
 import (
 	"context"
 	"errors"
+	"sync/atomic"
+	"time"
 
 	"github.com/advancedlogic/box/cache"
 	"github.com/advancedlogic/box/interfaces"
@@ -18,6 +20,9 @@ type Ledis struct {
 	clusterClient *redis.ClusterClient
 	client        *redis.Client
 	ctx           context.Context
+	metrics       interfaces.Metrics
+	hits          uint64
+	misses        uint64
 }
 
 func WithCollection(collection string) cache.Option {
@@ -66,6 +71,18 @@ func AddEndpoints(endpoints ...string) cache.Option {
 	}
 }
 
+//WithMetrics counts hits and misses of Get and exposes the hit ratio
+func WithMetrics(metrics interfaces.Metrics) cache.Option {
+	return func(c interfaces.Cache) error {
+		if metrics != nil {
+			ledis := c.(*Ledis)
+			ledis.metrics = metrics
+			return nil
+		}
+		return errors.New("metrics cannot be nil")
+	}
+}
+
 func New(options ...cache.Option) (*Ledis, error) {
 	ledis := &Ledis{
 		endpoints: make([]string, 0),
@@ -108,6 +125,17 @@ func (l *Ledis) Connect() error {
 	return nil
 }
 
+//Check pings the redis server
+func (l *Ledis) Check(ctx context.Context) error {
+	if l.client != nil {
+		return l.client.Ping(ctx).Err()
+	}
+	if l.clusterClient != nil {
+		return l.clusterClient.Ping(ctx).Err()
+	}
+	return errors.New("not connected")
+}
+
 func (l *Ledis) Close() error {
 	if l.clusterClient != nil {
 		return l.clusterClient.Close()
@@ -135,6 +163,7 @@ func (l *Ledis) Get(key string) (interface{}, error) {
 	} else {
 		status = l.clusterClient.Get(l.ctx, key)
 	}
+	l.measure(status.Err())
 	if status.Err() != nil {
 		return nil, status.Err()
 	}
@@ -142,6 +171,27 @@ func (l *Ledis) Get(key string) (interface{}, error) {
 	return result, nil
 }
 
+func (l *Ledis) measure(err error) {
+	if l.metrics == nil {
+		return
+	}
+	result := "hit"
+	switch {
+	case err == redis.Nil:
+		result = "miss"
+		atomic.AddUint64(&l.misses, 1)
+	case err != nil:
+		result = "error"
+	default:
+		atomic.AddUint64(&l.hits, 1)
+	}
+	l.metrics.Counter("cache_requests_total", 1, map[string]string{"result": result})
+	hits := atomic.LoadUint64(&l.hits)
+	if total := hits + atomic.LoadUint64(&l.misses); total > 0 {
+		l.metrics.Gauge("cache_hit_ratio", float64(hits)/float64(total), nil)
+	}
+}
+
 func (l *Ledis) Keys() (interface{}, error) {
 	var status *redis.StringSliceCmd
 	if l.client != nil {
@@ -154,3 +204,42 @@ func (l *Ledis) Keys() (interface{}, error) {
 	}
 	return status.Val(), nil
 }
+
+//acquire sets the key if missing, or renews its ttl if it holds the owner
+var acquire = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0`)
+
+//release deletes the key if it holds the owner
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (l *Ledis) scripter() redis.Scripter {
+	if l.client != nil {
+		return l.client
+	}
+	return l.clusterClient
+}
+
+//Acquire takes the lease of key for owner for ttl, or renews it if owner already holds it
+func (l *Ledis) Acquire(key string, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := acquire.Run(l.ctx, l.scripter(), []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return acquired == 1, nil
+}
+
+//Release gives back the lease of key if owner holds it
+func (l *Ledis) Release(key string, owner string) error {
+	return release.Run(l.ctx, l.scripter(), []string{key}, owner).Err()
+}
@@ -0,0 +1,41 @@
+package ledis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedis_AcquireRelease(t *testing.T) {
+	s := miniredis.RunT(t)
+	l, err := New(AddEndpoints(s.Addr()))
+	assert.NoError(t, err)
+	assert.NoError(t, l.Connect())
+
+	acquired, err := l.Acquire("leader", "a", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	acquired, err = l.Acquire("leader", "b", time.Second)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	//renewing
+	acquired, err = l.Acquire("leader", "a", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	//releasing a lease of another owner does nothing
+	assert.NoError(t, l.Release("leader", "b"))
+	acquired, _ = l.Acquire("leader", "b", time.Second)
+	assert.False(t, acquired)
+
+	assert.NoError(t, l.Release("leader", "a"))
+	acquired, _ = l.Acquire("leader", "b", time.Second)
+	assert.True(t, acquired)
+
+	//expired
+	s.FastForward(2 * time.Second)
+	acquired, _ = l.Acquire("leader", "a", time.Second)
+	assert.True(t, acquired)
+}
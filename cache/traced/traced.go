@@ -0,0 +1,145 @@
+package traced
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/advancedlogic/box/cache"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
+)
+
+//WithCache sets the cache to be traced
+func WithCache(c interfaces.Cache) cache.Option {
+	return func(i interfaces.Cache) error {
+		if c != nil {
+			t := i.(*Traced)
+			t.cache = c
+			return nil
+		}
+		return errors.New("cache cannot be nil")
+	}
+}
+
+func WithTracer(tracer interfaces.Tracer) cache.Option {
+	return func(i interfaces.Cache) error {
+		if tracer != nil {
+			t := i.(*Traced)
+			t.tracer = tracer
+			return nil
+		}
+		return errors.New("tracer cannot be nil")
+	}
+}
+
+//Traced wraps a Cache creating a span for every Set, Get and Keys.
+//Use WithContext to make the spans children of the span of a request.
+type Traced struct {
+	cache  interfaces.Cache
+	tracer interfaces.Tracer
+	ctx    context.Context
+}
+
+func New(options ...cache.Option) (*Traced, error) {
+	t := &Traced{
+		ctx: context.Background(),
+	}
+	for _, option := range options {
+		if err := option(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.cache == nil {
+		return nil, errors.New("cache cannot be nil")
+	}
+	if t.tracer == nil {
+		return nil, errors.New("tracer cannot be nil")
+	}
+	return t, nil
+}
+
+//WithContext returns a copy of the cache whose spans are children of the span in ctx
+func (t *Traced) WithContext(ctx context.Context) *Traced {
+	return &Traced{
+		cache:  t.cache,
+		tracer: t.tracer,
+		ctx:    ctx,
+	}
+}
+
+func (t *Traced) start(operation, key string) interfaces.Span {
+	_, span := t.tracer.Start(t.ctx, fmt.Sprintf("cache %s", operation), tracer.KindClient)
+	span.SetAttribute("cache.operation", operation)
+	if key != "" {
+		span.SetAttribute("cache.key", key)
+	}
+	return span
+}
+
+func (t *Traced) Instance() interface{} {
+	return t.cache.Instance()
+}
+
+func (t *Traced) Connect() error {
+	return t.cache.Connect()
+}
+
+func (t *Traced) Close() error {
+	return t.cache.Close()
+}
+
+func (t *Traced) Set(key string, value interface{}, ttl int) error {
+	span := t.start("set", key)
+	defer span.End()
+	err := t.cache.Set(key, value, ttl)
+	span.SetError(err)
+	return err
+}
+
+func (t *Traced) Get(key string) (interface{}, error) {
+	span := t.start("get", key)
+	defer span.End()
+	value, err := t.cache.Get(key)
+	span.SetError(err)
+	return value, err
+}
+
+func (t *Traced) Keys() (interface{}, error) {
+	span := t.start("keys", "")
+	defer span.End()
+	value, err := t.cache.Keys()
+	span.SetError(err)
+	return value, err
+}
+
+//Acquire takes the lease of key if the traced cache is a Locker
+func (t *Traced) Acquire(key string, owner string, ttl time.Duration) (bool, error) {
+	span := t.start("acquire", key)
+	defer span.End()
+	locker, ok := t.cache.(interfaces.Locker)
+	if !ok {
+		err := errors.New("cache does not support locks")
+		span.SetError(err)
+		return false, err
+	}
+	acquired, err := locker.Acquire(key, owner, ttl)
+	span.SetError(err)
+	return acquired, err
+}
+
+//Release gives back the lease of key if the traced cache is a Locker
+func (t *Traced) Release(key string, owner string) error {
+	span := t.start("release", key)
+	defer span.End()
+	locker, ok := t.cache.(interfaces.Locker)
+	if !ok {
+		err := errors.New("cache does not support locks")
+		span.SetError(err)
+		return err
+	}
+	err := locker.Release(key, owner)
+	span.SetError(err)
+	return err
+}
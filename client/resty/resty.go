@@ -1,12 +1,15 @@
 package resty
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/advancedlogic/box/client"
 	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
 	"gopkg.in/resty.v1"
 )
 
@@ -21,6 +24,8 @@ type Resty struct {
 	Password    string
 	pem         string
 	key         string
+	tracer      interfaces.Tracer
+	ctx         context.Context
 }
 
 func WithUrl(url string) client.Option {
@@ -113,11 +118,38 @@ func WithX509Certificate(pem, key string) client.Option {
 	}
 }
 
+//WithTracer starts a client span for every request and
+//propagates it with the W3C traceparent header
+func WithTracer(tracer interfaces.Tracer) client.Option {
+	return func(client interfaces.Client) error {
+		if tracer != nil {
+			r := client.(*Resty)
+			r.tracer = tracer
+			return nil
+		}
+		return errors.New("tracer cannot be nil")
+	}
+}
+
+//WithContext sets the default context of the requests, used as parent of the client spans.
+//The Context variants of the methods (e.g. GETContext) take the context of each request.
+func WithContext(ctx context.Context) client.Option {
+	return func(client interfaces.Client) error {
+		if ctx != nil {
+			r := client.(*Resty)
+			r.ctx = ctx
+			return nil
+		}
+		return errors.New("context cannot be nil")
+	}
+}
+
 func New(options ...client.Option) (*Resty, error) {
 	r := &Resty{
 		QueryParams: make(map[string]string),
 		Headers:     make(map[string]string),
 		Cookies:     make(map[string]string),
+		ctx:         context.Background(),
 	}
 	for _, option := range options {
 		if err := option(r); err != nil {
@@ -167,25 +199,36 @@ func (r *Resty) render() (*resty.Request, error) {
 	return request, nil
 }
 
-func (r *Resty) GET(h interface{}) error {
+func (r *Resty) execute(ctx context.Context, method string, h interface{}) error {
+	if ctx == nil {
+		return errors.New("context cannot be nil")
+	}
 	request, err := r.render()
 	if err != nil {
 		return err
 	}
-	response, err := request.Get(r.Url)
-	if err != nil {
-		return err
+	var span interfaces.Span
+	if r.tracer != nil {
+		ctx, span = r.tracer.Start(ctx, fmt.Sprintf("HTTP %s", method), tracer.KindClient)
+		defer span.End()
+		span.SetAttribute("http.method", method)
+		span.SetAttribute("http.url", r.Url)
+		carrier := make(map[string]string)
+		r.tracer.Inject(ctx, carrier)
+		request.SetHeaders(carrier)
 	}
-	handler := h.(func(response *resty.Response) error)
-	return handler(response)
-}
+	request.SetContext(ctx)
 
-func (r *Resty) POST(h interface{}) error {
-	request, err := r.render()
-	if err != nil {
-		return err
+	response, err := request.Execute(method, r.Url)
+	if span != nil {
+		span.SetError(err)
+		if err == nil {
+			span.SetAttribute("http.status_code", response.StatusCode())
+			if response.StatusCode() >= http.StatusInternalServerError {
+				span.SetError(errors.New(response.Status()))
+			}
+		}
 	}
-	response, err := request.Post(r.Url)
 	if err != nil {
 		return err
 	}
@@ -193,28 +236,38 @@ func (r *Resty) POST(h interface{}) error {
 	return handler(response)
 }
 
+func (r *Resty) GET(h interface{}) error {
+	return r.execute(r.ctx, resty.MethodGet, h)
+}
+
+func (r *Resty) POST(h interface{}) error {
+	return r.execute(r.ctx, resty.MethodPost, h)
+}
+
 func (r *Resty) PUT(h interface{}) error {
-	request, err := r.render()
-	if err != nil {
-		return err
-	}
-	response, err := request.Put(r.Url)
-	if err != nil {
-		return err
-	}
-	handler := h.(func(response *resty.Response) error)
-	return handler(response)
+	return r.execute(r.ctx, resty.MethodPut, h)
 }
 
 func (r *Resty) DELETE(h interface{}) error {
-	request, err := r.render()
-	if err != nil {
-		return err
-	}
-	response, err := request.Delete(r.Url)
-	if err != nil {
-		return err
-	}
-	handler := h.(func(response *resty.Response) error)
-	return handler(response)
+	return r.execute(r.ctx, resty.MethodDelete, h)
+}
+
+//GETContext is GET with the context of the request, e.g. the one of the incoming call
+func (r *Resty) GETContext(ctx context.Context, h interface{}) error {
+	return r.execute(ctx, resty.MethodGet, h)
+}
+
+//POSTContext is POST with the context of the request
+func (r *Resty) POSTContext(ctx context.Context, h interface{}) error {
+	return r.execute(ctx, resty.MethodPost, h)
+}
+
+//PUTContext is PUT with the context of the request
+func (r *Resty) PUTContext(ctx context.Context, h interface{}) error {
+	return r.execute(ctx, resty.MethodPut, h)
+}
+
+//DELETEContext is DELETE with the context of the request
+func (r *Resty) DELETEContext(ctx context.Context, h interface{}) error {
+	return r.execute(ctx, resty.MethodDelete, h)
 }
@@ -3,6 +3,7 @@ package viper
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/advancedlogic/box/interfaces"
 	"github.com/fsnotify/fsnotify"
@@ -58,6 +59,9 @@ type Viper struct {
 	name     string
 	provider string
 	uri      string
+
+	lock     sync.RWMutex
+	watchers []func()
 }
 
 //New create a new configuration based on the given options
@@ -74,12 +78,12 @@ func New(options ...configuration.Option) (*Viper, error) {
 	return v, nil
 }
 
-func (v Viper) Instance() interface{} {
+func (v *Viper) Instance() interface{} {
 	return v.Viper
 }
 
 //Open one or more configuration files
-func (v Viper) Open(paths ...string) error {
+func (v *Viper) Open(paths ...string) error {
 	v.SetConfigName(v.name)
 
 	if v.provider != "" && v.uri != "" {
@@ -108,11 +112,23 @@ func (v Viper) Open(paths ...string) error {
 		if err != nil {
 			return
 		}
+		v.lock.RLock()
+		defer v.lock.RUnlock()
+		for _, watcher := range v.watchers {
+			watcher()
+		}
 	})
 
 	return nil
 }
 
+//Watch registers a function called every time the configuration changes
+func (v *Viper) Watch(watcher func()) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.watchers = append(v.watchers, watcher)
+}
+
 //Get return a configuration property given a key
 func (v *Viper) Get(key string) interface{} {
 	return v.Viper.Get(key)
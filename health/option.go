@@ -0,0 +1,5 @@
+package health
+
+import "github.com/advancedlogic/box/interfaces"
+
+type Option func(interfaces.Health) error
@@ -0,0 +1,193 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/health"
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+
+	errorTimeoutZero = "timeout must be greater than zero"
+	errorTTLNegative = "ttl cannot be negative"
+	errorCheckTime   = "check timed out"
+)
+
+//WithTimeout sets how long a single check can run. Default 5s.
+func WithTimeout(timeout time.Duration) health.Option {
+	return func(i interfaces.Health) error {
+		if timeout > 0 {
+			p := i.(*Probe)
+			p.timeout = timeout
+			return nil
+		}
+		return errors.New(errorTimeoutZero)
+	}
+}
+
+//WithTTL sets for how long the result of a check is reused. Default 5s.
+//Zero disables the cache.
+func WithTTL(ttl time.Duration) health.Option {
+	return func(i interfaces.Health) error {
+		if ttl >= 0 {
+			p := i.(*Probe)
+			p.ttl = ttl
+			return nil
+		}
+		return errors.New(errorTTLNegative)
+	}
+}
+
+//Result is the outcome of a single check
+type Result struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+//Report is the outcome of a group of checks
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type check struct {
+	name     string
+	check    func(context.Context) error
+	critical bool
+
+	lock   sync.Mutex
+	result *Result
+}
+
+//Probe implements the Health interface running the checks
+//concurrently, each one with a timeout, and caching their results
+type Probe struct {
+	timeout time.Duration
+	ttl     time.Duration
+
+	lock      sync.RWMutex
+	liveness  []*check
+	readiness []*check
+}
+
+func New(options ...health.Option) (*Probe, error) {
+	p := &Probe{
+		timeout:   5 * time.Second,
+		ttl:       5 * time.Second,
+		liveness:  make([]*check, 0),
+		readiness: make([]*check, 0),
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+//AddLivenessCheck adds a check telling if the process has to be restarted
+func (p *Probe) AddLivenessCheck(name string, f func(context.Context) error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.liveness = append(p.liveness, &check{name: name, check: f, critical: true})
+}
+
+//AddReadinessCheck adds a check telling if the process can receive traffic
+func (p *Probe) AddReadinessCheck(name string, f func(context.Context) error, critical bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.readiness = append(p.readiness, &check{name: name, check: f, critical: critical})
+}
+
+//Live runs the liveness checks
+func (p *Probe) Live(ctx context.Context) (bool, interface{}) {
+	p.lock.RLock()
+	checks := p.liveness
+	p.lock.RUnlock()
+	return p.run(ctx, checks)
+}
+
+//Ready runs the readiness checks
+func (p *Probe) Ready(ctx context.Context) (bool, interface{}) {
+	p.lock.RLock()
+	checks := p.readiness
+	p.lock.RUnlock()
+	return p.run(ctx, checks)
+}
+
+func (p *Probe) run(ctx context.Context, checks []*check) (bool, interface{}) {
+	report := &Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(checks)),
+	}
+	results := make([]Result, len(checks))
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = p.execute(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		result := results[i]
+		report.Checks[c.name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if c.critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report.Status != StatusFail, report
+}
+
+//execute runs a check unless its last result is still valid
+func (p *Probe) execute(ctx context.Context, c *check) Result {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.result != nil && time.Since(c.result.CheckedAt) < p.ttl {
+		return *c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.New(errorCheckTime)
+	}
+
+	result := &Result{
+		Status:    StatusOK,
+		Critical:  c.critical,
+		Duration:  time.Since(start).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	c.result = result
+	return *result
+}
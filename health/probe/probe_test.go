@@ -0,0 +1,53 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe_Ready(t *testing.T) {
+	p, err := New(WithTimeout(50*time.Millisecond), WithTTL(0))
+	assert.Nil(t, err)
+
+	ok, report := p.Ready(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, StatusOK, report.(*Report).Status)
+
+	p.AddReadinessCheck("cache", func(ctx context.Context) error {
+		return errors.New("unreachable")
+	}, false)
+	ok, report = p.Ready(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, StatusDegraded, report.(*Report).Status)
+	assert.Equal(t, "unreachable", report.(*Report).Checks["cache"].Error)
+
+	p.AddReadinessCheck("broker", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, true)
+	ok, report = p.Ready(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, StatusFail, report.(*Report).Status)
+	assert.Equal(t, errorCheckTime, report.(*Report).Checks["broker"].Error)
+
+	ok, _ = p.Live(context.Background())
+	assert.True(t, ok)
+}
+
+func TestProbe_Cache(t *testing.T) {
+	p, err := New(WithTTL(time.Hour))
+	assert.Nil(t, err)
+	var calls int32
+	p.AddLivenessCheck("deadlock", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	p.Live(context.Background())
+	p.Live(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -1,5 +1,7 @@
 package interfaces
 
+import "context"
+
 type Broker interface {
 	Instance() interface{}
 
@@ -8,3 +10,20 @@ type Broker interface {
 	Subscribe(string, interface{}) error
 	Close() error
 }
+
+//Requester is implemented by brokers supporting request/reply.
+//Request waits for the first reply, Gather collects up to max replies
+//(all of them if max is zero) until the context expires, Respond binds
+//a processor to a subject replying with the result of Process.
+type Requester interface {
+	Request(context.Context, string, interface{}) ([]byte, error)
+	Gather(context.Context, string, interface{}, int) ([][]byte, error)
+	Respond(string, Processor) error
+}
+
+//HeaderBroker is implemented by brokers carrying headers along with the payload.
+//Errors returned by the handler are logged by the broker.
+type HeaderBroker interface {
+	PublishWithHeader(context.Context, string, map[string]string, []byte) error
+	SubscribeWithHeader(string, func(context.Context, map[string]string, []byte) error) error
+}
@@ -1,5 +1,7 @@
 package interfaces
 
+import "time"
+
 type Cache interface {
 	Instance() interface{}
 
@@ -9,4 +11,13 @@ type Cache interface {
 	Set(string, interface{}, int) error
 	Get(string) (interface{}, error)
 	Keys() (interface{}, error)
-}
\ No newline at end of file
+}
+
+//Locker is implemented by caches supporting leases, used for leader election.
+//Acquire takes the lease of a key for an owner, or renews it if the owner
+//already holds it, returning false if another owner holds it. Release gives
+//the lease back if the owner holds it.
+type Locker interface {
+	Acquire(string, string, time.Duration) (bool, error)
+	Release(string, string) error
+}
@@ -8,3 +8,8 @@ type Configuration interface {
 	Get(string) interface{}
 	Default(string, interface{}) interface{}
 }
+
+//Watcher is implemented by configurations able to notify when they change
+type Watcher interface {
+	Watch(func())
+}
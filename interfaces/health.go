@@ -0,0 +1,19 @@
+package interfaces
+
+import "context"
+
+//Health aggregates the liveness and readiness checks of the µs.
+//Critical checks make the service not ready when they fail,
+//non critical ones are only reported.
+type Health interface {
+	AddLivenessCheck(string, func(context.Context) error)
+	AddReadinessCheck(string, func(context.Context) error, bool)
+
+	Live(context.Context) (bool, interface{})
+	Ready(context.Context) (bool, interface{})
+}
+
+//Checker is implemented by components able to verify their dependencies
+type Checker interface {
+	Check(context.Context) error
+}
@@ -1,5 +1,7 @@
 package interfaces
 
+import "time"
+
 //Logger defines the interface for logging the application
 type Logger interface {
 	Instance() interface{}
@@ -11,3 +13,12 @@ type Logger interface {
 	Fatal(string)
 }
 
+//LevelController is implemented by loggers that can change their level at runtime.
+//An empty component refers to the global level, otherwise the component
+//can be a path (e.g. broker/nats) and overrides are inherited by sub-components.
+type LevelController interface {
+	Level(string) string
+	Levels() map[string]string
+	ChangeLevel(string, string, time.Duration) error
+	ResetLevel(string) error
+}
@@ -0,0 +1,11 @@
+package interfaces
+
+//Metrics collects counters, gauges and histograms.
+//The labels of a metric must always have the same keys.
+type Metrics interface {
+	Instance() interface{}
+
+	Counter(string, float64, map[string]string) error
+	Gauge(string, float64, map[string]string) error
+	Histogram(string, float64, map[string]string) error
+}
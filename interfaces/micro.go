@@ -15,4 +15,8 @@ type Micro interface {
 	Cache() Cache
 	Client() Client
 	Store() Store
+	Tracer() Tracer
+	Metrics() Metrics
+	Health() Health
+	Secrets() Secrets
 }
@@ -0,0 +1,10 @@
+package interfaces
+
+//Secrets manages short-lived credentials, renewing them in background until
+//closed. Subscribers are called with the new data when a secret is rotated.
+type Secrets interface {
+	Get(string) (map[string]interface{}, error)
+	Subscribe(string, func(map[string]interface{})) error
+	Release(string) error
+	Close() error
+}
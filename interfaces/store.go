@@ -1,5 +1,7 @@
 package interfaces
 
+import "time"
+
 type Store interface {
 	Create(string, string, interface{}) error
 	Read(string, string) (interface{}, error)
@@ -9,3 +11,39 @@ type Store interface {
 	Query(string, ...interface{}) (interface{}, error)
 	Buckets() (interface{}, error)
 }
+
+//Scanner is implemented by the stores whose List passes the values of the keys
+//starting with a prefix to a callback: List(bucket, prefix, func([]byte)).
+//Scans reports whether it is supported, wrappers report the one of their store.
+type Scanner interface {
+	Scans() bool
+}
+
+//Versioned is implemented by stores giving every item a revision, an opaque
+//string changing at every write like an ETag, for optimistic concurrency.
+//Insert creates an item failing with store.ErrExists if the key exists,
+//ReadRevision returns an item with its revision, UpdateIf and DeleteIf write
+//an item only if its revision matches, failing with store.ErrConflict if it
+//was changed or deleted meanwhile. Insert and UpdateIf return the new revision.
+type Versioned interface {
+	Insert(string, string, interface{}) (string, error)
+	ReadRevision(string, string) (interface{}, string, error)
+	UpdateIf(string, string, interface{}, string) (string, error)
+	DeleteIf(string, string, string) error
+}
+
+//Revision describes a revision of an item kept by a Historian
+type Revision struct {
+	Revision string
+	Modified time.Time
+	Deleted  bool
+}
+
+//Historian is implemented by Versioned stores keeping the previous revisions
+//of the items. History returns the revisions of an item, the latest first,
+//ReadAt returns an item at one of its revisions.
+type Historian interface {
+	Versioned
+	History(string, string) ([]Revision, error)
+	ReadAt(string, string, string) (interface{}, error)
+}
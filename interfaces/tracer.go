@@ -0,0 +1,23 @@
+package interfaces
+
+import "context"
+
+//Tracer creates spans and propagates their context across services
+type Tracer interface {
+	Instance() interface{}
+
+	Start(context.Context, string, string) (context.Context, Span)
+	Inject(context.Context, map[string]string)
+	Extract(context.Context, map[string]string) context.Context
+	Close() error
+}
+
+//Span is a single operation within a trace
+type Span interface {
+	TraceID() string
+	SpanID() string
+
+	SetAttribute(string, interface{})
+	SetError(error)
+	End()
+}
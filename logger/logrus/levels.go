@@ -0,0 +1,224 @@
+package logrus
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	errorLevelInvalid     = "Logger level is not valid. Use info, warn, error, fatal or debug"
+	errorComponentUnknown = "component has no level override"
+)
+
+//revert keeps track of a temporary level change
+type revert struct {
+	timer    *time.Timer
+	previous string
+}
+
+//levels is the registry of the global level and of the per-component
+//overrides. It is shared between the root logger and its components.
+type levels struct {
+	sync.RWMutex
+
+	initial    string
+	global     string
+	overrides  map[string]string
+	reverts    map[string]*revert
+	root       *logrus.Logger
+	components map[string]*logrus.Logger
+}
+
+func newLevels(root *logrus.Logger, level string) *levels {
+	if _, err := logrus.ParseLevel(level); err != nil {
+		level = "info"
+	}
+	l := &levels{
+		initial:    level,
+		global:     level,
+		overrides:  make(map[string]string),
+		reverts:    make(map[string]*revert),
+		root:       root,
+		components: make(map[string]*logrus.Logger),
+	}
+	l.refresh()
+	return l
+}
+
+func parseLevel(level string) (logrus.Level, error) {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return logrus.InfoLevel, errors.New(errorLevelInvalid)
+	}
+	return lvl, nil
+}
+
+//effective returns the level of a component looking for the most specific
+//override along its path (e.g. broker/nats, then broker) and falling back
+//to the global level. Must be called holding the lock.
+func (l *levels) effective(component string) string {
+	for component != "" {
+		if level, ok := l.overrides[component]; ok {
+			return level
+		}
+		i := strings.LastIndexAny(component, "/.")
+		if i < 0 {
+			break
+		}
+		component = component[:i]
+	}
+	return l.global
+}
+
+//refresh applies the current levels to all the loggers. Must be called holding the lock.
+func (l *levels) refresh() {
+	lvl, _ := parseLevel(l.global)
+	l.root.SetLevel(lvl)
+	for component, logger := range l.components {
+		lvl, _ := parseLevel(l.effective(component))
+		logger.SetLevel(lvl)
+	}
+}
+
+//current returns the level explicitly set for a key. Must be called holding the lock.
+func (l *levels) current(component string) string {
+	if component == "" {
+		return l.global
+	}
+	return l.overrides[component]
+}
+
+//assign sets the level explicitly for a key. Must be called holding the lock.
+func (l *levels) assign(component, level string) {
+	switch {
+	case component == "":
+		l.global = level
+	case level == "":
+		delete(l.overrides, component)
+	default:
+		l.overrides[component] = level
+	}
+}
+
+func (l *levels) register(component string, logger *logrus.Logger) {
+	l.Lock()
+	defer l.Unlock()
+	l.components[component] = logger
+	l.refresh()
+}
+
+func (l *levels) level(component string) string {
+	l.RLock()
+	defer l.RUnlock()
+	return l.effective(component)
+}
+
+func (l *levels) list() map[string]string {
+	l.RLock()
+	defer l.RUnlock()
+	overrides := make(map[string]string, len(l.overrides))
+	for component, level := range l.overrides {
+		overrides[component] = level
+	}
+	return overrides
+}
+
+//change sets the level of a component. When ttl is greater than zero the
+//previous level is restored once the ttl expires.
+func (l *levels) change(component, level string, ttl time.Duration) error {
+	level = strings.ToLower(level)
+	if _, err := parseLevel(level); err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	previous := l.current(component)
+	if r, ok := l.reverts[component]; ok {
+		r.timer.Stop()
+		delete(l.reverts, component)
+		previous = r.previous
+	}
+	if ttl > 0 {
+		r := &revert{previous: previous}
+		r.timer = time.AfterFunc(ttl, func() {
+			l.Lock()
+			defer l.Unlock()
+			if l.reverts[component] != r {
+				return
+			}
+			delete(l.reverts, component)
+			l.assign(component, r.previous)
+			l.refresh()
+		})
+		l.reverts[component] = r
+	}
+	l.assign(component, level)
+	l.refresh()
+	return nil
+}
+
+//reset drops the override of a component or restores the initial global level
+func (l *levels) reset(component string) error {
+	l.Lock()
+	defer l.Unlock()
+	if r, ok := l.reverts[component]; ok {
+		r.timer.Stop()
+		delete(l.reverts, component)
+	}
+	if component == "" {
+		l.global = l.initial
+	} else {
+		if _, ok := l.overrides[component]; !ok {
+			return errors.New(errorComponentUnknown)
+		}
+		delete(l.overrides, component)
+	}
+	l.refresh()
+	return nil
+}
+
+//apply replaces the global level and the overrides with the given ones,
+//leaving untouched the temporary changes still pending
+func (l *levels) apply(global string, overrides map[string]string) error {
+	if global != "" {
+		if _, err := parseLevel(global); err != nil {
+			return err
+		}
+	}
+	for _, level := range overrides {
+		if _, err := parseLevel(level); err != nil {
+			return err
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if global != "" {
+		if r, ok := l.reverts[""]; ok {
+			r.previous = global
+		} else {
+			l.global = global
+		}
+	}
+	for component := range l.overrides {
+		if _, ok := overrides[component]; !ok {
+			if _, ok := l.reverts[component]; !ok {
+				delete(l.overrides, component)
+			}
+		}
+	}
+	for component, level := range overrides {
+		if r, ok := l.reverts[component]; ok {
+			r.previous = level
+			continue
+		}
+		l.overrides[component] = level
+	}
+	l.refresh()
+	return nil
+}
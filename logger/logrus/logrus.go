@@ -2,6 +2,9 @@ package logrus
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/advancedlogic/box/interfaces"
 	"github.com/advancedlogic/box/logger"
@@ -11,6 +14,8 @@ import (
 const (
 	errorLevelEmpty  = "Logger level cannot be empty. Use info, warn, error, fatal or debug"
 	errorFormatEmpty = "Logger format cannot be empty. Check for logrus specs for formatting"
+	errorConfigNil   = "configuration cannot be nil"
+	errorKeyEmpty    = "configuration key cannot be empty"
 )
 
 //WithLevel received a level as string.
@@ -40,13 +45,36 @@ func WithFormat(format string) logger.Option {
 	}
 }
 
+//WithConfiguration drives the levels from the given configuration.
+//The global level is read from <key>.level and the component overrides
+//from the <key>.levels map. If the configuration implements interfaces.Watcher
+//the levels are updated every time the configuration changes.
+func WithConfiguration(configuration interfaces.Configuration, key string) logger.Option {
+	return func(i interfaces.Logger) error {
+		if configuration == nil {
+			return errors.New(errorConfigNil)
+		}
+		if key == "" {
+			return errors.New(errorKeyEmpty)
+		}
+		l := i.(*Logrus)
+		l.configuration = configuration
+		l.key = key
+		return nil
+	}
+}
+
 //Logrus is a struct implementing the Logger interface
 //Basically is a wrapper around the logrus library
 type Logrus struct {
 	*logrus.Logger
 
-	level  string
-	format string
+	level         string
+	format        string
+	component     string
+	levels        *levels
+	configuration interfaces.Configuration
+	key           string
 }
 
 //New instantiate a new Logger with the given options
@@ -66,28 +94,101 @@ func New(options ...logger.Option) (*Logrus, error) {
 		l.level = "info"
 	}
 
-	switch l.level {
-	case "info":
-		l.SetLevel(logrus.InfoLevel)
-	case "warn":
-		l.SetLevel(logrus.WarnLevel)
-	case "error":
-		l.SetLevel(logrus.ErrorLevel)
-	case "fatal":
-		l.SetLevel(logrus.FatalLevel)
-	case "debug":
-		l.SetLevel(logrus.DebugLevel)
-	default:
-		l.SetLevel(logrus.InfoLevel)
-	}
+	l.levels = newLevels(l.Logger, l.level)
 
 	if l.format == "" {
 		l.format = ""
 	}
 
+	if l.configuration != nil {
+		if err := l.reload(); err != nil {
+			return nil, err
+		}
+		if watcher, ok := l.configuration.(interfaces.Watcher); ok {
+			watcher.Watch(func() {
+				if err := l.reload(); err != nil {
+					l.Error(err.Error())
+				}
+			})
+		}
+	}
+
 	return l, nil
 }
 
+//reload reads the levels from the configuration
+func (l *Logrus) reload() error {
+	global := ""
+	if level, ok := l.configuration.Get(fmt.Sprintf("%s.level", l.key)).(string); ok {
+		global = strings.ToLower(level)
+	}
+	overrides := make(map[string]string)
+	switch levels := l.configuration.Get(fmt.Sprintf("%s.levels", l.key)).(type) {
+	case map[string]interface{}:
+		for component, level := range levels {
+			overrides[component] = strings.ToLower(fmt.Sprint(level))
+		}
+	case map[string]string:
+		for component, level := range levels {
+			overrides[component] = strings.ToLower(level)
+		}
+	}
+	return l.levels.apply(global, overrides)
+}
+
+//Component returns a logger for the given component (e.g. broker/nats).
+//It shares output, formatter and hooks with the parent logger
+//but its level can be overridden at runtime with ChangeLevel.
+func (l Logrus) Component(component string) interfaces.Logger {
+	if l.component != "" {
+		component = fmt.Sprintf("%s/%s", l.component, component)
+	}
+	child := &logrus.Logger{
+		Out:          l.Logger.Out,
+		Hooks:        l.Logger.Hooks,
+		Formatter:    l.Logger.Formatter,
+		ReportCaller: l.Logger.ReportCaller,
+		Level:        l.Logger.GetLevel(),
+		ExitFunc:     l.Logger.ExitFunc,
+	}
+	l.levels.register(component, child)
+	return Logrus{
+		Logger:    child,
+		level:     l.level,
+		format:    l.format,
+		component: component,
+		levels:    l.levels,
+	}
+}
+
+//Level returns the effective level of a component, or the global one if component is empty
+func (l Logrus) Level(component string) string {
+	return l.levels.level(component)
+}
+
+//Levels returns the component overrides currently active
+func (l Logrus) Levels() map[string]string {
+	return l.levels.list()
+}
+
+//ChangeLevel changes the level of a component, or the global one if component is empty.
+//If ttl is greater than zero the previous level is restored after ttl.
+func (l Logrus) ChangeLevel(component, level string, ttl time.Duration) error {
+	return l.levels.change(component, level, ttl)
+}
+
+//ResetLevel removes the override of a component, or restores the initial global level
+func (l Logrus) ResetLevel(component string) error {
+	return l.levels.reset(component)
+}
+
+func (l Logrus) entry() *logrus.Entry {
+	if l.component != "" {
+		return l.Logger.WithField("component", l.component)
+	}
+	return logrus.NewEntry(l.Logger)
+}
+
 //Instance get the instance of the
 func (l Logrus) Instance() interface{} {
 	return l.Logger
@@ -95,25 +196,25 @@ func (l Logrus) Instance() interface{} {
 
 //Info logging level
 func (l Logrus) Info(message string) {
-	l.Logger.Info(message)
+	l.entry().Info(message)
 }
 
 //Debug logging level
 func (l Logrus) Debug(message string) {
-	l.Logger.Debug(message)
+	l.entry().Debug(message)
 }
 
 //Warn logging level
 func (l Logrus) Warn(message string) {
-	l.Logger.Warn(message)
+	l.entry().Warn(message)
 }
 
 //Error logging level
 func (l Logrus) Error(message string) {
-	l.Logger.Error(message)
+	l.entry().Error(message)
 }
 
 //Fatal logging level
 func (l Logrus) Fatal(message string) {
-	l.Logger.Fatal(message)
+	l.entry().Fatal(message)
 }
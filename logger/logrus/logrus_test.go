@@ -1,6 +1,11 @@
 package logrus
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
 
 func TestLogrus(t *testing.T) {
 	f := WithLevel("")
@@ -9,3 +14,52 @@ func TestLogrus(t *testing.T) {
 		t.Errorf("Not testing empty log level")
 	}
 }
+
+func TestLogrus_ChangeLevel(t *testing.T) {
+	l, err := New(WithLevel("info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nats := l.Component("broker/nats").(Logrus)
+	if nats.GetLevel() != logrus.InfoLevel {
+		t.Errorf("component should inherit the global level")
+	}
+
+	if err := l.ChangeLevel("broker", "debug", 0); err != nil {
+		t.Fatal(err)
+	}
+	if l.Level("broker/nats") != "debug" || nats.GetLevel() != logrus.DebugLevel {
+		t.Errorf("component should inherit the override of its parent")
+	}
+	if l.GetLevel() != logrus.InfoLevel {
+		t.Errorf("global level should not change")
+	}
+
+	if err := l.ChangeLevel("", "wrong", 0); err == nil {
+		t.Errorf("Not testing invalid log level")
+	}
+
+	if err := l.ResetLevel("broker"); err != nil {
+		t.Fatal(err)
+	}
+	if nats.GetLevel() != logrus.InfoLevel {
+		t.Errorf("component should go back to the global level")
+	}
+}
+
+func TestLogrus_ChangeLevelWithTTL(t *testing.T) {
+	l, err := New(WithLevel("warn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ChangeLevel("", "debug", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if l.GetLevel() != logrus.DebugLevel {
+		t.Errorf("level should be debug")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if l.GetLevel() != logrus.WarnLevel {
+		t.Errorf("level should be reverted to warn")
+	}
+}
@@ -0,0 +1,294 @@
+package sampler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	errorLoggerNil     = "logger cannot be nil"
+	errorIntervalZero  = "interval must be greater than zero"
+	errorFirstNegative = "first must be >= 0"
+	errorThereafter    = "thereafter must be greater than zero"
+	errorKeyNil        = "key function cannot be nil"
+	errorRegistererNil = "registerer cannot be nil"
+)
+
+var levels = []string{"debug", "info", "warn", "error"}
+
+//WithInterval sets the window in which messages are counted. Default 1s.
+func WithInterval(interval time.Duration) logger.Option {
+	return func(i interfaces.Logger) error {
+		if interval > 0 {
+			s := i.(*Sampler)
+			s.interval = interval
+			return nil
+		}
+		return errors.New(errorIntervalZero)
+	}
+}
+
+//WithFirst sets how many identical messages are logged in each interval
+//before sampling starts. Default 100.
+func WithFirst(first int) logger.Option {
+	return func(i interfaces.Logger) error {
+		if first >= 0 {
+			s := i.(*Sampler)
+			s.first = uint64(first)
+			return nil
+		}
+		return errors.New(errorFirstNegative)
+	}
+}
+
+//WithThereafter logs only one message every thereafter once the first ones
+//have been logged. Default 100.
+func WithThereafter(thereafter int) logger.Option {
+	return func(i interfaces.Logger) error {
+		if thereafter > 0 {
+			s := i.(*Sampler)
+			s.thereafter = uint64(thereafter)
+			return nil
+		}
+		return errors.New(errorThereafter)
+	}
+}
+
+//WithKey sets the function used to group messages.
+//By default messages are grouped by their exact text.
+func WithKey(key func(string) string) logger.Option {
+	return func(i interfaces.Logger) error {
+		if key != nil {
+			s := i.(*Sampler)
+			s.key = key
+			return nil
+		}
+		return errors.New(errorKeyNil)
+	}
+}
+
+//WithRegisterer exports the number of suppressed lines as the prometheus
+//counter box_logger_suppressed_total, labeled by level
+func WithRegisterer(registerer prometheus.Registerer) logger.Option {
+	return func(i interfaces.Logger) error {
+		if registerer != nil {
+			s := i.(*Sampler)
+			s.registerer = registerer
+			return nil
+		}
+		return errors.New(errorRegistererNil)
+	}
+}
+
+//counter keeps track of a message in the current interval
+type counter struct {
+	level      string
+	message    string
+	start      time.Time
+	count      uint64
+	suppressed uint64
+}
+
+//Sampler wraps a Logger limiting how many identical messages are logged.
+//The first messages of each interval are logged, then only one every
+//thereafter. At the end of the interval a summary with the number of
+//suppressed messages is logged.
+type Sampler struct {
+	interfaces.Logger
+
+	interval   time.Duration
+	first      uint64
+	thereafter uint64
+	key        func(string) string
+	registerer prometheus.Registerer
+	metric     *prometheus.CounterVec
+
+	lock       sync.Mutex
+	counters   map[string]*counter
+	suppressed map[string]*uint64
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+//New wraps the given logger with a sampler
+func New(l interfaces.Logger, options ...logger.Option) (*Sampler, error) {
+	if l == nil {
+		return nil, errors.New(errorLoggerNil)
+	}
+	s := &Sampler{
+		Logger:     l,
+		interval:   time.Second,
+		first:      100,
+		thereafter: 100,
+		key:        func(message string) string { return message },
+		counters:   make(map[string]*counter),
+		suppressed: make(map[string]*uint64),
+		done:       make(chan struct{}),
+	}
+	for _, level := range levels {
+		s.suppressed[level] = new(uint64)
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.registerer != nil {
+		s.metric = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "box",
+			Subsystem: "logger",
+			Name:      "suppressed_total",
+			Help:      "Number of log lines suppressed by the sampler.",
+		}, []string{"level"})
+		if err := s.registerer.Register(s.metric); err != nil {
+			return nil, err
+		}
+	}
+
+	go s.flusher()
+	return s, nil
+}
+
+//Close flushes the pending summaries and stops the sampler
+func (s *Sampler) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.flush(time.Time{})
+	})
+	return nil
+}
+
+//Suppressed returns the number of suppressed lines by level
+func (s *Sampler) Suppressed() map[string]uint64 {
+	suppressed := make(map[string]uint64, len(s.suppressed))
+	for level, counter := range s.suppressed {
+		suppressed[level] = atomic.LoadUint64(counter)
+	}
+	return suppressed
+}
+
+func (s *Sampler) flusher() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.flush(now)
+		}
+	}
+}
+
+//flush logs the summaries of the intervals expired before now and forgets them.
+//A zero time flushes everything.
+func (s *Sampler) flush(now time.Time) {
+	var expired []*counter
+	s.lock.Lock()
+	for key, c := range s.counters {
+		if now.IsZero() || now.Sub(c.start) >= s.interval {
+			delete(s.counters, key)
+			expired = append(expired, c)
+		}
+	}
+	s.lock.Unlock()
+	for _, c := range expired {
+		s.summary(c)
+	}
+}
+
+func (s *Sampler) summary(c *counter) {
+	if c.suppressed > 0 {
+		s.write(c.level, fmt.Sprintf("%s (repeated %d times)", c.message, c.suppressed))
+	}
+}
+
+func (s *Sampler) write(level, message string) {
+	switch level {
+	case "debug":
+		s.Logger.Debug(message)
+	case "info":
+		s.Logger.Info(message)
+	case "warn":
+		s.Logger.Warn(message)
+	case "error":
+		s.Logger.Error(message)
+	}
+}
+
+//sample returns true if the message has to be logged
+func (s *Sampler) sample(level, message string) bool {
+	key := fmt.Sprintf("%s:%s", level, s.key(message))
+	now := time.Now()
+
+	s.lock.Lock()
+	c, ok := s.counters[key]
+	var expired *counter
+	if ok && now.Sub(c.start) >= s.interval {
+		expired = c
+		ok = false
+	}
+	if !ok {
+		c = &counter{level: level, message: message, start: now}
+		s.counters[key] = c
+	}
+	c.count++
+	log := c.count <= s.first || (c.count-s.first)%s.thereafter == 0
+	if !log {
+		c.suppressed++
+	}
+	s.lock.Unlock()
+
+	if expired != nil {
+		s.summary(expired)
+	}
+	if !log {
+		atomic.AddUint64(s.suppressed[level], 1)
+		if s.metric != nil {
+			s.metric.WithLabelValues(level).Inc()
+		}
+	}
+	return log
+}
+
+//Debug logging level
+func (s *Sampler) Debug(message string) {
+	if s.sample("debug", message) {
+		s.Logger.Debug(message)
+	}
+}
+
+//Info logging level
+func (s *Sampler) Info(message string) {
+	if s.sample("info", message) {
+		s.Logger.Info(message)
+	}
+}
+
+//Warn logging level
+func (s *Sampler) Warn(message string) {
+	if s.sample("warn", message) {
+		s.Logger.Warn(message)
+	}
+}
+
+//Error logging level
+func (s *Sampler) Error(message string) {
+	if s.sample("error", message) {
+		s.Logger.Error(message)
+	}
+}
+
+//Fatal logging level, never sampled
+func (s *Sampler) Fatal(message string) {
+	s.Close()
+	s.Logger.Fatal(message)
+}
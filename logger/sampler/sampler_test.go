@@ -0,0 +1,54 @@
+package sampler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	sync.Mutex
+	messages []string
+}
+
+func (r *recorder) Instance() interface{} { return nil }
+func (r *recorder) Debug(message string)  { r.record(message) }
+func (r *recorder) Info(message string)   { r.record(message) }
+func (r *recorder) Warn(message string)   { r.record(message) }
+func (r *recorder) Error(message string)  { r.record(message) }
+func (r *recorder) Fatal(message string)  { r.record(message) }
+
+func (r *recorder) record(message string) {
+	r.Lock()
+	defer r.Unlock()
+	r.messages = append(r.messages, message)
+}
+
+func TestSampler(t *testing.T) {
+	r := &recorder{}
+	s, err := New(r, WithFirst(2), WithThereafter(5), WithInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		s.Error("boom")
+	}
+	s.Info("other")
+	if len(r.messages) != 5 {
+		t.Errorf("expected 5 messages, got %d: %v", len(r.messages), r.messages)
+	}
+	if s.Suppressed()["error"] != 8 {
+		t.Errorf("expected 8 suppressed errors, got %d", s.Suppressed()["error"])
+	}
+	s.Close()
+	last := r.messages[len(r.messages)-1]
+	if last != "boom (repeated 8 times)" {
+		t.Errorf("expected summary, got %s", last)
+	}
+}
+
+func TestSamplerNil(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Errorf("Not testing nil logger")
+	}
+}
@@ -0,0 +1,5 @@
+package metrics
+
+import "github.com/advancedlogic/box/interfaces"
+
+type Option func(interfaces.Metrics) error
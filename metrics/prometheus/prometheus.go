@@ -0,0 +1,235 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	errorNamespaceEmpty = "namespace cannot be empty"
+	errorPathEmpty      = "path cannot be empty"
+	errorBucketsEmpty   = "buckets cannot be empty"
+	errorRegistryNil    = "registry cannot be nil"
+	errorNameEmpty      = "metric name cannot be empty"
+	errorLabels         = "metric %s must always have labels %v"
+	errorType           = "metric %s is already registered as a %s"
+)
+
+//WithNamespace sets the prefix of every metric. Default box.
+func WithNamespace(namespace string) metrics.Option {
+	return func(i interfaces.Metrics) error {
+		if namespace != "" {
+			p := i.(*Prometheus)
+			p.namespace = namespace
+			return nil
+		}
+		return errors.New(errorNamespaceEmpty)
+	}
+}
+
+//WithPath sets the path where the metrics are exposed. Default /metrics.
+func WithPath(path string) metrics.Option {
+	return func(i interfaces.Metrics) error {
+		if path != "" {
+			p := i.(*Prometheus)
+			p.path = path
+			return nil
+		}
+		return errors.New(errorPathEmpty)
+	}
+}
+
+//WithBuckets sets the buckets of the histograms. Default prometheus.DefBuckets.
+func WithBuckets(buckets ...float64) metrics.Option {
+	return func(i interfaces.Metrics) error {
+		if len(buckets) > 0 {
+			p := i.(*Prometheus)
+			p.buckets = buckets
+			return nil
+		}
+		return errors.New(errorBucketsEmpty)
+	}
+}
+
+//WithRegistry uses the given registry instead of a new one,
+//useful to expose in the same endpoint the metrics of other libraries
+func WithRegistry(registry *prometheus.Registry) metrics.Option {
+	return func(i interfaces.Metrics) error {
+		if registry != nil {
+			p := i.(*Prometheus)
+			p.registry = registry
+			return nil
+		}
+		return errors.New(errorRegistryNil)
+	}
+}
+
+type vector struct {
+	kind      string
+	labels    []string
+	collector prometheus.Collector
+}
+
+//Prometheus implements the Metrics interface on top of the prometheus client.
+//Metrics are registered the first time they are used.
+type Prometheus struct {
+	namespace string
+	path      string
+	buckets   []float64
+	registry  *prometheus.Registry
+	handler   http.Handler
+
+	lock    sync.RWMutex
+	vectors map[string]*vector
+}
+
+func New(options ...metrics.Option) (*Prometheus, error) {
+	p := &Prometheus{
+		namespace: "box",
+		path:      "/metrics",
+		buckets:   prometheus.DefBuckets,
+		vectors:   make(map[string]*vector),
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	if p.registry == nil {
+		p.registry = prometheus.NewRegistry()
+		p.registry.MustRegister(
+			prometheus.NewGoCollector(),
+			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		)
+	}
+	p.handler = promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+	return p, nil
+}
+
+//Instance returns the prometheus registry
+func (p *Prometheus) Instance() interface{} {
+	return p.registry
+}
+
+//Path returns the path where the metrics should be exposed
+func (p *Prometheus) Path() string {
+	return p.path
+}
+
+//ServeHTTP exposes the metrics in the prometheus text format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+func keys(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//vector returns the collector of a metric registering it if needed
+func (p *Prometheus) vector(kind, name string, labels map[string]string) (prometheus.Collector, error) {
+	if name == "" {
+		return nil, errors.New(errorNameEmpty)
+	}
+	names := keys(labels)
+
+	p.lock.RLock()
+	v, ok := p.vectors[name]
+	p.lock.RUnlock()
+	if !ok {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if v, ok = p.vectors[name]; !ok {
+			help := strings.Replace(name, "_", " ", -1)
+			v = &vector{kind: kind, labels: names}
+			switch kind {
+			case "counter":
+				v.collector = prometheus.NewCounterVec(prometheus.CounterOpts{
+					Namespace: p.namespace,
+					Name:      name,
+					Help:      help,
+				}, names)
+			case "gauge":
+				v.collector = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+					Namespace: p.namespace,
+					Name:      name,
+					Help:      help,
+				}, names)
+			default:
+				v.collector = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+					Namespace: p.namespace,
+					Name:      name,
+					Help:      help,
+					Buckets:   p.buckets,
+				}, names)
+			}
+			if err := p.registry.Register(v.collector); err != nil {
+				return nil, err
+			}
+			p.vectors[name] = v
+		}
+	}
+
+	if v.kind != kind {
+		return nil, fmt.Errorf(errorType, name, v.kind)
+	}
+	if strings.Join(v.labels, ",") != strings.Join(names, ",") {
+		return nil, fmt.Errorf(errorLabels, name, v.labels)
+	}
+	return v.collector, nil
+}
+
+//Counter adds value to a counter
+func (p *Prometheus) Counter(name string, value float64, labels map[string]string) error {
+	c, err := p.vector("counter", name, labels)
+	if err != nil {
+		return err
+	}
+	counter, err := c.(*prometheus.CounterVec).GetMetricWith(labels)
+	if err != nil {
+		return err
+	}
+	counter.Add(value)
+	return nil
+}
+
+//Gauge sets the value of a gauge
+func (p *Prometheus) Gauge(name string, value float64, labels map[string]string) error {
+	c, err := p.vector("gauge", name, labels)
+	if err != nil {
+		return err
+	}
+	gauge, err := c.(*prometheus.GaugeVec).GetMetricWith(labels)
+	if err != nil {
+		return err
+	}
+	gauge.Set(value)
+	return nil
+}
+
+//Histogram observes a value in a histogram
+func (p *Prometheus) Histogram(name string, value float64, labels map[string]string) error {
+	c, err := p.vector("histogram", name, labels)
+	if err != nil {
+		return err
+	}
+	histogram, err := c.(*prometheus.HistogramVec).GetMetricWith(labels)
+	if err != nil {
+		return err
+	}
+	histogram.Observe(value)
+	return nil
+}
@@ -0,0 +1,35 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheus(t *testing.T) {
+	p, err := New(WithNamespace("test"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, p.Counter("requests_total", 2, map[string]string{"topic": "a"}))
+	assert.Nil(t, p.Gauge("ratio", 0.5, nil))
+	assert.Nil(t, p.Histogram("duration_seconds", 0.1, map[string]string{"topic": "a"}))
+	assert.NotNil(t, p.Counter("requests_total", 1, map[string]string{"subject": "a"}))
+	assert.NotNil(t, p.Gauge("requests_total", 1, map[string]string{"topic": "a"}))
+	assert.NotNil(t, p.Counter("", 1, nil))
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, httptest.NewRequest("GET", p.Path(), nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	assert.Contains(t, string(body), `test_requests_total{topic="a"} 2`)
+	assert.Contains(t, string(body), `test_ratio 0.5`)
+	assert.Contains(t, string(body), `test_duration_seconds_count{topic="a"} 1`)
+}
+
+func TestPrometheus_Options(t *testing.T) {
+	_, err := New(WithPath(""))
+	assert.NotNil(t, err)
+	_, err = New(WithNamespace(""))
+	assert.NotNil(t, err)
+}
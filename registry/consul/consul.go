@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -21,6 +22,7 @@ type Client struct {
 	timeout        string
 	username       string
 	password       string
+	metrics        interfaces.Metrics
 }
 
 func WithLogger(logger interfaces.Logger) registry.Option {
@@ -102,6 +104,18 @@ func WithCredentials(username, password string) registry.Option {
 	}
 }
 
+//WithMetrics records the registrations and the healthy instances found by Service
+func WithMetrics(metrics interfaces.Metrics) registry.Option {
+	return func(i interfaces.Registry) error {
+		if metrics != nil {
+			c := i.(*Client)
+			c.metrics = metrics
+			return nil
+		}
+		return errors.New("metrics cannot be nil")
+	}
+}
+
 func New(options ...registry.Option) (*Client, error) {
 	client := &Client{
 		address:        "localhost:8500",
@@ -109,7 +123,7 @@ func New(options ...registry.Option) (*Client, error) {
 		password:       "",
 		interval:       "3s",
 		timeout:        "5s",
-		healthEndpoint: "http://localhost:8080/healthcheck",
+		healthEndpoint: "http://localhost:8080/readyz",
 	}
 	for _, option := range options {
 		option(client)
@@ -149,7 +163,7 @@ func (c *Client) Register(name string) error {
 
 	if c.healthEndpoint != "" {
 		registration.Check = new(api.AgentServiceCheck)
-		registration.Check.HTTP = fmt.Sprintf(c.healthEndpoint)
+		registration.Check.HTTP = c.healthEndpoint
 		registration.Check.Interval = c.interval
 		registration.Check.Timeout = c.timeout
 	
@@ -166,6 +180,19 @@ func (c *Client) Register(name string) error {
 		c.Logger.Warn(fmt.Sprintf("Attempt nr.%d failed with error %s\n", counter, err.Error()))
 		time.Sleep(time.Duration(counter) * time.Second)
 	}
+	if c.metrics != nil {
+		registered := 0.0
+		if err == nil {
+			registered = 1
+		}
+		c.metrics.Gauge("registry_registered", registered, map[string]string{"service": name})
+	}
+	return err
+}
+
+// Check verifies that the consul agent is reachable
+func (c *Client) Check(ctx context.Context) error {
+	_, err := c.Client.Agent().Self()
 	return err
 }
 
@@ -178,6 +205,9 @@ func (c *Client) DeRegister(id string) error {
 func (c *Client) Service(service, tag string) (interface{}, interface{}, error) {
 	passingOnly := true
 	addrs, meta, err := c.Client.Health().Service(service, tag, passingOnly, nil)
+	if c.metrics != nil && err == nil {
+		c.metrics.Gauge("registry_healthy_instances", float64(len(addrs)), map[string]string{"service": service})
+	}
 	if len(addrs) == 0 && err == nil {
 		return nil, nil, fmt.Errorf("service ( %s ) was not found", service)
 	}
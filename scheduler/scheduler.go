@@ -0,0 +1,449 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/commons"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	errorStoreNil       = "store cannot be nil"
+	errorBrokerNil      = "broker cannot be nil"
+	errorLockerNil      = "locker cannot be nil"
+	errorBucketEmpty    = "bucket cannot be empty"
+	errorIntervalZero   = "interval must be greater than zero"
+	errorLeaseZero      = "lease must be greater than zero"
+	errorLease          = "lease must be greater than the interval"
+	errorLoggerNil      = "logger cannot be nil"
+	errorIDEmpty        = "id cannot be empty"
+	errorTopicEmpty     = "topic cannot be empty"
+	errorAlreadyStarted = "scheduler already started"
+	errorNotStarted     = "scheduler not started"
+	errorUnexpectedJob  = "unexpected job: %s"
+)
+
+type Option func(*Scheduler) error
+
+//WithStore sets the store persisting the pending jobs
+func WithStore(s interfaces.Store) Option {
+	return func(scheduler *Scheduler) error {
+		if s != nil {
+			scheduler.store = s
+			return nil
+		}
+		return errors.New(errorStoreNil)
+	}
+}
+
+//WithBroker sets the broker the due jobs are published to
+func WithBroker(b interfaces.Broker) Option {
+	return func(scheduler *Scheduler) error {
+		if b != nil {
+			scheduler.broker = b
+			return nil
+		}
+		return errors.New(errorBrokerNil)
+	}
+}
+
+//WithLocker elects a leader among the replicas sharing the bucket, only the
+//leader fires the jobs. Without locker every replica fires them.
+func WithLocker(locker interfaces.Locker) Option {
+	return func(scheduler *Scheduler) error {
+		if locker != nil {
+			scheduler.locker = locker
+			return nil
+		}
+		return errors.New(errorLockerNil)
+	}
+}
+
+//WithBucket sets the bucket of the jobs. Default scheduler.
+func WithBucket(bucket string) Option {
+	return func(scheduler *Scheduler) error {
+		if bucket != "" {
+			scheduler.bucket = bucket
+			return nil
+		}
+		return errors.New(errorBucketEmpty)
+	}
+}
+
+//WithInterval sets how often the due jobs are looked for. Default 1s.
+func WithInterval(interval time.Duration) Option {
+	return func(scheduler *Scheduler) error {
+		if interval > 0 {
+			scheduler.interval = interval
+			return nil
+		}
+		return errors.New(errorIntervalZero)
+	}
+}
+
+//WithLease sets how long the leadership lasts without being renewed,
+//that is how long the jobs wait when the leader dies. Default 10s.
+func WithLease(lease time.Duration) Option {
+	return func(scheduler *Scheduler) error {
+		if lease > 0 {
+			scheduler.lease = lease
+			return nil
+		}
+		return errors.New(errorLeaseZero)
+	}
+}
+
+//WithLogger sets where the failed elections and the unreadable jobs are logged
+func WithLogger(logger interfaces.Logger) Option {
+	return func(scheduler *Scheduler) error {
+		if logger != nil {
+			scheduler.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//Job is a message to be published when due, again and again if it has a cron expression
+type Job struct {
+	ID    string    `json:"id"`
+	Topic string    `json:"topic"`
+	Data  []byte    `json:"data"`
+	Cron  string    `json:"cron,omitempty"`
+	Due   time.Time `json:"due"`
+}
+
+//Scheduler publishes messages at a given time or periodically.
+//
+//The jobs are persisted in a Store, so that they survive restarts, and are
+//published with at-least-once delivery: a job is removed, or moved to its next
+//occurrence, after being published. Brokers carrying headers receive the
+//id of the occurrence in the broker.IDHeader to deduplicate the messages.
+//
+//Recurring jobs fire once for the occurrences missed while no replica was running.
+type Scheduler struct {
+	interfaces.Logger
+
+	store    interfaces.Store
+	broker   interfaces.Broker
+	locker   interfaces.Locker
+	bucket   string
+	interval time.Duration
+	lease    time.Duration
+	id       string
+
+	lock   sync.Mutex
+	leader bool
+	//elected is when the leadership has been taken or renewed last
+	elected time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func New(options ...Option) (*Scheduler, error) {
+	s := &Scheduler{
+		bucket:   "scheduler",
+		interval: time.Second,
+		lease:    10 * time.Second,
+		id:       commons.UUID(),
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.store == nil {
+		return nil, errors.New(errorStoreNil)
+	}
+	if s.broker == nil {
+		return nil, errors.New(errorBrokerNil)
+	}
+	if s.lease <= s.interval {
+		return nil, errors.New(errorLease)
+	}
+	return s, nil
+}
+
+//PublishAt schedules a message to be published on topic at the given time
+//and returns the id of the job
+func (s *Scheduler) PublishAt(at time.Time, topic string, message interface{}) (string, error) {
+	if topic == "" {
+		return "", errors.New(errorTopicEmpty)
+	}
+	data, err := broker.Payload(message)
+	if err != nil {
+		return "", err
+	}
+	job := &Job{
+		ID:    commons.UUID(),
+		Topic: topic,
+		Data:  data,
+		Due:   at,
+	}
+	if err := s.save(job, s.store.Create); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+//PublishAfter schedules a message to be published on topic after delay
+//and returns the id of the job
+func (s *Scheduler) PublishAfter(delay time.Duration, topic string, message interface{}) (string, error) {
+	return s.PublishAt(time.Now().Add(delay), topic, message)
+}
+
+//Schedule publishes a message on topic periodically as described by a cron
+//expression with five fields, minute hour day-of-month month day-of-week, or
+//a descriptor like @hourly or @every 10m. Scheduling an existing id replaces it,
+//keeping its next occurrence when the expression is the same, so every replica
+//can schedule the same jobs at startup.
+func (s *Scheduler) Schedule(id, spec, topic string, message interface{}) error {
+	if id == "" {
+		return errors.New(errorIDEmpty)
+	}
+	if topic == "" {
+		return errors.New(errorTopicEmpty)
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return err
+	}
+	data, err := broker.Payload(message)
+	if err != nil {
+		return err
+	}
+	job := &Job{
+		ID:    id,
+		Topic: topic,
+		Data:  data,
+		Cron:  spec,
+		Due:   schedule.Next(time.Now()),
+	}
+	if existing, err := s.job(id); err == nil {
+		if existing.Cron == spec {
+			job.Due = existing.Due
+		}
+		return s.save(job, s.store.Update)
+	}
+	return s.save(job, s.store.Create)
+}
+
+//Cancel removes a job
+func (s *Scheduler) Cancel(id string) error {
+	return s.store.Delete(s.bucket, id)
+}
+
+func (s *Scheduler) job(id string) (*Job, error) {
+	value, err := s.store.Read(s.bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	switch v := value.(type) {
+	case string:
+		err = json.Unmarshal([]byte(v), job)
+	case []byte:
+		err = json.Unmarshal(v, job)
+	default:
+		err = fmt.Errorf(errorUnexpectedJob, id)
+	}
+	return job, err
+}
+
+func (s *Scheduler) save(job *Job, write func(string, string, interface{}) error) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return write(s.bucket, job.ID, string(data))
+}
+
+//Jobs returns the pending jobs sorted by due time
+func (s *Scheduler) Jobs() ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	var failure error
+	_, err := s.store.List(s.bucket, "", func(data []byte) {
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			failure = fmt.Errorf(errorUnexpectedJob, err)
+			return
+		}
+		jobs = append(jobs, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failure != nil && s.Logger != nil {
+		s.Logger.Warn(failure.Error())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Due.Before(jobs[j].Due)
+	})
+	return jobs, nil
+}
+
+func (s *Scheduler) publish(ctx context.Context, job *Job) error {
+	if b, ok := s.broker.(interfaces.HeaderBroker); ok {
+		header := map[string]string{
+			broker.IDHeader: fmt.Sprintf("%s-%d", job.ID, job.Due.UnixNano()),
+		}
+		return b.PublishWithHeader(ctx, job.Topic, header, job.Data)
+	}
+	return s.broker.Publish(job.Topic, job.Data)
+}
+
+//Fire publishes the due jobs and returns how many have been published
+func (s *Scheduler) Fire(ctx context.Context) (int, error) {
+	return s.fire(ctx, nil)
+}
+
+//fire publishes the due jobs while held, if any, reports the leadership is still held
+func (s *Scheduler) fire(ctx context.Context, held func() bool) (int, error) {
+	jobs, err := s.Jobs()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	fired := 0
+	var failure error
+	for _, job := range jobs {
+		if job.Due.After(now) {
+			break
+		}
+		if held != nil && !held() {
+			break
+		}
+		if err := s.publish(ctx, job); err != nil {
+			failure = err
+			continue
+		}
+		fired++
+		//a failure here publishes the job again on the next run
+		if err := s.next(job, now); err != nil {
+			failure = err
+		}
+	}
+	return fired, failure
+}
+
+//next moves a recurring job to its next occurrence and removes the others
+func (s *Scheduler) next(job *Job, now time.Time) error {
+	if job.Cron == "" {
+		return s.store.Delete(s.bucket, job.ID)
+	}
+	schedule, err := cron.ParseStandard(job.Cron)
+	if err != nil {
+		return err
+	}
+	job.Due = schedule.Next(now)
+	return s.save(job, s.store.Update)
+}
+
+//Leader reports whether this replica fires the jobs
+func (s *Scheduler) Leader() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.leader
+}
+
+func (s *Scheduler) key() string {
+	return "box-scheduler-" + s.bucket
+}
+
+//elect takes or renews the leadership
+func (s *Scheduler) elect() bool {
+	leader := true
+	if s.locker != nil {
+		acquired, err := s.locker.Acquire(s.key(), s.id, s.lease)
+		if err != nil && s.Logger != nil {
+			s.Logger.Warn(fmt.Sprintf("scheduler election: %s", err.Error()))
+		}
+		leader = acquired && err == nil
+	}
+	s.lock.Lock()
+	if leader != s.leader && s.Logger != nil {
+		if leader {
+			s.Logger.Info(fmt.Sprintf("scheduler %s elected leader", s.id))
+		} else {
+			s.Logger.Info(fmt.Sprintf("scheduler %s not leader", s.id))
+		}
+	}
+	s.leader = leader
+	if leader {
+		s.elected = time.Now()
+	}
+	s.lock.Unlock()
+	return leader
+}
+
+//held renews the leadership once an interval has passed since the last
+//election, so that a long run stops publishing when the lease is lost
+func (s *Scheduler) held() bool {
+	s.lock.Lock()
+	elected := s.elected
+	s.lock.Unlock()
+	if time.Since(elected) < s.interval {
+		return true
+	}
+	return s.elect()
+}
+
+//Start fires the due jobs in background every interval while leader
+func (s *Scheduler) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stop != nil {
+		return errors.New(errorAlreadyStarted)
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if s.elect() {
+				if _, err := s.fire(context.Background(), s.held); err != nil && s.Logger != nil {
+					s.Logger.Warn(fmt.Sprintf("scheduler: %s", err.Error()))
+				}
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(s.stop, s.done)
+	return nil
+}
+
+//Stop stops firing the jobs, waiting for the current run to complete,
+//and gives the leadership up
+func (s *Scheduler) Stop() error {
+	s.lock.Lock()
+	if s.stop == nil {
+		s.lock.Unlock()
+		return errors.New(errorNotStarted)
+	}
+	close(s.stop)
+	done := s.done
+	s.stop = nil
+	s.lock.Unlock()
+	<-done
+
+	s.lock.Lock()
+	s.leader = false
+	s.lock.Unlock()
+	if s.locker != nil {
+		return s.locker.Release(s.key(), s.id)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/broker/memory"
+	"github.com/advancedlogic/box/cache/ledis"
+	"github.com/advancedlogic/box/store/kv/kvtest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBroker(t *testing.T) *memory.Memory {
+	b, _ := memory.New()
+	assert.NoError(t, b.Connect())
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.Error(t, err)
+	_, err = New(WithStore(kvtest.New(t)))
+	assert.Error(t, err)
+	_, err = New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)), WithInterval(time.Second), WithLease(time.Second))
+	assert.EqualError(t, err, errorLease)
+	_, err = New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)), WithLease(0))
+	assert.EqualError(t, err, errorLeaseZero)
+	s, err := New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)))
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+}
+
+func TestScheduler_PublishAfter(t *testing.T) {
+	store := kvtest.New(t)
+	b := newBroker(t)
+	s, _ := New(WithStore(store), WithBroker(b))
+
+	received := make(chan map[string]string, 1)
+	assert.NoError(t, b.SubscribeWithHeader("reminders", func(ctx context.Context, header map[string]string, data []byte) error {
+		assert.Equal(t, "call back", string(data))
+		received <- header
+		return nil
+	}))
+
+	id, err := s.PublishAfter(50*time.Millisecond, "reminders", "call back")
+	assert.NoError(t, err)
+	fired, err := s.Fire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fired)
+
+	time.Sleep(60 * time.Millisecond)
+	//a new scheduler on the same store finds the job
+	s, _ = New(WithStore(store), WithBroker(b))
+	fired, err = s.Fire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fired)
+	select {
+	case header := <-received:
+		assert.True(t, strings.HasPrefix(header[broker.IDHeader], id))
+	case <-time.After(time.Second):
+		t.Fatal("job not published")
+	}
+	jobs, _ := s.Jobs()
+	assert.Empty(t, jobs)
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s, _ := New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)))
+	id, _ := s.PublishAt(time.Now(), "reminders", "call back")
+	assert.NoError(t, s.Cancel(id))
+	fired, _ := s.Fire(context.Background())
+	assert.Equal(t, 0, fired)
+}
+
+func TestScheduler_Schedule(t *testing.T) {
+	s, _ := New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)))
+	assert.Error(t, s.Schedule("", "@hourly", "reports", "build"))
+	assert.Error(t, s.Schedule("report", "not a cron", "reports", "build"))
+	assert.NoError(t, s.Schedule("report", "0 6 * * *", "reports", "build"))
+	//rescheduling the same expression keeps the next occurrence
+	jobs, _ := s.Jobs()
+	due := jobs[0].Due
+	assert.NoError(t, s.Schedule("report", "0 6 * * *", "reports", "rebuild"))
+	jobs, _ = s.Jobs()
+	assert.Equal(t, due.UnixNano(), jobs[0].Due.UnixNano())
+	assert.Equal(t, "rebuild", string(jobs[0].Data))
+	//replacing
+	assert.NoError(t, s.Schedule("report", "@every 1h", "reports", "build"))
+
+	jobs, err := s.Jobs()
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "@every 1h", jobs[0].Cron)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), jobs[0].Due, time.Second)
+
+	//firing moves the job to the next occurrence
+	assert.NoError(t, s.Schedule("tick", "@every 1s", "ticks", "tick"))
+	time.Sleep(1100 * time.Millisecond)
+	fired, err := s.Fire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fired)
+	jobs, _ = s.Jobs()
+	assert.Len(t, jobs, 2)
+	assert.True(t, jobs[0].Due.After(time.Now()))
+}
+
+func TestScheduler_Leader(t *testing.T) {
+	redis := miniredis.RunT(t)
+	cache, _ := ledis.New(ledis.AddEndpoints(redis.Addr()))
+	assert.NoError(t, cache.Connect())
+	store := kvtest.New(t)
+	b := newBroker(t)
+
+	var lock sync.Mutex
+	count := 0
+	assert.NoError(t, b.Subscribe("ticks", func(data []byte) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+	}))
+
+	options := []Option{WithStore(store), WithBroker(b), WithLocker(cache), WithInterval(10 * time.Millisecond), WithLease(time.Second)}
+	first, _ := New(options...)
+	second, _ := New(options...)
+	assert.NoError(t, first.Start())
+	assert.Eventually(t, first.Leader, time.Second, 10*time.Millisecond)
+	assert.NoError(t, second.Start())
+	assert.Error(t, second.Start())
+	defer second.Stop()
+
+	_, err := first.PublishAt(time.Now(), "ticks", "tick")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		jobs, _ := first.Jobs()
+		return len(jobs) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, second.Leader())
+
+	//the leadership passes to the second replica
+	assert.NoError(t, first.Stop())
+	assert.Eventually(t, second.Leader, time.Second, 10*time.Millisecond)
+	_, err = second.PublishAt(time.Now(), "ticks", "tick")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return count == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+//locker grants the lease a number of times
+type locker struct {
+	lock   sync.Mutex
+	grants int
+}
+
+func (l *locker) Acquire(key, owner string, lease time.Duration) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.grants--
+	return l.grants >= 0, nil
+}
+
+func (l *locker) Release(key, owner string) error {
+	return nil
+}
+
+func TestScheduler_LeaseLost(t *testing.T) {
+	s, _ := New(WithStore(kvtest.New(t)), WithBroker(newBroker(t)), WithLocker(&locker{grants: 1}), WithInterval(10*time.Millisecond), WithLease(time.Second))
+	for i := 0; i < 3; i++ {
+		_, err := s.PublishAt(time.Now().Add(-time.Minute), "ticks", "tick")
+		assert.NoError(t, err)
+	}
+	assert.True(t, s.elect())
+
+	//the run stops as soon as the leadership is not held
+	checks := 0
+	fired, err := s.fire(context.Background(), func() bool {
+		checks++
+		return checks <= 2
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fired)
+	jobs, _ := s.Jobs()
+	assert.Len(t, jobs, 1)
+
+	//the lease is renewed once an interval has passed, and lost here
+	assert.True(t, s.held())
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, s.held())
+	assert.False(t, s.Leader())
+	fired, _ = s.fire(context.Background(), s.held)
+	assert.Equal(t, 0, fired)
+}
@@ -0,0 +1,360 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store/vault"
+)
+
+const (
+	errorLeaserNil    = "leaser cannot be nil"
+	errorLoggerNil    = "logger cannot be nil"
+	errorThreshold    = "threshold must be between 0 and 1"
+	errorIncrement    = "increment cannot be negative"
+	errorRetry        = "retry must be greater than zero"
+	errorPathEmpty    = "path cannot be empty"
+	errorCallbackNil  = "callback cannot be nil"
+	errorClosed       = "secrets are closed"
+	errorNotRequested = "secret has not been requested"
+)
+
+//Leaser requests, renews and revokes dynamic secrets, implemented by *vault.Vault
+type Leaser interface {
+	Lease(string, map[string]interface{}) (*vault.Lease, error)
+	Renew(string, time.Duration) (time.Duration, error)
+	Revoke(string) error
+}
+
+type Option func(*Secrets) error
+
+//WithVault sets the Vault the secrets are requested to
+func WithVault(v *vault.Vault) Option {
+	return WithLeaser(v)
+}
+
+//WithLeaser sets the leaser of the secrets
+func WithLeaser(leaser Leaser) Option {
+	return func(s *Secrets) error {
+		if leaser == nil {
+			return errors.New(errorLeaserNil)
+		}
+		if v, ok := leaser.(*vault.Vault); ok && v == nil {
+			return errors.New(errorLeaserNil)
+		}
+		s.leaser = leaser
+		return nil
+	}
+}
+
+//WithThreshold sets the fraction of the duration of a lease after which it is renewed. Default 2/3.
+func WithThreshold(threshold float64) Option {
+	return func(s *Secrets) error {
+		if threshold <= 0 || threshold >= 1 {
+			return errors.New(errorThreshold)
+		}
+		s.threshold = threshold
+		return nil
+	}
+}
+
+//WithIncrement sets how long the leases are extended by, zero for the default
+//duration of their secrets. Default zero.
+func WithIncrement(increment time.Duration) Option {
+	return func(s *Secrets) error {
+		if increment < 0 {
+			return errors.New(errorIncrement)
+		}
+		s.increment = increment
+		return nil
+	}
+}
+
+//WithRetry sets how long to wait before requesting again a secret that could
+//not be renewed nor requested. Default 5s.
+func WithRetry(retry time.Duration) Option {
+	return func(s *Secrets) error {
+		if retry <= 0 {
+			return errors.New(errorRetry)
+		}
+		s.retry = retry
+		return nil
+	}
+}
+
+func WithLogger(logger interfaces.Logger) Option {
+	return func(s *Secrets) error {
+		if logger == nil {
+			return errors.New(errorLoggerNil)
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+//secret is a requested secret and the subscribers to its rotations
+type secret struct {
+	lease       *vault.Lease
+	subscribers []func(map[string]interface{})
+	stop        chan struct{}
+}
+
+//pending is a secret being requested, the concurrent requests of its path wait for it
+type pending struct {
+	done   chan struct{}
+	secret *secret
+	err    error
+}
+
+//Secrets manages short-lived credentials: it requests dynamic secrets, renews
+//their leases in background before they expire and requests them again when
+//they cannot be renewed anymore, notifying the subscribers of the new ones.
+//Close revokes every lease, Box.Stop closes the secrets of the µs.
+type Secrets struct {
+	leaser    Leaser
+	logger    interfaces.Logger
+	threshold float64
+	increment time.Duration
+	retry     time.Duration
+
+	lock    sync.Mutex
+	secrets map[string]*secret
+	pending map[string]*pending
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func New(options ...Option) (*Secrets, error) {
+	s := &Secrets{
+		threshold: 2.0 / 3.0,
+		retry:     5 * time.Second,
+		secrets:   make(map[string]*secret),
+		pending:   make(map[string]*pending),
+	}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.leaser == nil {
+		return nil, errors.New(errorLeaserNil)
+	}
+	return s, nil
+}
+
+func (s *Secrets) warn(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger.Warn(fmt.Sprintf(format, args...))
+	}
+}
+
+//request returns the secret of a path, requesting it the first time. The lease
+//is requested without the lock, once for the concurrent requests of a path.
+func (s *Secrets) request(path string) (*secret, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, errors.New(errorPathEmpty)
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil, errors.New(errorClosed)
+	}
+	if current, ok := s.secrets[path]; ok {
+		s.lock.Unlock()
+		return current, nil
+	}
+	if p, ok := s.pending[path]; ok {
+		s.lock.Unlock()
+		<-p.done
+		return p.secret, p.err
+	}
+	p := &pending{done: make(chan struct{})}
+	s.pending[path] = p
+	//Close waits for the lease to be revoked
+	s.wg.Add(1)
+	s.lock.Unlock()
+	defer s.wg.Done()
+	defer close(p.done)
+
+	lease, err := s.leaser.Lease(path, nil)
+	s.lock.Lock()
+	delete(s.pending, path)
+	if err != nil {
+		s.lock.Unlock()
+		p.err = err
+		return nil, err
+	}
+	if s.closed {
+		s.lock.Unlock()
+		s.revoke(path, lease)
+		p.err = errors.New(errorClosed)
+		return nil, p.err
+	}
+	p.secret = &secret{lease: lease, stop: make(chan struct{})}
+	s.secrets[path] = p.secret
+	//secrets without lease, like static ones, never expire
+	if lease.ID != "" && lease.Duration > 0 {
+		s.wg.Add(1)
+		go s.watch(path, p.secret)
+	}
+	s.lock.Unlock()
+	return p.secret, nil
+}
+
+//Get returns the data of a secret, requesting it the first time
+func (s *Secrets) Get(path string) (map[string]interface{}, error) {
+	current, err := s.request(path)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return current.lease.Data, nil
+}
+
+//Subscribe calls callback with the data of a secret every time it is requested
+//again, requesting it the first time. The previous lease is revoked after the
+//callbacks return.
+func (s *Secrets) Subscribe(path string, callback func(map[string]interface{})) error {
+	if callback == nil {
+		return errors.New(errorCallbackNil)
+	}
+	current, err := s.request(path)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current.subscribers = append(current.subscribers, callback)
+	return nil
+}
+
+//Lease returns the current lease of a secret
+func (s *Secrets) Lease(path string) (*vault.Lease, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.secrets[strings.Trim(path, "/")]
+	if !ok {
+		return nil, errors.New(errorNotRequested)
+	}
+	return current.lease, nil
+}
+
+//watch renews the lease of a secret until it is released, requesting the
+//secret again when the lease cannot be renewed anymore
+func (s *Secrets) watch(path string, current *secret) {
+	defer s.wg.Done()
+	s.lock.Lock()
+	lease := current.lease
+	s.lock.Unlock()
+	//the duration of a lease renewed up to the maximum of its secret gets
+	//shorter than requested, it is requested again before expiring then
+	requested := s.increment
+	if requested == 0 {
+		requested = lease.Duration
+	}
+	wait := time.Duration(float64(lease.Duration) * s.threshold)
+	for {
+		select {
+		case <-current.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		if lease.Renewable {
+			duration, err := s.leaser.Renew(lease.ID, s.increment)
+			if err == nil && duration >= requested {
+				wait = time.Duration(float64(duration) * s.threshold)
+				continue
+			}
+			if err != nil {
+				s.warn("secrets: renewing %s: %s", path, err)
+			}
+		}
+
+		renewed, err := s.leaser.Lease(path, nil)
+		if err != nil {
+			s.warn("secrets: requesting %s: %s", path, err)
+			wait = s.retry
+			continue
+		}
+		s.lock.Lock()
+		select {
+		case <-current.stop:
+			//released while requesting
+			s.lock.Unlock()
+			s.revoke(path, renewed)
+			return
+		default:
+		}
+		current.lease = renewed
+		subscribers := append([]func(map[string]interface{}){}, current.subscribers...)
+		s.lock.Unlock()
+
+		for _, subscriber := range subscribers {
+			subscriber(renewed.Data)
+		}
+		s.revoke(path, lease)
+		lease = renewed
+		if s.increment == 0 {
+			requested = lease.Duration
+		}
+		wait = time.Duration(float64(lease.Duration) * s.threshold)
+	}
+}
+
+func (s *Secrets) revoke(path string, lease *vault.Lease) error {
+	if lease.ID == "" {
+		return nil
+	}
+	err := s.leaser.Revoke(lease.ID)
+	if err != nil {
+		s.warn("secrets: revoking %s: %s", path, err)
+	}
+	return err
+}
+
+//Release stops renewing a secret and revokes its lease
+func (s *Secrets) Release(path string) error {
+	path = strings.Trim(path, "/")
+	s.lock.Lock()
+	current, ok := s.secrets[path]
+	if !ok {
+		s.lock.Unlock()
+		return errors.New(errorNotRequested)
+	}
+	delete(s.secrets, path)
+	close(current.stop)
+	lease := current.lease
+	s.lock.Unlock()
+	return s.revoke(path, lease)
+}
+
+//Close stops renewing the secrets and revokes their leases, returning the first error
+func (s *Secrets) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	paths := make([]string, 0, len(s.secrets))
+	for path := range s.secrets {
+		paths = append(paths, path)
+	}
+	s.lock.Unlock()
+
+	var first error
+	for _, path := range paths {
+		if err := s.Release(path); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.wg.Wait()
+	return first
+}
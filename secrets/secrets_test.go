@@ -0,0 +1,219 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/store/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+//leaser leases credentials for duration after delay, renewable up to max times
+type leaser struct {
+	lock     sync.Mutex
+	duration time.Duration
+	delay    time.Duration
+	max      int
+	failing  bool
+	leased   int
+	renewals map[string]int
+	revoked  map[string]bool
+}
+
+func newLeaser(duration time.Duration, max int) *leaser {
+	return &leaser{duration: duration, max: max, renewals: map[string]int{}, revoked: map[string]bool{}}
+}
+
+func (l *leaser) Lease(path string, data map[string]interface{}) (*vault.Lease, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if path == "kv/static" {
+		return &vault.Lease{Path: path, Data: map[string]interface{}{"password": "static"}}, nil
+	}
+	l.lock.Unlock()
+	time.Sleep(l.delay)
+	l.lock.Lock()
+	l.leased++
+	id := fmt.Sprintf("%s/%d", path, l.leased)
+	return &vault.Lease{
+		ID: id, Path: path, Duration: l.duration, Renewable: true,
+		Data: map[string]interface{}{"username": id},
+	}, nil
+}
+
+func (l *leaser) Renew(id string, increment time.Duration) (time.Duration, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.failing || l.revoked[id] {
+		return 0, errors.New("lease not found")
+	}
+	l.renewals[id]++
+	duration := l.duration
+	if increment > 0 {
+		duration = increment
+	}
+	if l.renewals[id] > l.max {
+		//the maximum duration of the secret is reached
+		return duration / 2, nil
+	}
+	return duration, nil
+}
+
+func (l *leaser) Revoke(id string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.revoked[id] = true
+	return nil
+}
+
+func (l *leaser) state() (int, int, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	renewals := 0
+	for _, n := range l.renewals {
+		renewals += n
+	}
+	return l.leased, renewals, len(l.revoked)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.Error(t, err)
+	_, err = New(WithVault(nil))
+	assert.Error(t, err)
+	_, err = New(WithLeaser(newLeaser(time.Second, 1)), WithThreshold(1))
+	assert.Error(t, err)
+	_, err = New(WithLeaser(newLeaser(time.Second, 1)), WithRetry(0))
+	assert.Error(t, err)
+}
+
+func TestSecrets_Renewal(t *testing.T) {
+	l := newLeaser(150*time.Millisecond, 100)
+	s, err := New(WithLeaser(l))
+	assert.NoError(t, err)
+	defer s.Close()
+
+	data, err := s.Get("database/creds/readonly")
+	assert.NoError(t, err)
+	assert.Equal(t, "database/creds/readonly/1", data["username"])
+	data, _ = s.Get("/database/creds/readonly/")
+	assert.Equal(t, "database/creds/readonly/1", data["username"])
+
+	assert.Eventually(t, func() bool {
+		_, renewals, _ := l.state()
+		return renewals >= 2
+	}, 2*time.Second, 10*time.Millisecond)
+	leased, _, _ := l.state()
+	assert.Equal(t, 1, leased)
+}
+
+func TestSecrets_Increment(t *testing.T) {
+	//renewals shorter than the duration of the secret are not rotations
+	l := newLeaser(300*time.Millisecond, 100)
+	s, _ := New(WithLeaser(l), WithIncrement(100*time.Millisecond))
+	defer s.Close()
+	_, err := s.Get("database/creds/readonly")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, renewals, _ := l.state()
+		return renewals >= 3
+	}, 2*time.Second, 10*time.Millisecond)
+	leased, _, _ := l.state()
+	assert.Equal(t, 1, leased)
+}
+
+func TestSecrets_ConcurrentRequests(t *testing.T) {
+	l := newLeaser(time.Minute, 100)
+	l.delay = 200 * time.Millisecond
+	s, _ := New(WithLeaser(l))
+	defer s.Close()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := s.Get("database/creds/readonly")
+			assert.NoError(t, err)
+			assert.Equal(t, "database/creds/readonly/1", data["username"])
+		}()
+	}
+	//the other paths are not blocked by the pending request
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	_, err := s.Get("kv/static")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	wg.Wait()
+	leased, _, _ := l.state()
+	assert.Equal(t, 1, leased)
+}
+
+func TestSecrets_Rotation(t *testing.T) {
+	l := newLeaser(150*time.Millisecond, 1)
+	s, _ := New(WithLeaser(l))
+	defer s.Close()
+
+	rotated := make(chan map[string]interface{}, 10)
+	assert.NoError(t, s.Subscribe("database/creds/readonly", func(data map[string]interface{}) {
+		rotated <- data
+	}))
+	assert.Error(t, s.Subscribe("database/creds/readonly", nil))
+
+	select {
+	case data := <-rotated:
+		assert.Equal(t, "database/creds/readonly/2", data["username"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("secret not rotated")
+	}
+	assert.Eventually(t, func() bool {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return l.revoked["database/creds/readonly/1"]
+	}, time.Second, 10*time.Millisecond)
+	lease, err := s.Lease("database/creds/readonly")
+	assert.NoError(t, err)
+	assert.Equal(t, "database/creds/readonly/2", lease.ID)
+}
+
+func TestSecrets_RenewalFailure(t *testing.T) {
+	l := newLeaser(150*time.Millisecond, 100)
+	l.failing = true
+	s, _ := New(WithLeaser(l))
+	defer s.Close()
+	_, err := s.Get("database/creds/readonly")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		data, _ := s.Get("database/creds/readonly")
+		return data["username"] == "database/creds/readonly/2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestSecrets_Close(t *testing.T) {
+	l := newLeaser(time.Hour, 100)
+	s, _ := New(WithLeaser(l))
+	_, err := s.Get("database/creds/readonly")
+	assert.NoError(t, err)
+	_, err = s.Get("aws/creds/deploy")
+	assert.NoError(t, err)
+	data, err := s.Get("kv/static")
+	assert.NoError(t, err)
+	assert.Equal(t, "static", data["password"])
+
+	assert.NoError(t, s.Release("aws/creds/deploy"))
+	assert.Error(t, s.Release("aws/creds/deploy"))
+	_, _, revoked := l.state()
+	assert.Equal(t, 1, revoked)
+
+	assert.NoError(t, s.Close())
+	_, _, revoked = l.state()
+	assert.Equal(t, 2, revoked)
+	assert.NoError(t, s.Close())
+	_, err = s.Get("database/creds/readonly")
+	assert.Error(t, err)
+	_, err = s.Lease("database/creds/readonly")
+	assert.Error(t, err)
+}
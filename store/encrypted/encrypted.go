@@ -0,0 +1,370 @@
+package encrypted
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+)
+
+const (
+	algorithm = "AES-256-GCM"
+
+	errorStoreNil    = "store cannot be nil"
+	errorKeyringNil  = "keyring cannot be nil"
+	errorValueType   = "value must be a string or a []byte"
+	errorStoredType  = "stored value must be a string or a []byte"
+	errorListParams  = "list params must be a prefix, a func([]byte) or func(string, []byte) callback and optionally the params of the store"
+	errorQueryParams = "query params must be a *query.Query"
+)
+
+//ErrNotEncrypted is returned reading a value that is not an envelope of this store
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+//ErrDecrypt is returned when a value cannot be decrypted, tampered or moved to another key
+var ErrDecrypt = errors.New("cannot decrypt value")
+
+//WithStore sets the store holding the encrypted values
+func WithStore(s interfaces.Store) store.Option {
+	return func(i interfaces.Store) error {
+		if s != nil {
+			e := i.(*Encrypted)
+			e.store = s
+			return nil
+		}
+		return errors.New(errorStoreNil)
+	}
+}
+
+//WithKeyring sets the keyring wrapping the data keys
+func WithKeyring(keyring Keyring) store.Option {
+	return func(i interfaces.Store) error {
+		if keyring != nil {
+			e := i.(*Encrypted)
+			e.keyring = keyring
+			return nil
+		}
+		return errors.New(errorKeyringNil)
+	}
+}
+
+//envelope is the value written to the store: the data encrypted with a random
+//data key, the data key wrapped by the master key of the keyring
+type envelope struct {
+	Algorithm string `json:"alg"`
+	Key       string `json:"key"`
+	Version   int    `json:"version"`
+	DataKey   string `json:"data_key"`
+	Nonce     []byte `json:"nonce"`
+	Data      []byte `json:"data"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+//Store is an encrypted store, see New
+type Store interface {
+	interfaces.Store
+	Store() interfaces.Store
+	Reencrypt(string, string) (int, error)
+}
+
+//Encrypted wraps a Store encrypting the values at rest. Keys and bucket names
+//are stored in clear, values are read back with the type they were written.
+type Encrypted struct {
+	store   interfaces.Store
+	keyring Keyring
+}
+
+//New returns an *Encrypted, a *Versioned if the store wrapped keeps revisions or
+//a *Historian if it keeps their history, supporting what the store supports
+func New(options ...store.Option) (Store, error) {
+	e := &Encrypted{}
+	for _, option := range options {
+		if err := option(e); err != nil {
+			return nil, err
+		}
+	}
+	if e.store == nil {
+		return nil, errors.New(errorStoreNil)
+	}
+	if e.keyring == nil {
+		return nil, errors.New(errorKeyringNil)
+	}
+	return e.wrap(), nil
+}
+
+//Store returns the wrapped store
+func (e *Encrypted) Store() interfaces.Store {
+	return e.store
+}
+
+//additional binds the ciphertext to its bucket and key
+func additional(bucket, key string) []byte {
+	return []byte(bucket + "/" + key)
+}
+
+func (e *Encrypted) seal(bucket, key string, data interface{}) ([]byte, error) {
+	var plain []byte
+	binary := false
+	switch value := data.(type) {
+	case string:
+		plain = []byte(value)
+	case []byte:
+		plain, binary = value, true
+	default:
+		return nil, errors.New(errorValueType)
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	gcm, err := aead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped, version, err := e.keyring.Wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope{
+		Algorithm: algorithm,
+		Key:       key,
+		Version:   version,
+		DataKey:   wrapped,
+		Nonce:     nonce,
+		Data:      gcm.Seal(nil, nonce, plain, additional(bucket, key)),
+		Binary:    binary,
+	})
+}
+
+func decode(stored interface{}) (*envelope, error) {
+	var data []byte
+	switch value := stored.(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return nil, errors.New(errorStoredType)
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(data, env); err != nil || env.Algorithm != algorithm {
+		return nil, ErrNotEncrypted
+	}
+	return env, nil
+}
+
+func (e *Encrypted) open(bucket string, env *envelope) ([]byte, error) {
+	dataKey, err := e.keyring.Unwrap(env.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := aead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%s/%s: %w", bucket, env.Key, ErrDecrypt)
+	}
+	plain, err := gcm.Open(nil, env.Nonce, env.Data, additional(bucket, env.Key))
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", bucket, env.Key, ErrDecrypt)
+	}
+	return plain, nil
+}
+
+func value(env *envelope, plain []byte) interface{} {
+	if env.Binary {
+		return plain
+	}
+	return string(plain)
+}
+
+//Create encrypts the value of a key, a string or a []byte
+func (e *Encrypted) Create(bucket string, key string, data interface{}) error {
+	sealed, err := e.seal(bucket, key, data)
+	if err != nil {
+		return err
+	}
+	return e.store.Create(bucket, key, sealed)
+}
+
+//Read decrypts the value of a key, returned as a string or a []byte as it was written
+func (e *Encrypted) Read(bucket string, key string) (interface{}, error) {
+	stored, err := e.store.Read(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(bucket, key, stored)
+}
+
+//decrypt returns the value of an envelope of a key
+func (e *Encrypted) decrypt(bucket string, key string, stored interface{}) (interface{}, error) {
+	env, err := decode(stored)
+	if err != nil {
+		return nil, err
+	}
+	if env.Key != key {
+		return nil, fmt.Errorf("%s/%s: %w", bucket, key, ErrDecrypt)
+	}
+	plain, err := e.open(bucket, env)
+	if err != nil {
+		return nil, err
+	}
+	return value(env, plain), nil
+}
+
+func (e *Encrypted) Update(bucket string, key string, data interface{}) error {
+	sealed, err := e.seal(bucket, key, data)
+	if err != nil {
+		return err
+	}
+	return e.store.Update(bucket, key, sealed)
+}
+
+func (e *Encrypted) Delete(bucket string, key string) error {
+	return e.store.Delete(bucket, key)
+}
+
+//Scans reports whether the List of the store wrapped passes the values to a callback
+func (e *Encrypted) Scans() bool {
+	return store.Scanning(e.store) == nil
+}
+
+//List passes the decrypted values of the keys starting with a prefix to a callback:
+//List(bucket, prefix string, callback), the callback being a func([]byte) or a
+//func(string, []byte) receiving the key too. The other params are passed to the store.
+//It stops at the first value that cannot be decrypted.
+func (e *Encrypted) List(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errors.New(errorListParams)
+	}
+	var callback func(string, []byte)
+	switch c := params[1].(type) {
+	case func([]byte):
+		callback = func(_ string, v []byte) { c(v) }
+	case func(string, []byte):
+		callback = c
+	default:
+		return nil, errors.New(errorListParams)
+	}
+	var failed error
+	forward := append([]interface{}{params[0], func(stored []byte) {
+		if failed != nil {
+			return
+		}
+		env, err := decode(stored)
+		if err != nil {
+			failed = err
+			return
+		}
+		plain, err := e.open(bucket, env)
+		if err != nil {
+			failed = err
+			return
+		}
+		callback(env.Key, plain)
+	}}, params[2:]...)
+	result, err := e.store.List(bucket, forward...)
+	if err != nil {
+		return nil, err
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return result, nil
+}
+
+//Query evaluates a *query.Query on the decrypted JSON objects of a bucket, scanning it
+func (e *Encrypted) Query(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, errors.New(errorQueryParams)
+	}
+	q, ok := params[0].(*query.Query)
+	if !ok {
+		return nil, errors.New(errorQueryParams)
+	}
+	return query.Scan(e, bucket, q)
+}
+
+func (e *Encrypted) Buckets() (interface{}, error) {
+	return e.store.Buckets()
+}
+
+//Reencrypt encrypts again with a new data key the values of the keys starting
+//with a prefix whose data key was wrapped by an older version of the master key,
+//and returns how many were encrypted again. Run it after rotating the master key
+//and before retiring its older versions.
+func (e *Encrypted) Reencrypt(bucket string, prefix string) (int, error) {
+	current, err := e.keyring.Version()
+	if err != nil {
+		return 0, err
+	}
+	stale := make([]string, 0)
+	var failed error
+	_, err = e.store.List(bucket, prefix, func(stored []byte) {
+		if failed != nil {
+			return
+		}
+		env, err := decode(stored)
+		if err != nil {
+			failed = err
+			return
+		}
+		if env.Version < current {
+			stale = append(stale, env.Key)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if failed != nil {
+		return 0, failed
+	}
+
+	//with revisions, the values written meanwhile are not overwritten
+	versioned, _ := e.store.(interfaces.Versioned)
+	count := 0
+	for _, key := range stale {
+		var stored interface{}
+		revision := ""
+		if versioned != nil {
+			stored, revision, err = versioned.ReadRevision(bucket, key)
+		} else {
+			stored, err = e.store.Read(bucket, key)
+		}
+		if err != nil {
+			return count, err
+		}
+		env, err := decode(stored)
+		if err != nil {
+			return count, err
+		}
+		if env.Version >= current {
+			continue
+		}
+		plain, err := e.open(bucket, env)
+		if err != nil {
+			return count, err
+		}
+		if versioned == nil {
+			err = e.Update(bucket, key, value(env, plain))
+		} else if _, err = e.updateIf(versioned, bucket, key, value(env, plain), revision); errors.Is(err, store.ErrConflict) {
+			//written meanwhile with the current key
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
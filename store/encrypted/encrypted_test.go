@@ -0,0 +1,174 @@
+package encrypted
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/kv"
+	"github.com/advancedlogic/box/store/kv/kvtest"
+	"github.com/advancedlogic/box/store/query"
+	"github.com/stretchr/testify/assert"
+)
+
+//plain hides the revisions of a store
+type plain struct {
+	interfaces.Store
+}
+
+func newEncrypted(t *testing.T) (*Versioned, *kv.Kv, *Local) {
+	k := kvtest.New(t)
+	keyring, err := NewLocal(bytes.Repeat([]byte{1}, KeySize))
+	assert.NoError(t, err)
+	e, err := New(WithStore(k), WithKeyring(keyring))
+	assert.NoError(t, err)
+	return e.(*Versioned), k, keyring
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.Error(t, err)
+	_, err = New(WithStore(nil))
+	assert.Error(t, err)
+	_, err = New(WithKeyring(nil))
+	assert.Error(t, err)
+	_, err = NewLocal()
+	assert.Error(t, err)
+	_, err = NewLocal([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestEncrypted_CRUD(t *testing.T) {
+	e, k, _ := newEncrypted(t)
+	assert.NoError(t, e.Create("users", "alice", "alice@example.com"))
+	value, err := e.Read("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", value)
+	raw, _ := k.Read("users", "alice")
+	assert.NotContains(t, raw, "alice@example.com")
+
+	assert.NoError(t, e.Update("users", "alice", []byte{0, 1, 2}))
+	value, err = e.Read("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, value)
+	assert.Error(t, e.Create("users", "bob", 42))
+
+	assert.NoError(t, e.Delete("users", "alice"))
+	_, err = e.Read("users", "alice")
+	assert.True(t, errors.Is(err, kv.ErrNotFound))
+}
+
+func TestEncrypted_Versioned(t *testing.T) {
+	e, k, _ := newEncrypted(t)
+	revision, err := e.Insert("users", "alice", "alice@example.com")
+	assert.NoError(t, err)
+	_, err = e.Insert("users", "alice", "alice@example.org")
+	assert.True(t, errors.Is(err, store.ErrExists))
+	raw, _ := k.Read("users", "alice")
+	assert.NotContains(t, raw, "alice@example.com")
+
+	updated, err := e.UpdateIf("users", "alice", "alice@example.org", revision)
+	assert.NoError(t, err)
+	_, err = e.UpdateIf("users", "alice", "alice@example.net", revision)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	value, current, err := e.ReadRevision("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.org", value)
+	assert.Equal(t, updated, current)
+	assert.NoError(t, e.DeleteIf("users", "alice", current))
+
+	_, err = store.History(e)
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+
+	//the revisions are supported only if the store wrapped keeps them
+	keyring, _ := NewLocal(bytes.Repeat([]byte{1}, KeySize))
+	p, err := New(WithStore(plain{k}), WithKeyring(keyring))
+	assert.NoError(t, err)
+	_, err = store.Versioning(p)
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+}
+
+func TestEncrypted_Tampered(t *testing.T) {
+	e, k, _ := newEncrypted(t)
+	assert.NoError(t, k.Create("users", "plain", "not encrypted"))
+	_, err := e.Read("users", "plain")
+	assert.True(t, errors.Is(err, ErrNotEncrypted))
+
+	//an envelope moved to another key does not decrypt
+	assert.NoError(t, e.Create("users", "alice", "secret"))
+	raw, _ := k.Read("users", "alice")
+	assert.NoError(t, k.Create("users", "bob", raw))
+	_, err = e.Read("users", "bob")
+	assert.True(t, errors.Is(err, ErrDecrypt))
+	moved := strings.Replace(raw.(string), `"key":"alice"`, `"key":"bob"`, 1)
+	assert.NoError(t, k.Update("users", "bob", moved))
+	_, err = e.Read("users", "bob")
+	assert.True(t, errors.Is(err, ErrDecrypt))
+
+	//a data key wrapped by another master key does not unwrap
+	other, _ := NewLocal(bytes.Repeat([]byte{2}, KeySize))
+	stranger, _ := New(WithStore(k), WithKeyring(other))
+	_, err = stranger.Read("users", "alice")
+	assert.Error(t, err)
+}
+
+func TestEncrypted_ListQuery(t *testing.T) {
+	e, _, _ := newEncrypted(t)
+	assert.NoError(t, e.Create("users", "alice", `{"name":"alice","age":30}`))
+	assert.NoError(t, e.Create("users", "bob", `{"name":"bob","age":20}`))
+	assert.NoError(t, e.Create("users", "carol", `{"name":"carol","age":40}`))
+
+	keys := make([]string, 0)
+	_, err := e.List("users", "", func(key string, value []byte) {
+		keys = append(keys, key)
+		assert.Contains(t, string(value), key)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, keys)
+	_, err = e.List("users", "")
+	assert.Error(t, err)
+
+	result, err := e.Query("users", &query.Query{
+		Where: query.Gt("age", 25),
+		Sort:  []query.Sort{{Field: "name"}},
+	})
+	assert.NoError(t, err)
+	matched := result.([]map[string]interface{})
+	assert.Len(t, matched, 2)
+	assert.Equal(t, "alice", matched[0]["name"])
+	assert.Equal(t, "carol", matched[1]["name"])
+}
+
+func TestEncrypted_Rotation(t *testing.T) {
+	for name, wrap := range map[string]func(interfaces.Store) interfaces.Store{
+		"versioned": func(s interfaces.Store) interfaces.Store { return s },
+		"plain":     func(s interfaces.Store) interfaces.Store { return plain{s} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			keyring, _ := NewLocal(bytes.Repeat([]byte{1}, KeySize))
+			e, err := New(WithStore(wrap(kvtest.New(t))), WithKeyring(keyring))
+			assert.NoError(t, err)
+			assert.NoError(t, e.Create("users", "alice", "one"))
+			assert.NoError(t, e.Create("users", "bob", "two"))
+			assert.NoError(t, keyring.Rotate())
+			version, _ := keyring.Version()
+			assert.Equal(t, 2, version)
+
+			//values wrapped by the previous version still decrypt
+			value, err := e.Read("users", "alice")
+			assert.NoError(t, err)
+			assert.Equal(t, "one", value)
+			assert.NoError(t, e.Update("users", "bob", "three"))
+
+			count, err := e.Reencrypt("users", "")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, count)
+			count, err = e.Reencrypt("users", "")
+			assert.NoError(t, err)
+			assert.Equal(t, 0, count)
+		})
+	}
+}
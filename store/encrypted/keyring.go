@@ -0,0 +1,151 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	//KeySize is the size of the master keys and of the data keys, AES-256
+	KeySize = 32
+
+	errorKeysEmpty   = "keyring needs at least one key"
+	errorKeySize     = "keys must be 32 bytes"
+	errorWrapped     = "wrapped key is malformed"
+	errorKeyVersion  = "unknown master key version"
+	errorUnwrapFails = "cannot unwrap data key"
+)
+
+//Keyring wraps the data keys with a master key. Wrapped keys carry the version
+//of the master key so they can be unwrapped after it rotates.
+type Keyring interface {
+	//Wrap encrypts a data key and returns it with the version of the master key used
+	Wrap(key []byte) (string, int, error)
+	//Unwrap decrypts a data key wrapped with any version of the master key
+	Unwrap(wrapped string) ([]byte, error)
+	//Version returns the current version of the master key
+	Version() (int, error)
+	//Rotate creates a new version of the master key used to wrap from now on
+	Rotate() error
+}
+
+//Local is a Keyring keeping the master keys in memory, the version of a key
+//being its position starting from 1. Wrapped keys look like v1:base64.
+type Local struct {
+	lock sync.RWMutex
+	keys []cipher.AEAD
+	raw  [][]byte
+}
+
+func aead(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New(errorKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//NewLocal returns a keyring of the master keys, the last one being the current one
+func NewLocal(keys ...[]byte) (*Local, error) {
+	if len(keys) == 0 {
+		return nil, errors.New(errorKeysEmpty)
+	}
+	l := &Local{}
+	for _, key := range keys {
+		if err := l.add(key); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *Local) add(key []byte) error {
+	gcm, err := aead(key)
+	if err != nil {
+		return err
+	}
+	l.keys = append(l.keys, gcm)
+	l.raw = append(l.raw, append([]byte(nil), key...))
+	return nil
+}
+
+//Keys returns the master keys to persist them, oldest first
+func (l *Local) Keys() [][]byte {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	keys := make([][]byte, len(l.raw))
+	for k, key := range l.raw {
+		keys[k] = append([]byte(nil), key...)
+	}
+	return keys
+}
+
+func (l *Local) Wrap(key []byte) (string, int, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	version := len(l.keys)
+	gcm := l.keys[version-1]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", 0, err
+	}
+	sealed := gcm.Seal(nonce, nonce, key, []byte(strconv.Itoa(version)))
+	return fmt.Sprintf("v%d:%s", version, base64.StdEncoding.EncodeToString(sealed)), version, nil
+}
+
+func (l *Local) Unwrap(wrapped string) ([]byte, error) {
+	parts := strings.SplitN(wrapped, ":", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "v") {
+		return nil, errors.New(errorWrapped)
+	}
+	version, err := strconv.Atoi(parts[0][1:])
+	if err != nil {
+		return nil, errors.New(errorWrapped)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New(errorWrapped)
+	}
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if version < 1 || version > len(l.keys) {
+		return nil, fmt.Errorf("%s: %d", errorKeyVersion, version)
+	}
+	gcm := l.keys[version-1]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New(errorWrapped)
+	}
+	key, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(parts[0][1:]))
+	if err != nil {
+		return nil, errors.New(errorUnwrapFails)
+	}
+	return key, nil
+}
+
+func (l *Local) Version() (int, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return len(l.keys), nil
+}
+
+//Rotate generates a new random master key
+func (l *Local) Rotate() error {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.add(key)
+}
@@ -0,0 +1,81 @@
+package encrypted
+
+import (
+	"github.com/advancedlogic/box/interfaces"
+)
+
+//Versioned is an Encrypted store whose store keeps revisions, the revisions
+//are the ones of the encrypted values
+type Versioned struct {
+	*Encrypted
+	versioned interfaces.Versioned
+}
+
+//Historian is a Versioned store whose store keeps the history of the revisions
+type Historian struct {
+	*Versioned
+	historian interfaces.Historian
+}
+
+//wrap returns the variant supporting what the store wrapped supports
+func (e *Encrypted) wrap() Store {
+	if h, ok := e.store.(interfaces.Historian); ok {
+		return &Historian{Versioned: &Versioned{Encrypted: e, versioned: h}, historian: h}
+	}
+	if v, ok := e.store.(interfaces.Versioned); ok {
+		return &Versioned{Encrypted: e, versioned: v}
+	}
+	return e
+}
+
+//Insert encrypts the value of a key failing with store.ErrExists if it exists
+func (v *Versioned) Insert(bucket string, key string, data interface{}) (string, error) {
+	sealed, err := v.seal(bucket, key, data)
+	if err != nil {
+		return "", err
+	}
+	return v.versioned.Insert(bucket, key, sealed)
+}
+
+//ReadRevision decrypts the value of a key and returns its revision
+func (v *Versioned) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	stored, revision, err := v.versioned.ReadRevision(bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	value, err := v.decrypt(bucket, key, stored)
+	if err != nil {
+		return nil, "", err
+	}
+	return value, revision, nil
+}
+
+//UpdateIf encrypts the value of a key if its revision matches
+func (v *Versioned) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	return v.updateIf(v.versioned, bucket, key, data, revision)
+}
+
+func (e *Encrypted) updateIf(versioned interfaces.Versioned, bucket string, key string, data interface{}, revision string) (string, error) {
+	sealed, err := e.seal(bucket, key, data)
+	if err != nil {
+		return "", err
+	}
+	return versioned.UpdateIf(bucket, key, sealed, revision)
+}
+
+func (v *Versioned) DeleteIf(bucket string, key string, revision string) error {
+	return v.versioned.DeleteIf(bucket, key, revision)
+}
+
+func (h *Historian) History(bucket string, key string) ([]interfaces.Revision, error) {
+	return h.historian.History(bucket, key)
+}
+
+//ReadAt decrypts the value of a key at a revision
+func (h *Historian) ReadAt(bucket string, key string, revision string) (interface{}, error) {
+	stored, err := h.historian.ReadAt(bucket, key, revision)
+	if err != nil {
+		return nil, err
+	}
+	return h.decrypt(bucket, key, stored)
+}
@@ -0,0 +1,467 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+)
+
+const (
+	lockFile     = ".lock"
+	sequenceFile = ".sequence"
+	metaSuffix   = ".meta"
+	tempPrefix   = ".tmp-"
+	maxName      = 255
+
+	errorRootEmpty    = "root cannot be empty"
+	errorPermissions  = "permissions must include read and write for the owner"
+	errorBucketName   = "invalid bucket name: %q"
+	errorKeyEmpty     = "key cannot be empty"
+	errorKeyTooLong   = "key too long: %q"
+	errorValueType    = "value must be string or []byte"
+	errorListParams   = "list params must be a prefix, a func([]byte) callback and optionally a *Page"
+	errorLimitInvalid = "page limit cannot be negative"
+	errorQueryParams  = "query params must be a *query.Query"
+)
+
+//WithRoot sets the directory holding the buckets. Default data.
+func WithRoot(root string) store.Option {
+	return func(i interfaces.Store) error {
+		if root != "" {
+			f := i.(*Fs)
+			f.root = root
+			return nil
+		}
+		return errors.New(errorRootEmpty)
+	}
+}
+
+//WithPermissions sets the permissions of the files, the directories get the
+//execute bit where the files are readable. Default 0644.
+func WithPermissions(perm os.FileMode) store.Option {
+	return func(i interfaces.Store) error {
+		if perm&0600 == 0600 {
+			f := i.(*Fs)
+			f.perm = perm
+			return nil
+		}
+		return errors.New(errorPermissions)
+	}
+}
+
+//Metadata describes a value, it is kept in a sidecar file next to it
+type Metadata struct {
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	//Revision is the sequence of the last write in the bucket, so that a key
+	//deleted and written again never gets a revision back. 0 if the sidecar is missing.
+	Revision int64 `json:"revision"`
+}
+
+//Page limits the keys visited by List. Limit keys are visited after the key After,
+//Next is set to the last key visited when more keys are left, to be used as After
+//for the next page.
+type Page struct {
+	After string
+	Limit int
+	Next  string
+}
+
+//Fs implements the Store interface on the local filesystem, for development and tests.
+//
+//Buckets are directories of the root and keys are files of their bucket, with the
+//keys escaped so that any key, including the ones with / or .., stays in its bucket.
+//Writes are atomic, writing a temporary file then renamed, and are serialized
+//among processes by a lock file in every bucket.
+type Fs struct {
+	root string
+	perm os.FileMode
+}
+
+func New(options ...store.Option) (*Fs, error) {
+	f := &Fs{
+		root: "data",
+		perm: 0644,
+	}
+	for _, option := range options {
+		if err := option(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(f.root, f.dirPerm()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Fs) Instance() interface{} {
+	return f.root
+}
+
+//Check verifies that the root is a directory
+func (f *Fs) Check(ctx context.Context) error {
+	info, err := os.Stat(f.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", f.root)
+	}
+	return nil
+}
+
+func (f *Fs) dirPerm() os.FileMode {
+	return f.perm | (f.perm&0444)>>2
+}
+
+//name returns the file name of a key: the key escaped with its dots escaped too
+//when leading, since the names starting with a dot are reserved
+func name(key string) (string, error) {
+	if key == "" {
+		return "", errors.New(errorKeyEmpty)
+	}
+	escaped := url.PathEscape(key)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	if len(escaped)+len(metaSuffix)+1 > maxName {
+		return "", fmt.Errorf(errorKeyTooLong, key)
+	}
+	return escaped, nil
+}
+
+func validBucket(bucket string) error {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) || len(bucket) > maxName {
+		return fmt.Errorf(errorBucketName, bucket)
+	}
+	return nil
+}
+
+//path returns the directory of the bucket and the file name of the key
+func (f *Fs) path(bucket, key string) (string, string, error) {
+	if err := validBucket(bucket); err != nil {
+		return "", "", err
+	}
+	file, err := name(key)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(f.root, bucket), file, nil
+}
+
+func contentType(data interface{}) ([]byte, string, error) {
+	switch value := data.(type) {
+	case string:
+		return []byte(value), "text/plain; charset=utf-8", nil
+	case []byte:
+		return value, http.DetectContentType(value), nil
+	}
+	return nil, "", errors.New(errorValueType)
+}
+
+//Create writes the value of a key, a string or a []byte
+func (f *Fs) Create(bucket string, key string, data interface{}) error {
+	_, err := f.put(bucket, key, data, nil)
+	return err
+}
+
+//put writes the value of a key if check, called with the revision of the key
+//or "" if missing, does not fail, and returns the new revision
+func (f *Fs) put(bucket string, key string, data interface{}, check func(string) error) (string, error) {
+	dir, file, err := f.path(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	value, kind, err := contentType(data)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, f.dirPerm()); err != nil {
+		return "", err
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile), true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if check != nil {
+		if err := check(f.revision(dir, file)); err != nil {
+			return "", err
+		}
+	}
+	now := time.Now().UTC()
+	meta := &Metadata{ContentType: kind, Size: int64(len(value)), Created: now, Updated: now}
+	previous, err := f.metadata(dir, file)
+	if err == nil {
+		meta.Created = previous.Created
+	}
+	if meta.Revision, err = f.next(dir, previous); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	if err := f.write(dir, file, value); err != nil {
+		return "", err
+	}
+	//a missing or stale sidecar only affects Stat and the revisions
+	if err := f.write(dir, "."+file+metaSuffix, encoded); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(meta.Revision, 10), nil
+}
+
+//next increments the sequence of the writes in a bucket, past the revision of
+//the previous metadata of the key if any. The lock of the bucket must be held.
+func (f *Fs) next(dir string, previous *Metadata) (int64, error) {
+	sequence := int64(0)
+	if data, err := os.ReadFile(filepath.Join(dir, sequenceFile)); err == nil {
+		sequence, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if previous != nil && previous.Revision > sequence {
+		sequence = previous.Revision
+	}
+	sequence++
+	if err := f.write(dir, sequenceFile, []byte(strconv.FormatInt(sequence, 10))); err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
+//write writes a file atomically, through a temporary file renamed over it
+func (f *Fs) write(dir, file string, data []byte) error {
+	temp, err := os.CreateTemp(dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), f.perm); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filepath.Join(dir, file))
+}
+
+//Read returns the value of a key as a string
+func (f *Fs) Read(bucket string, key string) (interface{}, error) {
+	dir, file, err := f.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile), false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (f *Fs) Update(bucket string, key string, data interface{}) error {
+	return f.Create(bucket, key, data)
+}
+
+//Delete removes a key and its metadata
+func (f *Fs) Delete(bucket string, key string) error {
+	return f.remove(bucket, key, nil)
+}
+
+//remove deletes a key if check, called with the revision of the key, does not fail
+func (f *Fs) remove(bucket string, key string, check func(string) error) error {
+	dir, file, err := f.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile), true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if check != nil {
+		if err := check(f.revision(dir, file)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, file)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, "."+file+metaSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *Fs) metadata(dir, file string) (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "."+file+metaSuffix))
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+//Stat returns the metadata of a key
+func (f *Fs) Stat(bucket string, key string) (*Metadata, error) {
+	dir, file, err := f.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile), false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+		return nil, err
+	}
+	return f.metadata(dir, file)
+}
+
+//Keys returns the keys of a bucket starting with prefix in lexical order
+func (f *Fs) Keys(bucket string, prefix string) ([]string, error) {
+	if err := validBucket(bucket); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(f.root, bucket))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		key, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//Scans reports that List passes the values to a callback
+func (f *Fs) Scans() bool {
+	return true
+}
+
+//List passes the values of the keys starting with a prefix to a callback, in the
+//lexical order of the keys: List(bucket, prefix string, callback func([]byte)).
+//An optional *Page paginates the keys.
+func (f *Fs) List(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errors.New(errorListParams)
+	}
+	prefix, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New(errorListParams)
+	}
+	callback, ok := params[1].(func([]byte))
+	if !ok {
+		return nil, errors.New(errorListParams)
+	}
+	var page *Page
+	if len(params) > 2 {
+		if page, ok = params[2].(*Page); !ok {
+			return nil, errors.New(errorListParams)
+		}
+		if page.Limit < 0 {
+			return nil, errors.New(errorLimitInvalid)
+		}
+	}
+
+	keys, err := f.Keys(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if page != nil {
+		page.Next = ""
+		start := sort.SearchStrings(keys, page.After)
+		if start < len(keys) && keys[start] == page.After {
+			start++
+		}
+		keys = keys[start:]
+		if page.Limit > 0 && len(keys) > page.Limit {
+			keys = keys[:page.Limit]
+			page.Next = keys[len(keys)-1]
+		}
+	}
+	for _, key := range keys {
+		value, err := f.Read(bucket, key)
+		if errors.Is(err, os.ErrNotExist) {
+			//deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		callback([]byte(value.(string)))
+	}
+	return nil, nil
+}
+
+//Query evaluates a *query.Query on the JSON objects of a bucket, scanning it
+func (f *Fs) Query(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) == 0 {
+		return nil, errors.New(errorQueryParams)
+	}
+	q, ok := params[0].(*query.Query)
+	if !ok {
+		return nil, errors.New(errorQueryParams)
+	}
+	return query.Scan(f, bucket, q)
+}
+
+//Buckets returns the names of the buckets
+func (f *Fs) Buckets() (interface{}, error) {
+	entries, err := os.ReadDir(f.root)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,225 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFs(t *testing.T) *Fs {
+	f, err := New(WithRoot(t.TempDir()))
+	assert.NoError(t, err)
+	return f
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithRoot(""))
+	assert.Error(t, err)
+	_, err = New(WithPermissions(0400))
+	assert.Error(t, err)
+	f := newFs(t)
+	assert.NoError(t, f.Check(nil))
+}
+
+func TestFs_CRUD(t *testing.T) {
+	f := newFs(t)
+	assert.NoError(t, f.Create("users", "alice", "admin"))
+	value, err := f.Read("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+
+	created, err := f.Stat("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", created.ContentType)
+	assert.Equal(t, int64(5), created.Size)
+
+	assert.NoError(t, f.Update("users", "alice", []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}))
+	updated, err := f.Stat("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", updated.ContentType)
+	assert.Equal(t, created.Created, updated.Created)
+	assert.False(t, updated.Updated.Before(created.Updated))
+
+	assert.NoError(t, f.Delete("users", "alice"))
+	_, err = f.Read("users", "alice")
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, f.Delete("users", "alice"))
+	_, err = f.Read("missing", "alice")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Error(t, f.Create("users", "bob", 42))
+	assert.Error(t, f.Create("users", "", "empty"))
+}
+
+func TestFs_Traversal(t *testing.T) {
+	root := t.TempDir()
+	f, _ := New(WithRoot(filepath.Join(root, "store")))
+	for _, bucket := range []string{"", ".", "..", "../escape", "a/b", `a\b`, ".hidden"} {
+		assert.Error(t, f.Create(bucket, "key", "value"), bucket)
+	}
+	for _, key := range []string{"..", "../../escape", "/etc/passwd", ".lock", ".meta", "a/b/c"} {
+		assert.NoError(t, f.Create("bucket", key, key), key)
+		value, err := f.Read("bucket", key)
+		assert.NoError(t, err)
+		assert.Equal(t, key, value)
+	}
+	entries, _ := os.ReadDir(root)
+	assert.Len(t, entries, 1)
+
+	keys, err := f.Keys("bucket", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"..", "../../escape", ".lock", ".meta", "/etc/passwd", "a/b/c"}, keys)
+}
+
+func TestFs_List(t *testing.T) {
+	f := newFs(t)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, f.Create("events", fmt.Sprintf("order/%02d", i), fmt.Sprintf("%d", i)))
+	}
+	assert.NoError(t, f.Create("events", "payment/01", "payment"))
+
+	values := make([]string, 0)
+	callback := func(data []byte) { values = append(values, string(data)) }
+	_, err := f.List("events", "order/", callback)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, values)
+
+	values = values[:0]
+	page := &Page{Limit: 4}
+	pages := 0
+	for {
+		_, err := f.List("events", "order/", callback, page)
+		assert.NoError(t, err)
+		pages++
+		if page.Next == "" {
+			break
+		}
+		page.After = page.Next
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, values)
+
+	_, err = f.List("events", "order/")
+	assert.Error(t, err)
+	_, err = f.List("events", "order/", callback, &Page{Limit: -1})
+	assert.Error(t, err)
+
+	values = values[:0]
+	_, err = f.List("missing", "", callback)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestFs_Buckets(t *testing.T) {
+	f := newFs(t)
+	assert.NoError(t, f.Create("users", "alice", "admin"))
+	assert.NoError(t, f.Create("groups", "admin", "alice"))
+	buckets, err := f.Buckets()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"users", "groups"}, buckets)
+}
+
+func TestFs_Concurrent(t *testing.T) {
+	f := newFs(t)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, f.Update("counters", "shared", fmt.Sprintf("value %d", i)))
+			_, err := f.Read("counters", "shared")
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	keys, _ := f.Keys("counters", "")
+	assert.Equal(t, []string{"shared"}, keys)
+	entries, _ := os.ReadDir(filepath.Join(f.root, "counters"))
+	//the value, its metadata, the lock and the sequence files, no temporary file left
+	assert.Len(t, entries, 4)
+}
+
+func TestFs_Query(t *testing.T) {
+	f := newFs(t)
+	assert.NoError(t, f.Create("users", "alice", `{"name":"alice","address":{"city":"Rome"}}`))
+	assert.NoError(t, f.Create("users", "bob", `{"name":"bob","address":{"city":"Milan"}}`))
+
+	result, err := f.Query("users", &query.Query{Where: query.Eq("address.city", "Rome"), Fields: []string{"name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"name": "alice"}}, result)
+	_, err = f.Query("users")
+	assert.Error(t, err)
+}
+
+func TestFs_Versioned(t *testing.T) {
+	f := newFs(t)
+	var _ interfaces.Versioned = f
+	revision, err := f.Insert("users", "alice", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", revision)
+	_, err = f.Insert("users", "alice", "user")
+	assert.True(t, errors.Is(err, store.ErrExists))
+
+	updated, err := f.UpdateIf("users", "alice", "user", revision)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", updated)
+	_, err = f.UpdateIf("users", "alice", "guest", revision)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	value, current, err := f.ReadRevision("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", value)
+	assert.Equal(t, updated, current)
+
+	assert.True(t, errors.Is(f.DeleteIf("users", "alice", revision), store.ErrConflict))
+	assert.NoError(t, f.DeleteIf("users", "alice", current))
+	_, _, err = f.ReadRevision("users", "alice")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	//a key written again after being deleted does not get a revision back
+	revision, err = f.Insert("users", "alice", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", revision)
+	_, err = f.UpdateIf("users", "alice", "guest", "1")
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	keys := 0
+	_, err = f.List("users", "", func([]byte) { keys++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, keys)
+}
+
+func TestFs_ConcurrentUpdateIf(t *testing.T) {
+	f := newFs(t)
+	_, err := f.Insert("counters", "hits", "0")
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				value, revision, err := f.ReadRevision("counters", "hits")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var n int
+				fmt.Sscan(value.(string), &n)
+				if _, err = f.UpdateIf("counters", "hits", fmt.Sprint(n+1), revision); !errors.Is(err, store.ErrConflict) {
+					assert.NoError(t, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	value, _ := f.Read("counters", "hits")
+	assert.Equal(t, "10", value)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package fs
+
+import (
+	"os"
+	"sync"
+)
+
+//locks serializes the access within the process, processes sharing
+//a root are not serialized on windows
+var locks sync.Map
+
+//lock takes a lock on path, exclusive or shared, returning the function releasing it
+func lock(path string, exclusive bool) (func(), error) {
+	//the bucket must exist as on the other systems
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	value, _ := locks.LoadOrStore(path, &sync.RWMutex{})
+	l := value.(*sync.RWMutex)
+	if exclusive {
+		l.Lock()
+		return l.Unlock, nil
+	}
+	l.RLock()
+	return l.RUnlock, nil
+}
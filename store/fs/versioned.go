@@ -0,0 +1,71 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/advancedlogic/box/store"
+)
+
+//revision returns the revision of a key, "" if missing. The lock of the bucket must be held.
+func (f *Fs) revision(dir, file string) string {
+	if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+		return ""
+	}
+	meta, err := f.metadata(dir, file)
+	if err != nil {
+		return "0"
+	}
+	return strconv.FormatInt(meta.Revision, 10)
+}
+
+//Insert creates a key failing with store.ErrExists if it exists, and returns its revision
+func (f *Fs) Insert(bucket string, key string, data interface{}) (string, error) {
+	return f.put(bucket, key, data, func(current string) error {
+		if current != "" {
+			return fmt.Errorf("%s/%s: %w", bucket, key, store.ErrExists)
+		}
+		return nil
+	})
+}
+
+//ReadRevision returns the value of a key as a string and its revision
+func (f *Fs) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	dir, file, err := f.path(bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	unlock, err := lock(filepath.Join(dir, lockFile), false)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, "", err
+	}
+	return string(data), f.revision(dir, file), nil
+}
+
+//match returns a check failing with store.ErrConflict if the revision of a key differs
+func match(bucket, key, revision string) func(string) error {
+	return func(current string) error {
+		if current == "" || current != revision {
+			return fmt.Errorf("%s/%s: %w", bucket, key, store.ErrConflict)
+		}
+		return nil
+	}
+}
+
+//UpdateIf writes a key if its revision matches, failing with store.ErrConflict
+//otherwise, and returns its new revision
+func (f *Fs) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	return f.put(bucket, key, data, match(bucket, key, revision))
+}
+
+//DeleteIf deletes a key if its revision matches, failing with store.ErrConflict otherwise
+func (f *Fs) DeleteIf(bucket string, key string, revision string) error {
+	return f.remove(bucket, key, match(bucket, key, revision))
+}
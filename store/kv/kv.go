@@ -0,0 +1,432 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	errorPathEmpty     = "path cannot be empty"
+	errorTimeoutZero   = "timeout must be greater than zero"
+	errorBucketEmpty   = "bucket cannot be empty"
+	errorKeyEmpty      = "key cannot be empty"
+	errorValueType     = "value must be string or []byte"
+	errorListParams    = "list params must be a prefix, a func([]byte) or func(string, []byte) callback and optionally a *Range"
+	errorQueryParams   = "query params must be a *query.Query or a func(string, []byte) bool predicate and optionally a limit"
+	errorLimitNegative = "limit cannot be negative"
+	errorTxClosed      = "transaction closed"
+	errorBucketName    = "bucket name is reserved: %q"
+)
+
+//ErrNotFound is returned reading or deleting a missing key, it is store.ErrNotFound
+var ErrNotFound = store.ErrNotFound
+
+//WithPath sets the file of the database. Default box.db.
+func WithPath(path string) store.Option {
+	return func(i interfaces.Store) error {
+		if path != "" {
+			k := i.(*Kv)
+			k.path = path
+			return nil
+		}
+		return errors.New(errorPathEmpty)
+	}
+}
+
+//WithTimeout sets how long opening waits for another process to release the file. Default 1s.
+func WithTimeout(timeout time.Duration) store.Option {
+	return func(i interfaces.Store) error {
+		if timeout > 0 {
+			k := i.(*Kv)
+			k.timeout = timeout
+			return nil
+		}
+		return errors.New(errorTimeoutZero)
+	}
+}
+
+//WithNoSync skips the fsync of every commit: faster, but the last commits
+//can be lost if the machine crashes. Use it for tests and caches.
+func WithNoSync() store.Option {
+	return func(i interfaces.Store) error {
+		k := i.(*Kv)
+		k.noSync = true
+		return nil
+	}
+}
+
+//Range limits the keys visited by List to the ones from From, included, to To,
+//excluded, at most Limit of them. Empty bounds and zero limit are unlimited.
+type Range struct {
+	From  string
+	To    string
+	Limit int
+}
+
+//Kv implements the Store interface on an embedded B+tree database,
+//a single file that only one process can open at a time.
+//Buckets are created by the first write, values are returned as strings.
+//Every write gives the key a new revision for the conditional writes.
+type Kv struct {
+	path    string
+	timeout time.Duration
+	noSync  bool
+	db      *bolt.DB
+}
+
+//New opens the database, creating it if missing
+func New(options ...store.Option) (*Kv, error) {
+	k := &Kv{
+		path:    "box.db",
+		timeout: time.Second,
+	}
+	for _, option := range options {
+		if err := option(k); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(k.path, 0600, &bolt.Options{Timeout: k.timeout, NoSync: k.noSync})
+	if err != nil {
+		return nil, err
+	}
+	k.db = db
+	return k, nil
+}
+
+func (k *Kv) Instance() interface{} {
+	return k.db
+}
+
+//Check verifies that the database is open
+func (k *Kv) Check(ctx context.Context) error {
+	return k.db.View(func(*bolt.Tx) error { return nil })
+}
+
+func (k *Kv) Close() error {
+	return k.db.Close()
+}
+
+func (k *Kv) Create(bucket string, key string, data interface{}) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		return (&Tx{tx: tx}).Create(bucket, key, data)
+	})
+}
+
+func (k *Kv) Read(bucket string, key string) (interface{}, error) {
+	var value interface{}
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, err = (&Tx{tx: tx}).Read(bucket, key)
+		return err
+	})
+	return value, err
+}
+
+func (k *Kv) Update(bucket string, key string, data interface{}) error {
+	return k.Create(bucket, key, data)
+}
+
+func (k *Kv) Delete(bucket string, key string) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		return (&Tx{tx: tx}).Delete(bucket, key)
+	})
+}
+
+//Scans reports that List passes the values to a callback
+func (k *Kv) Scans() bool {
+	return true
+}
+
+//List passes the values of the keys starting with a prefix to a callback, in the
+//lexical order of the keys: List(bucket, prefix string, callback), the callback being
+//a func([]byte) or a func(string, []byte) receiving the key too.
+//An optional *Range limits the keys.
+func (k *Kv) List(bucket string, params ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = (&Tx{tx: tx}).List(bucket, params...)
+		return err
+	})
+	return result, err
+}
+
+//Query evaluates a *query.Query on the JSON objects of a bucket: Query(bucket, q).
+//It also returns the values whose key and value satisfy a predicate, in the lexical
+//order of the keys: Query(bucket, predicate func(string, []byte) bool), with an
+//optional limit of values.
+func (k *Kv) Query(bucket string, params ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = (&Tx{tx: tx}).Query(bucket, params...)
+		return err
+	})
+	return result, err
+}
+
+func (k *Kv) Buckets() (interface{}, error) {
+	var result interface{}
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = (&Tx{tx: tx}).Buckets()
+		return err
+	})
+	return result, err
+}
+
+//Transaction runs fn in a read-write transaction, committed if fn returns nil
+//and rolled back otherwise. The Store passed to fn must not be used after fn returns.
+//Transactions are serialized, a read-write transaction at a time.
+func (k *Kv) Transaction(fn func(interfaces.Store) error) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		t := &Tx{tx: tx}
+		defer func() { t.tx = nil }()
+		return fn(t)
+	})
+}
+
+//Backup writes a consistent copy of the database to a file while it is in use
+func (k *Kv) Backup(path string) error {
+	return k.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+//BackupTo writes a consistent copy of the database to w while it is in use
+//and returns the bytes written
+func (k *Kv) BackupTo(w io.Writer) (int64, error) {
+	var written int64
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+		return err
+	})
+	return written, err
+}
+
+//Tx implements the Store interface within a transaction
+type Tx struct {
+	tx *bolt.Tx
+}
+
+func (t *Tx) open() (*bolt.Tx, error) {
+	if t.tx == nil {
+		return nil, errors.New(errorTxClosed)
+	}
+	return t.tx, nil
+}
+
+func validBucket(bucket string) error {
+	if bucket == "" {
+		return errors.New(errorBucketEmpty)
+	}
+	if bucket == revisions {
+		return fmt.Errorf(errorBucketName, bucket)
+	}
+	return nil
+}
+
+func (t *Tx) bucket(bucket string) (*bolt.Bucket, error) {
+	if err := validBucket(bucket); err != nil {
+		return nil, err
+	}
+	tx, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	return tx.Bucket([]byte(bucket)), nil
+}
+
+func value(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	}
+	return nil, errors.New(errorValueType)
+}
+
+func (t *Tx) Create(bucket string, key string, data interface{}) error {
+	_, err := t.write(bucket, key, data)
+	return err
+}
+
+func (t *Tx) Read(bucket string, key string) (interface{}, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		if v := b.Get([]byte(key)); v != nil {
+			//the slice is valid only within the transaction
+			return string(v), nil
+		}
+	}
+	return nil, fmt.Errorf("%s/%s: %w", bucket, key, ErrNotFound)
+}
+
+func (t *Tx) Update(bucket string, key string, data interface{}) error {
+	return t.Create(bucket, key, data)
+}
+
+func (t *Tx) Delete(bucket string, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	if b == nil || b.Get([]byte(key)) == nil {
+		return fmt.Errorf("%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	return t.remove(b, bucket, key)
+}
+
+//Scans reports that List passes the values to a callback
+func (t *Tx) Scans() bool {
+	return true
+}
+
+func (t *Tx) List(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errors.New(errorListParams)
+	}
+	prefix, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New(errorListParams)
+	}
+	var callback func(string, []byte)
+	switch c := params[1].(type) {
+	case func([]byte):
+		callback = func(_ string, v []byte) { c(v) }
+	case func(string, []byte):
+		callback = c
+	default:
+		return nil, errors.New(errorListParams)
+	}
+	r := &Range{}
+	if len(params) > 2 {
+		if r, ok = params[2].(*Range); !ok {
+			return nil, errors.New(errorListParams)
+		}
+		if r.Limit < 0 {
+			return nil, errors.New(errorLimitNegative)
+		}
+	}
+
+	b, err := t.bucket(bucket)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	start := []byte(prefix)
+	if r.From > prefix {
+		start = []byte(r.From)
+	}
+	c := b.Cursor()
+	visited := 0
+	for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+		if r.To != "" && string(k) >= r.To {
+			break
+		}
+		if r.Limit > 0 && visited == r.Limit {
+			break
+		}
+		//copied, the slice is valid only within the transaction
+		callback(string(k), append([]byte(nil), v...))
+		visited++
+	}
+	return nil, nil
+}
+
+func (t *Tx) Query(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, errors.New(errorQueryParams)
+	}
+	if q, ok := params[0].(*query.Query); ok {
+		return t.query(bucket, q)
+	}
+	predicate, ok := params[0].(func(string, []byte) bool)
+	if !ok {
+		return nil, errors.New(errorQueryParams)
+	}
+	limit := 0
+	if len(params) > 1 {
+		if limit, ok = params[1].(int); !ok {
+			return nil, errors.New(errorQueryParams)
+		}
+		if limit < 0 {
+			return nil, errors.New(errorLimitNegative)
+		}
+	}
+
+	values := make([]string, 0)
+	b, err := t.bucket(bucket)
+	if err != nil || b == nil {
+		return values, err
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		if limit > 0 && len(values) == limit {
+			return errStop
+		}
+		if predicate(string(k), v) {
+			values = append(values, string(v))
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	return values, nil
+}
+
+//query evaluates a query iterating the bucket within the transaction,
+//stopping as soon as enough documents match
+func (t *Tx) query(bucket string, q *query.Query) ([]map[string]interface{}, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	matched := make([]map[string]interface{}, 0)
+	b, err := t.bucket(bucket)
+	if err != nil || b == nil {
+		return matched, err
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && !q.Enough(len(matched)); k, v = c.Next() {
+		document, err := query.Decode(v)
+		if err != nil {
+			continue
+		}
+		if q.Match(document) {
+			matched = append(matched, document)
+		}
+	}
+	return q.Apply(matched), nil
+}
+
+//errStop stops an iteration
+var errStop = errors.New("stop")
+
+func (t *Tx) Buckets() (interface{}, error) {
+	tx, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	err = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if string(name) == revisions {
+			return nil
+		}
+		names = append(names, string(name))
+		return nil
+	})
+	return names, err
+}
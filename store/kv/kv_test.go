@@ -0,0 +1,221 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKv(t *testing.T) *Kv {
+	k, err := New(WithPath(filepath.Join(t.TempDir(), "test.db")), WithNoSync())
+	assert.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithPath(""))
+	assert.Error(t, err)
+	_, err = New(WithTimeout(0))
+	assert.Error(t, err)
+	k := newKv(t)
+	assert.NoError(t, k.Check(nil))
+}
+
+func TestKv_CRUD(t *testing.T) {
+	k := newKv(t)
+	assert.NoError(t, k.Create("users", "alice", "admin"))
+	value, err := k.Read("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	assert.NoError(t, k.Update("users", "alice", []byte("user")))
+	value, _ = k.Read("users", "alice")
+	assert.Equal(t, "user", value)
+
+	assert.NoError(t, k.Delete("users", "alice"))
+	_, err = k.Read("users", "alice")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(k.Delete("users", "alice"), ErrNotFound))
+	_, err = k.Read("missing", "alice")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Error(t, k.Create("", "alice", "admin"))
+	assert.Error(t, k.Create("users", "", "admin"))
+	assert.Error(t, k.Create("users", "alice", 42))
+}
+
+func TestKv_Transaction(t *testing.T) {
+	k := newKv(t)
+	assert.NoError(t, k.Create("accounts", "alice", "100"))
+
+	err := k.Transaction(func(tx interfaces.Store) error {
+		assert.NoError(t, tx.Update("accounts", "alice", "50"))
+		assert.NoError(t, tx.Create("accounts", "bob", "50"))
+		value, _ := tx.Read("accounts", "bob")
+		assert.Equal(t, "50", value)
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+	value, _ := k.Read("accounts", "alice")
+	assert.Equal(t, "100", value)
+	_, err = k.Read("accounts", "bob")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	var leaked interfaces.Store
+	err = k.Transaction(func(tx interfaces.Store) error {
+		leaked = tx
+		if err := tx.Update("accounts", "alice", "50"); err != nil {
+			return err
+		}
+		return tx.Create("accounts", "bob", "50")
+	})
+	assert.NoError(t, err)
+	value, _ = k.Read("accounts", "bob")
+	assert.Equal(t, "50", value)
+	assert.Error(t, leaked.Create("accounts", "carol", "0"))
+}
+
+func TestKv_List(t *testing.T) {
+	k := newKv(t)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, k.Create("events", fmt.Sprintf("order/%02d", i), fmt.Sprint(i)))
+	}
+	assert.NoError(t, k.Create("events", "payment/01", "payment"))
+
+	values := make([]string, 0)
+	_, err := k.List("events", "order/", func(v []byte) { values = append(values, string(v)) })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, values)
+
+	keys := make([]string, 0)
+	_, err = k.List("events", "order/", func(key string, _ []byte) { keys = append(keys, key) },
+		&Range{From: "order/03", To: "order/08", Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order/03", "order/04", "order/05"}, keys)
+
+	keys = keys[:0]
+	_, err = k.List("events", "", func(key string, _ []byte) { keys = append(keys, key) }, &Range{From: "order/08"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order/08", "order/09", "payment/01"}, keys)
+
+	_, err = k.List("events", "order/")
+	assert.Error(t, err)
+	_, err = k.List("missing", "", func([]byte) { t.Fatal("unexpected value") })
+	assert.NoError(t, err)
+}
+
+func TestKv_Query(t *testing.T) {
+	k := newKv(t)
+	assert.NoError(t, k.Create("users", "alice", `{"role":"admin"}`))
+	assert.NoError(t, k.Create("users", "bob", `{"role":"user"}`))
+	assert.NoError(t, k.Create("users", "carol", `{"role":"admin"}`))
+
+	admins := func(key string, value []byte) bool {
+		return strings.Contains(string(value), `"admin"`)
+	}
+	values, err := k.Query("users", admins)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"role":"admin"}`, `{"role":"admin"}`}, values)
+	values, err = k.Query("users", admins, 1)
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+	_, err = k.Query("users")
+	assert.Error(t, err)
+	_, err = k.Query("users", admins, -1)
+	assert.Error(t, err)
+}
+
+func TestKv_Backup(t *testing.T) {
+	k := newKv(t)
+	assert.NoError(t, k.Create("users", "alice", "admin"))
+	assert.NoError(t, k.Create("groups", "admin", "alice"))
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	assert.NoError(t, k.Backup(path))
+	backup, err := New(WithPath(path))
+	assert.NoError(t, err)
+	defer backup.Close()
+	value, err := backup.Read("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	buckets, _ := backup.Buckets()
+	assert.Equal(t, []string{"groups", "users"}, buckets)
+
+	buffer := &bytes.Buffer{}
+	written, err := k.BackupTo(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buffer.Len()), written)
+}
+
+func TestKv_QueryDocuments(t *testing.T) {
+	k := newKv(t)
+	assert.NoError(t, k.Create("users", "alice", `{"name":"alice","age":34}`))
+	assert.NoError(t, k.Create("users", "bob", `{"name":"bob","age":27}`))
+	assert.NoError(t, k.Create("users", "carol", `{"name":"carol","age":41}`))
+	assert.NoError(t, k.Create("users", "raw", "not json"))
+
+	result, err := k.Query("users", &query.Query{
+		Where:  query.Gt("age", 30),
+		Sort:   []query.Sort{{Field: "age", Descending: true}},
+		Fields: []string{"name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"name": "carol"}, {"name": "alice"}}, result)
+
+	result, err = k.Query("users", &query.Query{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	_, err = k.Query("users", &query.Query{Where: &query.Expression{Field: "age", Operator: "like"}})
+	assert.Error(t, err)
+}
+
+func TestKv_Versioned(t *testing.T) {
+	k := newKv(t)
+	var _ interfaces.Versioned = k
+	revision, err := k.Insert("users", "alice", "admin")
+	assert.NoError(t, err)
+	_, err = k.Insert("users", "alice", "user")
+	assert.True(t, errors.Is(err, store.ErrExists))
+
+	value, read, err := k.ReadRevision("users", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	assert.Equal(t, revision, read)
+
+	updated, err := k.UpdateIf("users", "alice", "user", revision)
+	assert.NoError(t, err)
+	assert.NotEqual(t, revision, updated)
+	//a stale revision does not overwrite the concurrent update
+	_, err = k.UpdateIf("users", "alice", "guest", revision)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	value, _ = k.Read("users", "alice")
+	assert.Equal(t, "user", value)
+
+	//unconditional writes change the revision too
+	assert.NoError(t, k.Update("users", "alice", "owner"))
+	assert.True(t, errors.Is(k.DeleteIf("users", "alice", updated), store.ErrConflict))
+	_, current, _ := k.ReadRevision("users", "alice")
+	assert.NoError(t, k.DeleteIf("users", "alice", current))
+	_, _, err = k.ReadRevision("users", "alice")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = k.UpdateIf("users", "alice", "user", current)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+
+	buckets, _ := k.Buckets()
+	assert.Equal(t, []string{"users"}, buckets)
+	assert.Error(t, k.Create(revisions, "alice", "admin"))
+
+	err = k.Transaction(func(s interfaces.Store) error {
+		_, err := s.(interfaces.Versioned).Insert("users", "bob", "user")
+		return err
+	})
+	assert.NoError(t, err)
+}
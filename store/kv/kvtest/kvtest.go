@@ -0,0 +1,21 @@
+//Package kvtest provides a Kv store for the tests of the packages built on a Store
+package kvtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/advancedlogic/box/store/kv"
+)
+
+//New opens a Kv store in a temporary directory without syncing to disk,
+//closed when the test ends
+func New(t testing.TB) *kv.Kv {
+	t.Helper()
+	k, err := kv.New(kv.WithPath(filepath.Join(t.TempDir(), "test.db")), kv.WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
@@ -0,0 +1,187 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/advancedlogic/box/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+//revisions is the bucket holding the revisions of the keys, a nested bucket
+//for every bucket. The revision of a key is the sequence of its bucket at its
+//last write, the keys written before revisions were kept have revision 0.
+const revisions = ".revisions"
+
+//write writes the value of a key and returns its new revision
+func (t *Tx) write(bucket string, key string, data interface{}) (string, error) {
+	if err := validBucket(bucket); err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", errors.New(errorKeyEmpty)
+	}
+	v, err := value(data)
+	if err != nil {
+		return "", err
+	}
+	tx, err := t.open()
+	if err != nil {
+		return "", err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return "", err
+	}
+	sequence, err := b.NextSequence()
+	if err != nil {
+		return "", err
+	}
+	if err := b.Put([]byte(key), v); err != nil {
+		return "", err
+	}
+	r, err := tx.CreateBucketIfNotExists([]byte(revisions))
+	if err != nil {
+		return "", err
+	}
+	if r, err = r.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+		return "", err
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, sequence)
+	if err := r.Put([]byte(key), encoded); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(sequence, 10), nil
+}
+
+//remove deletes a key and its revision
+func (t *Tx) remove(b *bolt.Bucket, bucket string, key string) error {
+	if err := b.Delete([]byte(key)); err != nil {
+		return err
+	}
+	if r := t.tx.Bucket([]byte(revisions)); r != nil {
+		if r = r.Bucket([]byte(bucket)); r != nil {
+			return r.Delete([]byte(key))
+		}
+	}
+	return nil
+}
+
+//revision returns the value and the revision of a key, a nil value if missing
+func (t *Tx) revision(bucket string, key string) ([]byte, string, error) {
+	b, err := t.bucket(bucket)
+	if err != nil || b == nil {
+		return nil, "", err
+	}
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, "", nil
+	}
+	if r := t.tx.Bucket([]byte(revisions)); r != nil {
+		if r = r.Bucket([]byte(bucket)); r != nil {
+			if encoded := r.Get([]byte(key)); len(encoded) == 8 {
+				return v, strconv.FormatUint(binary.BigEndian.Uint64(encoded), 10), nil
+			}
+		}
+	}
+	return v, "0", nil
+}
+
+//Insert creates a key failing with store.ErrExists if it exists
+func (t *Tx) Insert(bucket string, key string, data interface{}) (string, error) {
+	v, _, err := t.revision(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if v != nil {
+		return "", fmt.Errorf("%s/%s: %w", bucket, key, store.ErrExists)
+	}
+	return t.write(bucket, key, data)
+}
+
+func (t *Tx) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	v, revision, err := t.revision(bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if v == nil {
+		return nil, "", fmt.Errorf("%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	//the slice is valid only within the transaction
+	return string(v), revision, nil
+}
+
+//match fails with store.ErrConflict if the revision of a key differs
+func (t *Tx) match(bucket string, key string, revision string) error {
+	v, current, err := t.revision(bucket, key)
+	if err != nil {
+		return err
+	}
+	if v == nil || current != revision {
+		return fmt.Errorf("%s/%s: %w", bucket, key, store.ErrConflict)
+	}
+	return nil
+}
+
+func (t *Tx) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	if err := t.match(bucket, key, revision); err != nil {
+		return "", err
+	}
+	return t.write(bucket, key, data)
+}
+
+func (t *Tx) DeleteIf(bucket string, key string, revision string) error {
+	if err := t.match(bucket, key, revision); err != nil {
+		return err
+	}
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return t.remove(b, bucket, key)
+}
+
+//Insert creates a key failing with store.ErrExists if it exists, and returns its revision
+func (k *Kv) Insert(bucket string, key string, data interface{}) (string, error) {
+	var revision string
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		revision, err = (&Tx{tx: tx}).Insert(bucket, key, data)
+		return err
+	})
+	return revision, err
+}
+
+//ReadRevision returns the value of a key as a string and its revision
+func (k *Kv) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	var value interface{}
+	var revision string
+	err := k.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, revision, err = (&Tx{tx: tx}).ReadRevision(bucket, key)
+		return err
+	})
+	return value, revision, err
+}
+
+//UpdateIf writes a key if its revision matches, failing with store.ErrConflict
+//otherwise, and returns its new revision
+func (k *Kv) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	var updated string
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		updated, err = (&Tx{tx: tx}).UpdateIf(bucket, key, data, revision)
+		return err
+	})
+	return updated, err
+}
+
+//DeleteIf deletes a key if its revision matches, failing with store.ErrConflict otherwise
+func (k *Kv) DeleteIf(bucket string, key string, revision string) error {
+	return k.db.Update(func(tx *bolt.Tx) error {
+		return (&Tx{tx: tx}).DeleteIf(bucket, key, revision)
+	})
+}
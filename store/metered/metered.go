@@ -0,0 +1,141 @@
+package metered
+
+import (
+	"errors"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+)
+
+//WithStore sets the store to be measured
+func WithStore(s interfaces.Store) store.Option {
+	return func(i interfaces.Store) error {
+		if s != nil {
+			m := i.(*Metered)
+			m.store = s
+			return nil
+		}
+		return errors.New("store cannot be nil")
+	}
+}
+
+func WithMetrics(metrics interfaces.Metrics) store.Option {
+	return func(i interfaces.Store) error {
+		if metrics != nil {
+			m := i.(*Metered)
+			m.metrics = metrics
+			return nil
+		}
+		return errors.New("metrics cannot be nil")
+	}
+}
+
+//WithName sets the store label of the metrics. Default store.
+func WithName(name string) store.Option {
+	return func(i interfaces.Store) error {
+		if name != "" {
+			m := i.(*Metered)
+			m.name = name
+			return nil
+		}
+		return errors.New("name cannot be empty")
+	}
+}
+
+//Metered wraps a Store measuring the latency and the errors of every call
+type Metered struct {
+	store   interfaces.Store
+	metrics interfaces.Metrics
+	name    string
+}
+
+//New returns a *Metered, a *Versioned if the store measured keeps revisions or
+//a *Historian if it keeps their history, supporting what the store supports
+func New(options ...store.Option) (interfaces.Store, error) {
+	m := &Metered{
+		name: "store",
+	}
+	for _, option := range options {
+		if err := option(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.store == nil {
+		return nil, errors.New("store cannot be nil")
+	}
+	if m.metrics == nil {
+		return nil, errors.New("metrics cannot be nil")
+	}
+	return m.wrap(), nil
+}
+
+func (m *Metered) measure(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.metrics.Counter("store_operations_total", 1, map[string]string{
+		"store":     m.name,
+		"operation": operation,
+		"result":    result,
+	})
+	m.metrics.Histogram("store_operation_duration_seconds", time.Since(start).Seconds(), map[string]string{
+		"store":     m.name,
+		"operation": operation,
+	})
+}
+
+func (m *Metered) Create(bucket string, key string, data interface{}) error {
+	start := time.Now()
+	err := m.store.Create(bucket, key, data)
+	m.measure("create", start, err)
+	return err
+}
+
+func (m *Metered) Read(bucket string, key string) (interface{}, error) {
+	start := time.Now()
+	value, err := m.store.Read(bucket, key)
+	m.measure("read", start, err)
+	return value, err
+}
+
+func (m *Metered) Update(bucket string, key string, data interface{}) error {
+	start := time.Now()
+	err := m.store.Update(bucket, key, data)
+	m.measure("update", start, err)
+	return err
+}
+
+func (m *Metered) Delete(bucket string, key string) error {
+	start := time.Now()
+	err := m.store.Delete(bucket, key)
+	m.measure("delete", start, err)
+	return err
+}
+
+//Scans reports whether the List of the store measured passes the values to a callback
+func (m *Metered) Scans() bool {
+	return store.Scanning(m.store) == nil
+}
+
+func (m *Metered) List(bucket string, params ...interface{}) (interface{}, error) {
+	start := time.Now()
+	value, err := m.store.List(bucket, params...)
+	m.measure("list", start, err)
+	return value, err
+}
+
+func (m *Metered) Query(bucket string, params ...interface{}) (interface{}, error) {
+	start := time.Now()
+	value, err := m.store.Query(bucket, params...)
+	m.measure("query", start, err)
+	return value, err
+}
+
+func (m *Metered) Buckets() (interface{}, error) {
+	start := time.Now()
+	value, err := m.store.Buckets()
+	m.measure("buckets", start, err)
+	return value, err
+}
@@ -0,0 +1,73 @@
+package metered
+
+import (
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+)
+
+//Versioned is a Metered store whose store keeps revisions, the conditional
+//writes are measured like the other calls
+type Versioned struct {
+	*Metered
+	versioned interfaces.Versioned
+}
+
+//Historian is a Versioned store whose store keeps the history of the revisions
+type Historian struct {
+	*Versioned
+	historian interfaces.Historian
+}
+
+//wrap returns the variant supporting what the store measured supports
+func (m *Metered) wrap() interfaces.Store {
+	if h, ok := m.store.(interfaces.Historian); ok {
+		return &Historian{Versioned: &Versioned{Metered: m, versioned: h}, historian: h}
+	}
+	if v, ok := m.store.(interfaces.Versioned); ok {
+		return &Versioned{Metered: m, versioned: v}
+	}
+	return m
+}
+
+func (v *Versioned) Insert(bucket string, key string, data interface{}) (string, error) {
+	start := time.Now()
+	revision, err := v.versioned.Insert(bucket, key, data)
+	v.measure("insert", start, err)
+	return revision, err
+}
+
+func (v *Versioned) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	start := time.Now()
+	value, revision, err := v.versioned.ReadRevision(bucket, key)
+	v.measure("read_revision", start, err)
+	return value, revision, err
+}
+
+func (v *Versioned) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	start := time.Now()
+	updated, err := v.versioned.UpdateIf(bucket, key, data, revision)
+	v.measure("update_if", start, err)
+	return updated, err
+}
+
+func (v *Versioned) DeleteIf(bucket string, key string, revision string) error {
+	start := time.Now()
+	err := v.versioned.DeleteIf(bucket, key, revision)
+	v.measure("delete_if", start, err)
+	return err
+}
+
+func (h *Historian) History(bucket string, key string) ([]interfaces.Revision, error) {
+	start := time.Now()
+	revisions, err := h.historian.History(bucket, key)
+	h.measure("history", start, err)
+	return revisions, err
+}
+
+func (h *Historian) ReadAt(bucket string, key string, revision string) (interface{}, error) {
+	start := time.Now()
+	value, err := h.historian.ReadAt(bucket, key, revision)
+	h.measure("read_at", start, err)
+	return value, err
+}
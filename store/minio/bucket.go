@@ -0,0 +1,187 @@
+package minio
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+const (
+	errorRuleID    = "rule id cannot be empty"
+	errorRuleDays  = "rule must expire the objects or their noncurrent versions after at least one day"
+	errorRuleDup   = "rule id is duplicated"
+	errorRulesNone = "lifecycle needs at least one rule"
+	errorTagKey    = "tag key cannot be empty"
+)
+
+//Rule expires the objects of a bucket whose key starts with Prefix
+type Rule struct {
+	ID     string
+	Prefix string
+	//Days after which the current version of an object expires, 0 to keep it
+	Days int
+	//NoncurrentDays after which the previous versions of an object expire, 0 to keep them
+	NoncurrentDays int
+}
+
+type lifecycleRule struct {
+	ID     string `xml:"ID"`
+	Filter struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"Filter"`
+	Status     string `xml:"Status"`
+	Expiration *struct {
+		Days int `xml:"Days"`
+	} `xml:"Expiration,omitempty"`
+	NoncurrentVersionExpiration *struct {
+		NoncurrentDays int `xml:"NoncurrentDays"`
+	} `xml:"NoncurrentVersionExpiration,omitempty"`
+}
+
+type lifecycle struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"TagSet>Tag"`
+}
+
+func validRules(rules []Rule) error {
+	if len(rules) == 0 {
+		return errors.New(errorRulesNone)
+	}
+	ids := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return errors.New(errorRuleID)
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("%s: %s", errorRuleDup, rule.ID)
+		}
+		ids[rule.ID] = true
+		if rule.Days < 0 || rule.NoncurrentDays < 0 || rule.Days+rule.NoncurrentDays == 0 {
+			return fmt.Errorf("%s: %s", errorRuleDays, rule.ID)
+		}
+	}
+	return nil
+}
+
+//encodeLifecycle returns the S3 lifecycle configuration of the rules
+func encodeLifecycle(rules []Rule) (string, error) {
+	config := lifecycle{Rules: make([]lifecycleRule, len(rules))}
+	for r, rule := range rules {
+		encoded := &config.Rules[r]
+		encoded.ID = rule.ID
+		encoded.Filter.Prefix = rule.Prefix
+		encoded.Status = "Enabled"
+		if rule.Days > 0 {
+			encoded.Expiration = &struct {
+				Days int `xml:"Days"`
+			}{rule.Days}
+		}
+		if rule.NoncurrentDays > 0 {
+			encoded.NoncurrentVersionExpiration = &struct {
+				NoncurrentDays int `xml:"NoncurrentDays"`
+			}{rule.NoncurrentDays}
+		}
+	}
+	data, err := xml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//decodeLifecycle returns the enabled rules of a S3 lifecycle configuration
+func decodeLifecycle(data string) ([]Rule, error) {
+	var config lifecycle
+	if err := xml.Unmarshal([]byte(data), &config); err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0, len(config.Rules))
+	for _, encoded := range config.Rules {
+		if encoded.Status != "Enabled" {
+			continue
+		}
+		rule := Rule{ID: encoded.ID, Prefix: encoded.Filter.Prefix}
+		if encoded.Expiration != nil {
+			rule.Days = encoded.Expiration.Days
+		}
+		if encoded.NoncurrentVersionExpiration != nil {
+			rule.NoncurrentDays = encoded.NoncurrentVersionExpiration.NoncurrentDays
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+//SetVersioning enables or suspends the versioning of a bucket
+func (m *Minio) SetVersioning(ctx context.Context, bucket string, enabled bool) error {
+	if enabled {
+		return m.client.EnableVersioningWithContext(ctx, m.name(bucket))
+	}
+	return m.client.DisableVersioningWithContext(ctx, m.name(bucket))
+}
+
+//SetLifecycle replaces the lifecycle rules of a bucket, no rules removes them
+func (m *Minio) SetLifecycle(ctx context.Context, bucket string, rules ...Rule) error {
+	if len(rules) == 0 {
+		return m.client.SetBucketLifecycleWithContext(ctx, m.name(bucket), "")
+	}
+	if err := validRules(rules); err != nil {
+		return err
+	}
+	config, err := encodeLifecycle(rules)
+	if err != nil {
+		return err
+	}
+	return m.client.SetBucketLifecycleWithContext(ctx, m.name(bucket), config)
+}
+
+//Lifecycle returns the enabled lifecycle rules of a bucket
+func (m *Minio) Lifecycle(bucket string) ([]Rule, error) {
+	config, err := m.client.GetBucketLifecycle(m.name(bucket))
+	if err != nil {
+		return nil, err
+	}
+	if config == "" {
+		return nil, nil
+	}
+	return decodeLifecycle(config)
+}
+
+//SetTags replaces the tags of an object
+func (m *Minio) SetTags(ctx context.Context, bucket string, key string, tags map[string]string) error {
+	for k := range tags {
+		if k == "" {
+			return errors.New(errorTagKey)
+		}
+	}
+	if len(tags) == 0 {
+		return m.client.RemoveObjectTaggingWithContext(ctx, m.name(bucket), key)
+	}
+	return m.client.PutObjectTaggingWithContext(ctx, m.name(bucket), key, tags)
+}
+
+//Tags returns the tags of an object
+func (m *Minio) Tags(ctx context.Context, bucket string, key string) (map[string]string, error) {
+	data, err := m.client.GetObjectTaggingWithContext(ctx, m.name(bucket), key)
+	if err != nil {
+		return nil, err
+	}
+	var encoded tagging
+	if err := xml.Unmarshal([]byte(data), &encoded); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(encoded.Tags))
+	for _, tag := range encoded.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
@@ -1,26 +1,57 @@
 package minio
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"strings"
 	"sync"
 
 	"github.com/advancedlogic/box/interfaces"
 	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
 	minio "github.com/minio/minio-go/v6"
 )
 
+const (
+	errorQueryParams = "query params must be a *query.Query"
+	errorListParams  = "list params must be a prefix and a func([]byte) or func(string, []byte) callback"
+	errorValueType   = "value must be a string, a []byte or an io.Reader"
+	errorCAEmpty     = "ca file cannot be empty"
+	errorCAInvalid   = "no certificate found in ca file"
+)
+
+//Minio is a store on a S3-compatible server, safe for concurrent use.
+//The revisions of the objects are their ETags. S3 has no conditional writes,
+//so the conditional writes check the ETag then write, serialized within the
+//process but not among processes sharing a bucket.
+//
+//Minio is not an interfaces.Historian, even on versioned buckets: minio-go v6 can
+//neither list nor read the versions of an object, and an ETag, the hash of the
+//content, does not identify a version, a value written back gets it again.
 type Minio struct {
 	location  string
 	endpoint  string
 	bucket    string
 	accessKey string
 	secretKey string
-	lock      sync.RWMutex
-	client    *minio.Client
+	secure    bool
+	tls       *tls.Config
+	//defaults of the buckets created by CreateBucketIfNotExists
+	versioning bool
+	rules      []Rule
+	client     *minio.Client
+	//serializes the conditional writes
+	writing sync.Mutex
 }
 
+//WithLocation sets the region of the server and of the buckets created. Default us-east-1.
 func WithLocation(location string) store.Option {
 	return func(i interfaces.Store) error {
 		if location != "" {
@@ -32,6 +63,7 @@ func WithLocation(location string) store.Option {
 	}
 }
 
+//WithBucket sets the bucket used when the bucket passed is empty
 func WithBucket(bucket string) store.Option {
 	return func(i interfaces.Store) error {
 		if bucket != "" {
@@ -39,7 +71,7 @@ func WithBucket(bucket string) store.Option {
 			m.bucket = bucket
 			return nil
 		}
-		return errors.New("location cannot be empty")
+		return errors.New("bucket cannot be empty")
 	}
 }
 
@@ -88,12 +120,73 @@ func WithCredentials(accessKey, secretKey string) store.Option {
 	}
 }
 
+//WithTLS connects with TLS, the config can carry a client certificate or a nil
+//config uses the system roots
+func WithTLS(config *tls.Config) store.Option {
+	return func(i interfaces.Store) error {
+		m := i.(*Minio)
+		m.secure = true
+		if config != nil {
+			m.tls = config.Clone()
+		}
+		return nil
+	}
+}
+
+//WithCA connects with TLS trusting the certificates of the PEM file, along with the system roots
+func WithCA(file string) store.Option {
+	return func(i interfaces.Store) error {
+		if file == "" {
+			return errors.New(errorCAEmpty)
+		}
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		m := i.(*Minio)
+		if m.tls == nil {
+			m.tls = &tls.Config{}
+		}
+		if m.tls.RootCAs == nil {
+			if m.tls.RootCAs, err = x509.SystemCertPool(); err != nil {
+				m.tls.RootCAs = x509.NewCertPool()
+			}
+		}
+		if !m.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: %s", errorCAInvalid, file)
+		}
+		m.secure = true
+		return nil
+	}
+}
+
+//WithVersioning enables the versioning of the buckets created. The versions
+//are kept by the server, the store reads the latest ones only.
+func WithVersioning() store.Option {
+	return func(i interfaces.Store) error {
+		m := i.(*Minio)
+		m.versioning = true
+		return nil
+	}
+}
+
+//WithLifecycle sets the lifecycle rules of the buckets created
+func WithLifecycle(rules ...Rule) store.Option {
+	return func(i interfaces.Store) error {
+		if err := validRules(rules); err != nil {
+			return err
+		}
+		m := i.(*Minio)
+		m.rules = append(m.rules, rules...)
+		return nil
+	}
+}
+
 func New(options ...store.Option) (*Minio, error) {
 	m := &Minio{
-		location: "default",
+		location: "us-east-1",
 		bucket:   "default",
 		endpoint: "localhost:9000",
-		lock:     sync.RWMutex{},
 	}
 
 	for _, option := range options {
@@ -102,86 +195,102 @@ func New(options ...store.Option) (*Minio, error) {
 		}
 	}
 
-	client, err := minio.New(m.endpoint, m.accessKey, m.secretKey, false)
+	client, err := minio.NewWithRegion(m.endpoint, m.accessKey, m.secretKey, m.secure, m.location)
 	if err != nil {
 		return nil, err
 	}
+	if m.tls != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = m.tls
+		client.SetCustomTransport(transport)
+	}
 	m.client = client
 
 	return m, nil
 }
 
-func (m Minio) Instance() interface{} {
-	return nil
+//name returns the bucket, or the default one if empty
+func (m *Minio) name(bucket string) string {
+	if bucket == "" {
+		return m.bucket
+	}
+	return bucket
 }
 
-func (m Minio) Buckets() (interface{}, error) {
+func (m *Minio) Instance() interface{} {
+	return m.client
+}
+
+func (m *Minio) Buckets() (interface{}, error) {
 	buckets, err := m.client.ListBuckets()
 	if err != nil {
 		return nil, err
 	}
 	names := make([]string, len(buckets), len(buckets))
-	for b, bucket := range buckets  {
+	for b, bucket := range buckets {
 		names[b] = bucket.Name
 	}
 
 	return names, nil
 }
 
-func (m Minio) CreateBucketIfNotExists(bucket string) error {
-	buckets, err := m.client.ListBuckets()
-	if err != nil {
+//Check verifies that the minio server is reachable
+func (m *Minio) Check(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := m.client.ListBucketsWithContext(ctx)
+	return err
+}
+
+//CreateBucketIfNotExists creates a bucket in the configured location, with
+//the versioning and the lifecycle rules configured
+func (m *Minio) CreateBucketIfNotExists(bucket string) error {
+	bucket = m.name(bucket)
+	exists, err := m.client.BucketExists(bucket)
+	if err != nil || exists {
 		return err
 	}
-	exists := false
-	for _, b := range buckets {
-		if bucket == b.Name {
-			exists = true
-			break
-		}
+	if err := m.client.MakeBucket(bucket, m.location); err != nil {
+		return err
 	}
-	if !exists {
-		err = m.client.MakeBucket(bucket, "")
-		if err != nil {
+	ctx := context.Background()
+	if m.versioning {
+		if err := m.SetVersioning(ctx, bucket, true); err != nil {
 			return err
 		}
 	}
-
+	if len(m.rules) > 0 {
+		return m.SetLifecycle(ctx, bucket, m.rules...)
+	}
 	return nil
 }
 
+//Create uploads the value of a key, a string, a []byte or an io.Reader
 func (m *Minio) Create(bucket string, key string, data interface{}) error {
-	client := m.client
-	reader := strings.NewReader(data.(string))
-
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	_, err := client.PutObject(bucket, key, reader, -1, minio.PutObjectOptions{
-		ContentType: "plain/txt",
-	})
-	if err != nil {
-		return err
+	var reader io.Reader
+	size := int64(-1)
+	switch value := data.(type) {
+	case string:
+		reader, size = strings.NewReader(value), int64(len(value))
+	case []byte:
+		reader, size = bytes.NewReader(value), int64(len(value))
+	case io.Reader:
+		reader = value
+	default:
+		return errors.New(errorValueType)
 	}
-
-	return nil
+	_, err := m.Put(context.Background(), bucket, key, reader, &PutOptions{Size: size})
+	return err
 }
 
+//Read returns the value of a key as a string, verifying its checksum
 func (m *Minio) Read(bucket string, key string) (interface{}, error) {
-	client := m.client
-
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	reader, err := client.GetObject(bucket, key, minio.GetObjectOptions{})
-	if err != nil {
-		return "", err
-	}
-	defer reader.Close()
-
-	if value, err := ioutil.ReadAll(reader); err == nil {
-		return string(value), nil
-	} else {
+	var value strings.Builder
+	if _, err := m.Get(context.Background(), bucket, key, &value); err != nil {
 		return nil, err
 	}
+	return value.String(), nil
 }
 
 func (m *Minio) Update(bucket string, key string, data interface{}) error {
@@ -189,33 +298,63 @@ func (m *Minio) Update(bucket string, key string, data interface{}) error {
 }
 
 func (m *Minio) Delete(bucket string, key string) error {
-	client := m.client
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	return client.RemoveObject(bucket, key)
+	return m.client.RemoveObject(m.name(bucket), key)
 }
 
+//Scans reports that List passes the values to a callback
+func (m *Minio) Scans() bool {
+	return true
+}
+
+//List calls callback, a func([]byte) or a func(string, []byte) receiving the
+//key too, with the values of the keys starting with prefix: List(bucket, prefix, callback)
 func (m *Minio) List(bucket string, params ...interface{}) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errors.New(errorListParams)
+	}
+	prefix, ok := params[0].(string)
+	if !ok {
+		return nil, errors.New(errorListParams)
+	}
+	var callback func(string, []byte)
+	switch c := params[1].(type) {
+	case func([]byte):
+		callback = func(_ string, v []byte) { c(v) }
+	case func(string, []byte):
+		callback = c
+	default:
+		return nil, errors.New(errorListParams)
+	}
 	client := m.client
+	bucket = m.name(bucket)
 	doneCh := make(chan struct{})
 	defer close(doneCh)
-	prefix := params[0].(string)
-	callback := params[1].(func([]byte))
-	for value := range client.ListObjectsV2(bucket, prefix, true, doneCh) {
-		reader, err := client.GetObject(bucket, value.Key, minio.GetObjectOptions{})
+	for object := range client.ListObjectsV2(bucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		reader, err := client.GetObject(bucket, object.Key, minio.GetObjectOptions{})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-
 		value, err := ioutil.ReadAll(reader)
-		if err == nil {
-			callback(value)
-		}
 		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		callback(object.Key, value)
 	}
 	return nil, nil
 }
 
+//Query evaluates a *query.Query on the JSON objects of a bucket, scanning it
 func (m *Minio) Query(bucket string, params ...interface{}) (interface{}, error) {
-	return nil, nil
+	if len(params) == 0 {
+		return nil, errors.New(errorQueryParams)
+	}
+	q, ok := params[0].(*query.Query)
+	if !ok {
+		return nil, errors.New(errorQueryParams)
+	}
+	return query.Scan(m, bucket, q)
 }
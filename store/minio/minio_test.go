@@ -0,0 +1,429 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+)
+
+//config keeps in memory the lifecycle and tagging configurations the fake server does not support
+type config struct {
+	lock    sync.Mutex
+	next    http.Handler
+	configs map[string][]byte
+}
+
+func (c *config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	_, lifecycle := query["lifecycle"]
+	_, tagging := query["tagging"]
+	if !lifecycle && !tagging {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := r.URL.Path + "?" + r.URL.RawQuery
+	switch r.Method {
+	case http.MethodPut:
+		c.configs[key], _ = ioutil.ReadAll(r.Body)
+	case http.MethodDelete:
+		delete(c.configs, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		if data, ok := c.configs[key]; ok {
+			w.Write(data)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<Error><Code>NoSuchLifecycleConfiguration</Code></Error>"))
+	}
+}
+
+func newServer(t *testing.T, secure bool) *httptest.Server {
+	handler := &config{next: gofakes3.New(s3mem.New()).Server(), configs: map[string][]byte{}}
+	server := httptest.NewUnstartedServer(handler)
+	if secure {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func endpoint(server *httptest.Server) string {
+	return server.Listener.Addr().String()
+}
+
+func newMinio(t *testing.T, options ...store.Option) *Minio {
+	server := newServer(t, false)
+	options = append([]store.Option{WithEndpoint(endpoint(server)), WithCredentials("access", "secret")}, options...)
+	m, err := New(options...)
+	assert.NoError(t, err)
+	assert.NoError(t, m.CreateBucketIfNotExists("test"))
+	return m
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithBucket(""))
+	assert.Error(t, err)
+	_, err = New(WithCA(""))
+	assert.Error(t, err)
+	_, err = New(WithCA(filepath.Join(t.TempDir(), "missing.pem")))
+	assert.Error(t, err)
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte("invalid"), 0600))
+	_, err = New(WithCA(invalid))
+	assert.Error(t, err)
+	_, err = New(WithLifecycle(Rule{ID: "empty"}))
+	assert.Error(t, err)
+}
+
+func TestMinio_TLS(t *testing.T) {
+	server := newServer(t, true)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(ca, encoded, 0600))
+
+	m, err := New(WithEndpoint(endpoint(server)), WithCredentials("access", "secret"), WithCA(ca), WithLocation("eu-west-1"))
+	assert.NoError(t, err)
+	assert.NoError(t, m.CreateBucketIfNotExists("secure"))
+	assert.NoError(t, m.Create("secure", "alice", "admin"))
+	value, err := m.Read("secure", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+
+	//presigned URLs carry the scheme of the connection
+	m, err = New(WithEndpoint(endpoint(server)), WithCredentials("access", "secret"), WithTLS(nil))
+	assert.NoError(t, err)
+	u, err := m.PresignGet("secure", "alice", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "https://"))
+}
+
+func TestMinio_DefaultBucket(t *testing.T) {
+	m := newMinio(t, WithBucket("docs"))
+	assert.NoError(t, m.CreateBucketIfNotExists(""))
+	assert.NoError(t, m.CreateBucketIfNotExists("docs"))
+	assert.NoError(t, m.Create("", "readme", "hello"))
+	value, err := m.Read("docs", "readme")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", value)
+	buckets, err := m.Buckets()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"docs", "test"}, buckets)
+}
+
+func TestMinio_Versioning(t *testing.T) {
+	server := newServer(t, false)
+	m, err := New(WithEndpoint(endpoint(server)), WithCredentials("access", "secret"), WithVersioning())
+	assert.NoError(t, err)
+	assert.NoError(t, m.CreateBucketIfNotExists("versioned"))
+	versioning := func() string {
+		response, err := http.Get(server.URL + "/versioned?versioning")
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		return string(body)
+	}
+	assert.Contains(t, versioning(), "<Status>Enabled</Status>")
+	assert.NoError(t, m.SetVersioning(context.Background(), "versioned", false))
+	assert.Contains(t, versioning(), "<Status>Suspended</Status>")
+}
+
+func TestMinio_Lifecycle(t *testing.T) {
+	rules := []Rule{{ID: "logs", Prefix: "logs/", Days: 30}, {ID: "old", NoncurrentDays: 7}}
+	m := newMinio(t, WithLifecycle(rules...))
+	assert.NoError(t, m.CreateBucketIfNotExists("expiring"))
+	current, err := m.Lifecycle("expiring")
+	assert.NoError(t, err)
+	assert.Equal(t, rules, current)
+
+	current, err = m.Lifecycle("test")
+	assert.NoError(t, err)
+	assert.Equal(t, rules, current)
+	assert.NoError(t, m.SetLifecycle(context.Background(), "test", Rule{ID: "tmp", Prefix: "tmp/", Days: 1}))
+	current, _ = m.Lifecycle("test")
+	assert.Equal(t, []Rule{{ID: "tmp", Prefix: "tmp/", Days: 1}}, current)
+	assert.NoError(t, m.SetLifecycle(context.Background(), "test"))
+	current, _ = m.Lifecycle("test")
+	assert.Empty(t, current)
+
+	assert.Error(t, m.SetLifecycle(context.Background(), "test", Rule{Days: 1}))
+	assert.Error(t, m.SetLifecycle(context.Background(), "test", Rule{ID: "a", Days: 1}, Rule{ID: "a", Days: 2}))
+	assert.Error(t, m.SetLifecycle(context.Background(), "test", Rule{ID: "a", Days: -1, NoncurrentDays: 2}))
+}
+
+func TestMinio_Tags(t *testing.T) {
+	m := newMinio(t)
+	ctx := context.Background()
+	assert.NoError(t, m.Create("test", "report", "data"))
+	tags := map[string]string{"team": "billing", "class": "internal"}
+	assert.NoError(t, m.SetTags(ctx, "test", "report", tags))
+	current, err := m.Tags(ctx, "test", "report")
+	assert.NoError(t, err)
+	assert.Equal(t, tags, current)
+	assert.Error(t, m.SetTags(ctx, "test", "report", map[string]string{"": "empty"}))
+}
+
+func TestMinio_Concurrent(t *testing.T) {
+	m := newMinio(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			assert.NoError(t, m.Create("test", key, key))
+			value, err := m.Read("test", key)
+			assert.NoError(t, err)
+			assert.Equal(t, key, value)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMinio_CRUD(t *testing.T) {
+	m := newMinio(t)
+	assert.NoError(t, m.Create("test", "alice", "admin"))
+	value, err := m.Read("test", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	info, err := m.Stat(context.Background(), "test", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+	assert.NotEmpty(t, info.Checksum)
+
+	assert.NoError(t, m.Update("test", "alice", []byte("user")))
+	value, _ = m.Read("test", "alice")
+	assert.Equal(t, "user", value)
+	assert.NoError(t, m.Update("test", "alice", strings.NewReader("guest")))
+	value, _ = m.Read("test", "alice")
+	assert.Equal(t, "guest", value)
+	assert.Error(t, m.Create("test", "alice", 42))
+
+	assert.NoError(t, m.Delete("test", "alice"))
+	_, err = m.Read("test", "alice")
+	assert.True(t, store.NotFound(err))
+}
+
+func TestMinio_List(t *testing.T) {
+	m := newMinio(t)
+	assert.NoError(t, m.Create("test", "users/alice", "admin"))
+	assert.NoError(t, m.Create("test", "users/bob", "user"))
+	assert.NoError(t, m.Create("test", "groups/staff", "staff"))
+
+	values := make(map[string]string)
+	_, err := m.List("test", "users/", func(key string, value []byte) {
+		values[key] = string(value)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"users/alice": "admin", "users/bob": "user"}, values)
+	count := 0
+	_, err = m.List("test", "", func([]byte) { count++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, err = m.List("test")
+	assert.Error(t, err)
+	_, err = m.List("test", "", func(string) {})
+	assert.Error(t, err)
+	//a missing bucket is not an empty one
+	_, err = m.List("missing", "", func([]byte) {})
+	assert.Error(t, err)
+}
+
+func TestMinio_Versioned(t *testing.T) {
+	m := newMinio(t)
+	var _ interfaces.Versioned = m
+	revision, err := m.Insert("test", "alice", "admin")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, revision)
+	_, err = m.Insert("test", "alice", "user")
+	assert.True(t, errors.Is(err, store.ErrExists))
+
+	value, read, err := m.ReadRevision("test", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", value)
+	assert.Equal(t, revision, read)
+
+	updated, err := m.UpdateIf("test", "alice", "user", revision)
+	assert.NoError(t, err)
+	assert.NotEqual(t, revision, updated)
+	_, err = m.UpdateIf("test", "alice", "guest", revision)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	value, _ = m.Read("test", "alice")
+	assert.Equal(t, "user", value)
+
+	assert.True(t, errors.Is(m.DeleteIf("test", "alice", revision), store.ErrConflict))
+	assert.NoError(t, m.DeleteIf("test", "alice", updated))
+	_, err = m.UpdateIf("test", "alice", "user", updated)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	_, err = store.History(m)
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+}
+
+func TestMinio_PutMultipart(t *testing.T) {
+	m := newMinio(t)
+	data := bytes.Repeat([]byte("0123456789"), 1200*1024)
+	var uploaded int64
+	//an io.Reader that cannot seek, of unknown size
+	reader := ioutil.NopCloser(bytes.NewReader(data))
+	n, err := m.Put(context.Background(), "test", "large.bin", reader, &PutOptions{
+		Size:     -1,
+		PartSize: minPartSize,
+		Metadata: map[string]string{"Owner": "alice"},
+		Progress: func(done, total int64) {
+			atomic.StoreInt64(&uploaded, done)
+			assert.Equal(t, int64(-1), total)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int64(len(data)), atomic.LoadInt64(&uploaded))
+
+	info, err := m.Stat(context.Background(), "test", "large.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, "application/octet-stream", info.ContentType)
+	assert.Equal(t, "alice", info.Metadata["Owner"])
+	assert.Empty(t, info.Checksum)
+
+	var out bytes.Buffer
+	n, err = m.Get(context.Background(), "test", "large.bin", &out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.True(t, bytes.Equal(data, out.Bytes()))
+
+	_, err = m.Put(context.Background(), "test", "small", reader, &PutOptions{Size: -1, PartSize: 1024})
+	assert.Error(t, err)
+	_, err = m.Put(context.Background(), "test", "nil", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestMinio_PutUnknownSize(t *testing.T) {
+	var parts int32
+	server := newServer(t, false)
+	next := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") != "" {
+			atomic.AddInt32(&parts, 1)
+		}
+		next.ServeHTTP(w, r)
+	})
+	m, err := New(WithEndpoint(endpoint(server)), WithCredentials("access", "secret"))
+	assert.NoError(t, err)
+	assert.NoError(t, m.CreateBucketIfNotExists("test"))
+
+	data := bytes.Repeat([]byte("0123456789"), 2*1024*1024)
+	//an io.Reader that cannot seek, of unknown size, uploaded in parts of 16MB
+	reader := ioutil.NopCloser(bytes.NewReader(data))
+	n, err := m.Put(context.Background(), "test", "stream.bin", reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&parts))
+
+	var out bytes.Buffer
+	_, err = m.Get(context.Background(), "test", "stream.bin", &out)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, out.Bytes()))
+}
+
+func TestMinio_ContentType(t *testing.T) {
+	m := newMinio(t)
+	ctx := context.Background()
+	_, err := m.Put(ctx, "test", "page.html", strings.NewReader("<p>hello</p>"), &PutOptions{Size: -1})
+	assert.NoError(t, err)
+	_, err = m.Put(ctx, "test", "image", bytes.NewReader([]byte("\x89PNG\r\n\x1a\n0000")), nil)
+	assert.NoError(t, err)
+	_, err = m.Put(ctx, "test", "data", strings.NewReader("{}"), &PutOptions{Size: 2, ContentType: "application/json"})
+	assert.NoError(t, err)
+
+	for key, expected := range map[string]string{
+		"page.html": "text/html; charset=utf-8",
+		"image":     "image/png",
+		"data":      "application/json",
+	} {
+		info, err := m.Stat(ctx, "test", key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, info.ContentType, key)
+	}
+}
+
+func TestMinio_GetRange(t *testing.T) {
+	m := newMinio(t)
+	assert.NoError(t, m.Create("test", "digits", "0123456789"))
+	var out bytes.Buffer
+	n, err := m.GetRange(context.Background(), "test", "digits", &out, 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.Equal(t, "3456", out.String())
+
+	_, err = m.GetRange(context.Background(), "test", "digits", &out, -1, 4)
+	assert.Error(t, err)
+	_, err = m.GetRange(context.Background(), "test", "digits", &out, 0, 0)
+	assert.Error(t, err)
+	_, err = m.GetRange(context.Background(), "test", "digits", nil, 0, 1)
+	assert.Error(t, err)
+}
+
+func TestMinio_Checksum(t *testing.T) {
+	m := newMinio(t)
+	ctx := context.Background()
+	wrong := strings.Repeat("0", 64)
+	_, err := m.Put(ctx, "test", "corrupted", strings.NewReader("data"), &PutOptions{Size: 4, Checksum: wrong})
+	assert.NoError(t, err)
+	_, err = m.Get(ctx, "test", "corrupted", ioutil.Discard)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	reader, err := m.Open(ctx, "test", "corrupted")
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	reader.Close()
+
+	_, err = m.Put(ctx, "test", "invalid", strings.NewReader("data"), &PutOptions{Size: 4, Checksum: "abc"})
+	assert.Error(t, err)
+}
+
+func TestMinio_Presign(t *testing.T) {
+	m := newMinio(t)
+	put, err := m.PresignPut("test", "presigned", time.Minute)
+	assert.NoError(t, err)
+	request, _ := http.NewRequest(http.MethodPut, put, strings.NewReader("uploaded"))
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	get, err := m.PresignGet("test", "presigned", time.Minute)
+	assert.NoError(t, err)
+	response, err = http.Get(get)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	assert.Equal(t, "uploaded", string(body))
+
+	_, err = m.PresignGet("test", "presigned", 0)
+	assert.Error(t, err)
+	_, err = m.PresignPut("test", "presigned", 8*24*time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,297 @@
+package minio
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/advancedlogic/box/store"
+	minio "github.com/minio/minio-go/v6"
+)
+
+const (
+	//ChecksumMetadata is the user metadata holding the hex SHA-256 of an object
+	ChecksumMetadata = "Sha256"
+
+	minPartSize = 5 * 1024 * 1024
+	//unknownPartSize bounds the buffer of the uploads of unknown size,
+	//minio-go would buffer parts of 576MB to allow objects up to 5TB
+	unknownPartSize = 16 * 1024 * 1024
+
+	errorReaderNil    = "reader cannot be nil"
+	errorWriterNil    = "writer cannot be nil"
+	errorPartSize     = "part size must be at least 5MB"
+	errorRange        = "range offset cannot be negative and length must be greater than zero"
+	errorExpiry       = "expiry must be between 1s and 7 days"
+	errorChecksumType = "checksum must be a hex SHA-256"
+)
+
+//ErrChecksumMismatch is returned when the data read differs from the data written
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+//PutOptions customizes an upload
+type PutOptions struct {
+	//Size of the data, -1 if unknown. Unknown sizes are uploaded in parts of PartSize.
+	Size int64
+	//ContentType of the data. Default detected from the extension of the key or the data.
+	ContentType string
+	//Metadata is stored along with the data
+	Metadata map[string]string
+	//PartSize of the multipart uploads, at least 5MB. Default chosen by the size,
+	//16MB if unknown, limiting such uploads to 160GB.
+	PartSize uint64
+	//Checksum is the hex SHA-256 of the data, verified by Get. Default computed
+	//if the reader is an io.Seeker, readers that cannot seek are uploaded without.
+	Checksum string
+	//Progress is called while uploading with the bytes uploaded and the total size, -1 if unknown
+	Progress func(uploaded, total int64)
+}
+
+//Info describes an object
+type Info struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	Checksum    string
+	Metadata    map[string]string
+	Modified    time.Time
+}
+
+//progress reports the uploaded bytes, minio reads from it the bytes it uploads
+type progress struct {
+	uploaded int64
+	total    int64
+	callback func(int64, int64)
+}
+
+func (p *progress) Read(b []byte) (int, error) {
+	p.callback(atomic.AddInt64(&p.uploaded, int64(len(b))), p.total)
+	return len(b), nil
+}
+
+//contentType detects the content type from the extension of the key,
+//or from the first bytes of the data
+func contentType(key string, reader *bufio.Reader) string {
+	if kind := mime.TypeByExtension(path.Ext(key)); kind != "" {
+		return kind
+	}
+	head, _ := reader.Peek(512)
+	return http.DetectContentType(head)
+}
+
+//checksum computes the hex SHA-256 of a seekable reader and rewinds it
+func checksum(seeker io.ReadSeeker) (string, error) {
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, seeker); err != nil {
+		return "", err
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//Put uploads the data read from reader, in parts if large or of unknown size,
+//and returns the bytes uploaded
+func (m *Minio) Put(ctx context.Context, bucket string, key string, reader io.Reader, options *PutOptions) (int64, error) {
+	if reader == nil {
+		return 0, errors.New(errorReaderNil)
+	}
+	if options == nil {
+		options = &PutOptions{Size: -1}
+	}
+	if options.PartSize != 0 && options.PartSize < minPartSize {
+		return 0, errors.New(errorPartSize)
+	}
+	sum := strings.ToLower(options.Checksum)
+	if sum != "" {
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return 0, errors.New(errorChecksumType)
+		}
+	} else if seeker, ok := reader.(io.ReadSeeker); ok {
+		var err error
+		if sum, err = checksum(seeker); err != nil {
+			return 0, err
+		}
+	}
+
+	metadata := make(map[string]string, len(options.Metadata)+1)
+	for k, v := range options.Metadata {
+		metadata[k] = v
+	}
+	if sum != "" {
+		metadata[ChecksumMetadata] = sum
+	}
+	buffered := bufio.NewReader(reader)
+	opts := minio.PutObjectOptions{
+		ContentType:  options.ContentType,
+		UserMetadata: metadata,
+		PartSize:     options.PartSize,
+	}
+	if options.Size < 0 && opts.PartSize == 0 {
+		opts.PartSize = unknownPartSize
+	}
+	if opts.ContentType == "" {
+		opts.ContentType = contentType(key, buffered)
+	}
+	if options.Progress != nil {
+		opts.Progress = &progress{total: options.Size, callback: options.Progress}
+	}
+	return m.client.PutObjectWithContext(ctx, m.name(bucket), key, buffered, options.Size, opts)
+}
+
+//Stat returns the description of an object
+func (m *Minio) Stat(ctx context.Context, bucket string, key string) (*Info, error) {
+	info, err := m.client.StatObjectWithContext(ctx, m.name(bucket), key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(info.UserMetadata))
+	checksum := ""
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, ChecksumMetadata) {
+			checksum = v
+			continue
+		}
+		metadata[k] = v
+	}
+	return &Info{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ETag:        info.ETag,
+		Checksum:    checksum,
+		Metadata:    metadata,
+		Modified:    info.LastModified,
+	}, nil
+}
+
+//verifier verifies the checksum of the data read when reaching the end
+type verifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (v *verifier) Read(b []byte) (int, error) {
+	n, err := v.ReadCloser.Read(b)
+	v.hash.Write(b[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.expected {
+		return n, fmt.Errorf("%w: expected %s", ErrChecksumMismatch, v.expected)
+	}
+	return n, err
+}
+
+//Open returns a reader of an object. If the object has a checksum, reaching the
+//end of the data returns ErrChecksumMismatch instead of io.EOF when it differs.
+func (m *Minio) Open(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	reader, _, err := m.open(ctx, bucket, key)
+	return reader, err
+}
+
+//open returns a reader of an object and its description
+func (m *Minio) open(ctx context.Context, bucket string, key string) (io.ReadCloser, *Info, error) {
+	info, err := m.Stat(ctx, bucket, key)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, fmt.Errorf("%s/%s: %w", bucket, key, store.ErrNotFound)
+		}
+		return nil, nil, err
+	}
+	opts := minio.GetObjectOptions{}
+	//reading the version described by Stat
+	if err := opts.SetMatchETag(info.ETag); err != nil {
+		return nil, nil, err
+	}
+	object, err := m.client.GetObjectWithContext(ctx, m.name(bucket), key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Checksum == "" {
+		return object, info, nil
+	}
+	return &verifier{ReadCloser: object, hash: sha256.New(), expected: info.Checksum}, info, nil
+}
+
+//Get writes the data of an object to writer and returns the bytes written.
+//The checksum is verified at the end: on ErrChecksumMismatch discard what was written.
+func (m *Minio) Get(ctx context.Context, bucket string, key string, writer io.Writer) (int64, error) {
+	if writer == nil {
+		return 0, errors.New(errorWriterNil)
+	}
+	reader, err := m.Open(ctx, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.Copy(writer, reader)
+}
+
+//GetRange writes length bytes of an object starting at offset to writer
+//and returns the bytes written. Ranges are not verified against the checksum.
+func (m *Minio) GetRange(ctx context.Context, bucket string, key string, writer io.Writer, offset, length int64) (int64, error) {
+	if writer == nil {
+		return 0, errors.New(errorWriterNil)
+	}
+	if offset < 0 || length <= 0 {
+		return 0, errors.New(errorRange)
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return 0, err
+	}
+	object, err := m.client.GetObjectWithContext(ctx, m.name(bucket), key, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer object.Close()
+	return io.Copy(writer, object)
+}
+
+func validExpiry(expiry time.Duration) error {
+	if expiry < time.Second || expiry > 7*24*time.Hour {
+		return errors.New(errorExpiry)
+	}
+	return nil
+}
+
+//PresignGet returns a URL to download an object without credentials until expiry
+func (m *Minio) PresignGet(bucket string, key string, expiry time.Duration) (string, error) {
+	if err := validExpiry(expiry); err != nil {
+		return "", err
+	}
+	u, err := m.client.PresignedGetObject(m.name(bucket), key, expiry, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+//PresignPut returns a URL to upload an object with a PUT without credentials until expiry
+func (m *Minio) PresignPut(bucket string, key string, expiry time.Duration) (string, error) {
+	if err := validExpiry(expiry); err != nil {
+		return "", err
+	}
+	u, err := m.client.PresignedPutObject(m.name(bucket), key, expiry)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,92 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/advancedlogic/box/store"
+	minio "github.com/minio/minio-go/v6"
+)
+
+//revision returns the ETag of an object, "" if missing
+func (m *Minio) revision(bucket string, key string) (string, error) {
+	info, err := m.client.StatObject(m.name(bucket), key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return "", nil
+		}
+		return "", err
+	}
+	return info.ETag, nil
+}
+
+//conditional writes an object with write if check, called with its ETag or ""
+//if missing, does not fail, and returns its new ETag
+func (m *Minio) conditional(bucket string, key string, check func(string) error, write func() error) (string, error) {
+	m.writing.Lock()
+	defer m.writing.Unlock()
+	current, err := m.revision(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if err := check(current); err != nil {
+		return "", err
+	}
+	if err := write(); err != nil {
+		return "", err
+	}
+	return m.revision(bucket, key)
+}
+
+func match(bucket, key, revision string) func(string) error {
+	return func(current string) error {
+		if current == "" || current != revision {
+			return fmt.Errorf("%s/%s: %w", bucket, key, store.ErrConflict)
+		}
+		return nil
+	}
+}
+
+//Insert uploads the value of a key failing with store.ErrExists if it exists, and returns its ETag
+func (m *Minio) Insert(bucket string, key string, data interface{}) (string, error) {
+	return m.conditional(bucket, key, func(current string) error {
+		if current != "" {
+			return fmt.Errorf("%s/%s: %w", bucket, key, store.ErrExists)
+		}
+		return nil
+	}, func() error {
+		return m.Create(bucket, key, data)
+	})
+}
+
+//ReadRevision returns the value of a key as a string and its ETag, verifying its checksum
+func (m *Minio) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	reader, info, err := m.open(context.Background(), bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+	var value strings.Builder
+	if _, err := io.Copy(&value, reader); err != nil {
+		return nil, "", err
+	}
+	return value.String(), info.ETag, nil
+}
+
+//UpdateIf uploads the value of a key if its ETag matches, failing with
+//store.ErrConflict otherwise, and returns its new ETag
+func (m *Minio) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	return m.conditional(bucket, key, match(bucket, key, revision), func() error {
+		return m.Create(bucket, key, data)
+	})
+}
+
+//DeleteIf removes a key if its ETag matches, failing with store.ErrConflict otherwise
+func (m *Minio) DeleteIf(bucket string, key string, revision string) error {
+	_, err := m.conditional(bucket, key, match(bucket, key, revision), func() error {
+		return m.Delete(bucket, key)
+	})
+	return err
+}
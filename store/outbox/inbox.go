@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const errorIDEmpty = "message id cannot be empty"
+
+//Inbox deduplicates the messages delivered more than once by an outbox relay,
+//or by any at-least-once broker, remembering the ids of the handled messages
+type Inbox struct {
+	store  interfaces.Store
+	bucket string
+}
+
+//NewInbox returns an inbox remembering the handled messages in the bucket of the store
+func NewInbox(s interfaces.Store, bucket string) (*Inbox, error) {
+	if s == nil {
+		return nil, errors.New(errorStoreNil)
+	}
+	if bucket == "" {
+		return nil, errors.New(errorBucketEmpty)
+	}
+	return &Inbox{store: s, bucket: bucket}, nil
+}
+
+//Seen returns true if the message has already been handled
+func (i *Inbox) Seen(id string) bool {
+	_, err := i.store.Read(i.bucket, id)
+	return err == nil
+}
+
+//Mark remembers that the message has been handled
+func (i *Inbox) Mark(id string) error {
+	if id == "" {
+		return errors.New(errorIDEmpty)
+	}
+	return i.store.Create(i.bucket, id, time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+//Forget removes a message, to be called when it cannot be delivered again
+func (i *Inbox) Forget(id string) error {
+	return i.store.Delete(i.bucket, id)
+}
+
+//Middleware skips the messages whose broker.IDHeader has already been handled
+//and marks the ones handled successfully. Messages without id are always handled.
+func (i *Inbox) Middleware() broker.Middleware {
+	return func(next broker.Handler) broker.Handler {
+		return func(ctx context.Context, header map[string]string, data []byte) error {
+			id := header[broker.IDHeader]
+			if id == "" {
+				return next(ctx, header, data)
+			}
+			if i.Seen(id) {
+				return nil
+			}
+			if err := next(ctx, header, data); err != nil {
+				return err
+			}
+			return i.Mark(id)
+		}
+	}
+}
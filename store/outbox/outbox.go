@@ -0,0 +1,514 @@
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+)
+
+const (
+	statePrepared = "prepared"
+	stateReady    = "ready"
+
+	operationCreate = "create"
+	operationUpdate = "update"
+	operationDelete = "delete"
+
+	errorStoreNil        = "store cannot be nil"
+	errorBrokerNil       = "broker cannot be nil"
+	errorBucketEmpty     = "bucket cannot be empty"
+	errorIntervalZero    = "interval must be greater than zero"
+	errorGraceZero       = "grace must be greater than zero"
+	errorLoggerNil       = "logger cannot be nil"
+	errorAggregateEmpty  = "event aggregate cannot be empty"
+	errorStoreList       = "store cannot list the values: %w"
+	errorTopicEmpty      = "event topic cannot be empty"
+	errorAlreadyStarted  = "relay already started"
+	errorNotStarted      = "relay not started"
+	errorUnexpectedEntry = "unexpected outbox entry: %s"
+)
+
+//WithStore sets the store holding both the data and the outbox
+func WithStore(s interfaces.Store) store.Option {
+	return func(i interfaces.Store) error {
+		if s != nil {
+			o := i.(*Outbox)
+			o.store = s
+			return nil
+		}
+		return errors.New(errorStoreNil)
+	}
+}
+
+//WithBroker sets the broker the relay publishes the events to
+func WithBroker(b interfaces.Broker) store.Option {
+	return func(i interfaces.Store) error {
+		if b != nil {
+			o := i.(*Outbox)
+			o.broker = b
+			return nil
+		}
+		return errors.New(errorBrokerNil)
+	}
+}
+
+//WithBucket sets the bucket of the outbox. Default outbox.
+func WithBucket(bucket string) store.Option {
+	return func(i interfaces.Store) error {
+		if bucket != "" {
+			o := i.(*Outbox)
+			o.bucket = bucket
+			return nil
+		}
+		return errors.New(errorBucketEmpty)
+	}
+}
+
+//WithInterval sets how often the relay looks for new events. Default 1s.
+func WithInterval(interval time.Duration) store.Option {
+	return func(i interfaces.Store) error {
+		if interval > 0 {
+			o := i.(*Outbox)
+			o.interval = interval
+			return nil
+		}
+		return errors.New(errorIntervalZero)
+	}
+}
+
+//WithGrace sets after how long the events of a write that never completed,
+//because the process crashed in the middle, are resolved. Default 1m.
+func WithGrace(grace time.Duration) store.Option {
+	return func(i interfaces.Store) error {
+		if grace > 0 {
+			o := i.(*Outbox)
+			o.grace = grace
+			return nil
+		}
+		return errors.New(errorGraceZero)
+	}
+}
+
+func WithLogger(logger interfaces.Logger) store.Option {
+	return func(i interfaces.Store) error {
+		if logger != nil {
+			o := i.(*Outbox)
+			o.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//Event is published when the write recording it succeeds.
+//Events with the same aggregate are published in the order they are recorded.
+type Event struct {
+	//ID identifies the event for the deduplication on the consuming side. Default a sortable unique id.
+	ID        string
+	Aggregate string
+	Topic     string
+	Header    map[string]string
+	Data      []byte
+}
+
+type entry struct {
+	ID        string            `json:"id"`
+	Aggregate string            `json:"aggregate"`
+	Topic     string            `json:"topic"`
+	Header    map[string]string `json:"header,omitempty"`
+	Data      []byte            `json:"data"`
+	State     string            `json:"state"`
+	Operation string            `json:"operation"`
+	Bucket    string            `json:"bucket"`
+	Key       string            `json:"key"`
+	Sequence  string            `json:"sequence"`
+	Recorded  time.Time         `json:"recorded"`
+	//Revision of the key before the write, with the stores keeping revisions
+	Revision string `json:"revision,omitempty"`
+	//Digest of the value written, with the other stores
+	Digest string `json:"digest,omitempty"`
+}
+
+func (e *entry) key() string {
+	return url.PathEscape(e.Aggregate) + "/" + e.Sequence
+}
+
+//transactional is implemented by stores running writes atomically, like kv
+type transactional interface {
+	Transaction(func(interfaces.Store) error) error
+}
+
+//Outbox wraps a Store recording the events in an outbox bucket of the same store
+//together with the writes, and relays them to a Broker with at-least-once delivery.
+//
+//With the stores supporting transactions, like kv, the events and the write are
+//committed atomically. With the other stores the events are recorded as prepared,
+//the data is written and then the events are marked as ready. If the process
+//crashes in the middle, the relay resolves the prepared events after the grace
+//period checking the data: the events of a delete are published if the key is
+//missing, the ones of a create or an update if the revision of the key changed
+//since it was recorded, or with the stores without revisions if the key holds
+//the value written. A write of another process in the meantime can still let
+//the events of a write that did not happen be published.
+//
+//Run a single relay for every outbox bucket to keep the per-aggregate ordering.
+type Outbox struct {
+	interfaces.Logger
+
+	store    interfaces.Store
+	broker   interfaces.Broker
+	bucket   string
+	interval time.Duration
+	grace    time.Duration
+	counter  uint64
+
+	lock sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(options ...store.Option) (*Outbox, error) {
+	o := &Outbox{
+		bucket:   "outbox",
+		interval: time.Second,
+		grace:    time.Minute,
+	}
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.store == nil {
+		return nil, errors.New(errorStoreNil)
+	}
+	if o.broker == nil {
+		return nil, errors.New(errorBrokerNil)
+	}
+	//the relay lists the entries
+	if err := store.Scanning(o.store); err != nil {
+		return nil, fmt.Errorf(errorStoreList, err)
+	}
+	return o, nil
+}
+
+func (o *Outbox) Create(bucket string, key string, value interface{}) error {
+	return o.store.Create(bucket, key, value)
+}
+
+func (o *Outbox) Read(bucket string, key string) (interface{}, error) {
+	return o.store.Read(bucket, key)
+}
+
+func (o *Outbox) Update(bucket string, key string, value interface{}) error {
+	return o.store.Update(bucket, key, value)
+}
+
+func (o *Outbox) Delete(bucket string, key string) error {
+	return o.store.Delete(bucket, key)
+}
+
+//Scans reports whether the List of the store of the outbox passes the values to a callback
+func (o *Outbox) Scans() bool {
+	return store.Scanning(o.store) == nil
+}
+
+func (o *Outbox) List(bucket string, params ...interface{}) (interface{}, error) {
+	return o.store.List(bucket, params...)
+}
+
+func (o *Outbox) Query(bucket string, params ...interface{}) (interface{}, error) {
+	return o.store.Query(bucket, params...)
+}
+
+func (o *Outbox) Buckets() (interface{}, error) {
+	return o.store.Buckets()
+}
+
+//CreateWithEvents creates a key recording the events to be published
+func (o *Outbox) CreateWithEvents(bucket string, key string, value interface{}, events ...Event) error {
+	return o.write(operationCreate, bucket, key, value, events, func(s interfaces.Store) error {
+		return s.Create(bucket, key, value)
+	})
+}
+
+//UpdateWithEvents updates a key recording the events to be published
+func (o *Outbox) UpdateWithEvents(bucket string, key string, value interface{}, events ...Event) error {
+	return o.write(operationUpdate, bucket, key, value, events, func(s interfaces.Store) error {
+		return s.Update(bucket, key, value)
+	})
+}
+
+//DeleteWithEvents deletes a key recording the events to be published
+func (o *Outbox) DeleteWithEvents(bucket string, key string, events ...Event) error {
+	return o.write(operationDelete, bucket, key, nil, events, func(s interfaces.Store) error {
+		return s.Delete(bucket, key)
+	})
+}
+
+//sequence returns an id sorted by recording time
+func (o *Outbox) sequence() string {
+	return fmt.Sprintf("%020d-%010d", time.Now().UnixNano(), atomic.AddUint64(&o.counter, 1))
+}
+
+//digest returns the hex SHA-256 of a value as returned by the store, empty if
+//it cannot be compared
+func digest(value interface{}) string {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		//maps and structs are encoded with their keys sorted
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return ""
+		}
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (o *Outbox) write(operation, bucket, key string, value interface{}, events []Event, write func(interfaces.Store) error) error {
+	entries := make([]*entry, 0, len(events))
+	for _, event := range events {
+		if event.Aggregate == "" {
+			return errors.New(errorAggregateEmpty)
+		}
+		if event.Topic == "" {
+			return errors.New(errorTopicEmpty)
+		}
+		e := &entry{
+			ID:        event.ID,
+			Aggregate: event.Aggregate,
+			Topic:     event.Topic,
+			Header:    event.Header,
+			Data:      event.Data,
+			State:     statePrepared,
+			Operation: operation,
+			Bucket:    bucket,
+			Key:       key,
+			Recorded:  time.Now(),
+			Sequence:  o.sequence(),
+		}
+		if e.ID == "" {
+			e.ID = e.Sequence
+		}
+		entries = append(entries, e)
+	}
+
+	if t, ok := o.store.(transactional); ok {
+		return t.Transaction(func(s interfaces.Store) error {
+			for _, e := range entries {
+				e.State = stateReady
+				if err := o.save(e, s.Create); err != nil {
+					return err
+				}
+			}
+			return write(s)
+		})
+	}
+
+	if operation != operationDelete {
+		if versioned, ok := o.store.(interfaces.Versioned); ok {
+			//missing keys have no revision
+			_, revision, _ := versioned.ReadRevision(bucket, key)
+			for _, e := range entries {
+				e.Revision = revision
+			}
+		} else {
+			sum := digest(value)
+			for _, e := range entries {
+				e.Digest = sum
+			}
+		}
+	}
+	for i, e := range entries {
+		if err := o.save(e, o.store.Create); err != nil {
+			o.discard(entries[:i])
+			return err
+		}
+	}
+	if err := write(o.store); err != nil {
+		o.discard(entries)
+		return err
+	}
+	for _, e := range entries {
+		e.State = stateReady
+		//a failure leaves the event prepared, the relay will resolve it
+		if err := o.save(e, o.store.Update); err != nil && o.Logger != nil {
+			o.Logger.Warn(fmt.Sprintf("outbox %s: %s", e.ID, err.Error()))
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) save(e *entry, write func(string, string, interface{}) error) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return write(o.bucket, e.key(), string(data))
+}
+
+func (o *Outbox) discard(entries []*entry) {
+	for _, e := range entries {
+		if err := o.store.Delete(o.bucket, e.key()); err != nil && o.Logger != nil {
+			o.Logger.Warn(fmt.Sprintf("outbox %s: %s", e.ID, err.Error()))
+		}
+	}
+}
+
+//entries lists the entries of the outbox grouped by aggregate in recording order
+func (o *Outbox) entries() (map[string][]*entry, error) {
+	aggregates := make(map[string][]*entry)
+	var failure error
+	_, err := o.store.List(o.bucket, "", func(data []byte) {
+		e := &entry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			failure = fmt.Errorf(errorUnexpectedEntry, err)
+			return
+		}
+		aggregates[e.Aggregate] = append(aggregates[e.Aggregate], e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failure != nil && o.Logger != nil {
+		o.Logger.Warn(failure.Error())
+	}
+	for _, entries := range aggregates {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Sequence < entries[j].Sequence
+		})
+	}
+	return aggregates, nil
+}
+
+//resolve decides if a prepared entry has to be published (true) or discarded (false),
+//ok is false while the write might still be in progress
+func (o *Outbox) resolve(e *entry) (publish bool, ok bool) {
+	if time.Since(e.Recorded) < o.grace {
+		return false, false
+	}
+	if e.Operation == operationDelete {
+		_, err := o.store.Read(e.Bucket, e.Key)
+		return err != nil, true
+	}
+	if versioned, isVersioned := o.store.(interfaces.Versioned); isVersioned {
+		_, revision, err := versioned.ReadRevision(e.Bucket, e.Key)
+		return err == nil && revision != e.Revision, true
+	}
+	value, err := o.store.Read(e.Bucket, e.Key)
+	if err != nil {
+		return false, true
+	}
+	//entries recorded without digest keep the decision of the operation
+	return e.Digest == "" || digest(value) == e.Digest, true
+}
+
+func (o *Outbox) publish(ctx context.Context, e *entry) error {
+	if b, ok := o.broker.(interfaces.HeaderBroker); ok {
+		header := make(map[string]string, len(e.Header)+2)
+		for key, value := range e.Header {
+			header[key] = value
+		}
+		header[broker.IDHeader] = e.ID
+		//the events of an aggregate share the key, the brokers ordering by key keep them in order
+		if header[broker.KeyHeader] == "" {
+			header[broker.KeyHeader] = e.Aggregate
+		}
+		return b.PublishWithHeader(ctx, e.Topic, header, e.Data)
+	}
+	return o.broker.Publish(e.Topic, e.Data)
+}
+
+//Relay publishes the ready events and returns how many have been published.
+//The events of an aggregate are published in order: the first failure stops
+//its aggregate until the next run.
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	aggregates, err := o.entries()
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	var failure error
+	for _, entries := range aggregates {
+		for _, e := range entries {
+			if e.State == statePrepared {
+				publish, ok := o.resolve(e)
+				if !ok {
+					break
+				}
+				if !publish {
+					o.discard([]*entry{e})
+					continue
+				}
+			}
+			if err := o.publish(ctx, e); err != nil {
+				failure = err
+				break
+			}
+			published++
+			//a failure here publishes the event again on the next run
+			if err := o.store.Delete(o.bucket, e.key()); err != nil {
+				failure = err
+				break
+			}
+		}
+	}
+	return published, failure
+}
+
+//Start runs the relay in background every interval
+func (o *Outbox) Start() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.stop != nil {
+		return errors.New(errorAlreadyStarted)
+	}
+	o.stop = make(chan struct{})
+	o.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := o.Relay(context.Background()); err != nil && o.Logger != nil {
+					o.Logger.Warn(fmt.Sprintf("outbox relay: %s", err.Error()))
+				}
+			}
+		}
+	}(o.stop, o.done)
+	return nil
+}
+
+//Stop stops the relay waiting for the current run to complete
+func (o *Outbox) Stop() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.stop == nil {
+		return errors.New(errorNotStarted)
+	}
+	close(o.stop)
+	<-o.done
+	o.stop = nil
+	return nil
+}
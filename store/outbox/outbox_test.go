@@ -0,0 +1,202 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/broker"
+	"github.com/advancedlogic/box/broker/memory"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/fs"
+	"github.com/advancedlogic/box/store/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFs(t *testing.T) *fs.Fs {
+	f, err := fs.New(fs.WithRoot(t.TempDir()))
+	assert.Nil(t, err)
+	return f
+}
+
+//plain hides the revisions and the transactions of a store
+type plain struct {
+	interfaces.Store
+}
+
+func (p plain) Scans() bool {
+	return true
+}
+
+func count(t *testing.T, s interfaces.Store, bucket string) int {
+	count := 0
+	_, err := s.List(bucket, "", func([]byte) { count++ })
+	assert.Nil(t, err)
+	return count
+}
+
+func newOutbox(t *testing.T, s interfaces.Store) (*Outbox, *memory.Memory) {
+	b, _ := memory.New()
+	assert.Nil(t, b.Connect())
+	t.Cleanup(func() { b.Close() })
+	o, err := New(WithStore(s), WithBroker(b), WithInterval(10*time.Millisecond), WithGrace(50*time.Millisecond))
+	assert.Nil(t, err)
+	return o, b
+}
+
+//stores returns a store of every kind: transactional, with revisions and without
+func stores(t *testing.T) map[string]interfaces.Store {
+	return map[string]interfaces.Store{"kv": kvtest.New(t), "fs": newFs(t), "plain": plain{newFs(t)}}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithBroker(&memory.Memory{}))
+	assert.NotNil(t, err)
+	_, err = New(WithStore(newFs(t)))
+	assert.NotNil(t, err)
+	_, err = New(WithStore(newFs(t)), WithBroker(&memory.Memory{}), WithGrace(0))
+	assert.NotNil(t, err)
+	//the relay could not list the entries
+	_, err = New(WithStore(struct{ interfaces.Store }{newFs(t)}), WithBroker(&memory.Memory{}))
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			o, b := newOutbox(t, s)
+			received := make(chan string, 10)
+			assert.Nil(t, b.SubscribeWithHeader("orders", func(ctx context.Context, header map[string]string, data []byte) error {
+				assert.NotEmpty(t, header[broker.IDHeader])
+				assert.Equal(t, "order-1", header[broker.KeyHeader])
+				received <- string(data)
+				return nil
+			}))
+
+			assert.Nil(t, o.CreateWithEvents("orders", "1", "order 1",
+				Event{Aggregate: "order-1", Topic: "orders", Data: []byte("created")},
+				Event{Aggregate: "order-1", Topic: "orders", Data: []byte("priced")},
+			))
+			assert.Nil(t, o.UpdateWithEvents("orders", "1", "order 1 paid",
+				Event{Aggregate: "order-1", Topic: "orders", Data: []byte("paid")},
+			))
+			assert.NotNil(t, o.CreateWithEvents("orders", "2", "order 2", Event{Topic: "orders"}))
+			assert.Equal(t, 3, count(t, s, "outbox"))
+
+			published, err := o.Relay(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 3, published)
+			assert.Equal(t, 0, count(t, s, "outbox"))
+			for _, expected := range []string{"created", "priced", "paid"} {
+				select {
+				case r := <-received:
+					assert.Equal(t, expected, r)
+				case <-time.After(time.Second):
+					t.Fatal("event not published")
+				}
+			}
+		})
+	}
+}
+
+func TestOutbox_FailedWrite(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			o, _ := newOutbox(t, s)
+			//values of unsupported types fail to be written
+			err := o.CreateWithEvents("orders", "1", 42, Event{Aggregate: "order-1", Topic: "orders"})
+			assert.NotNil(t, err)
+			assert.Equal(t, 0, count(t, s, "outbox"))
+		})
+	}
+}
+
+//prepare records the events of a write as a crashed process leaves them
+func prepare(t *testing.T, o *Outbox, operation string, key string, value interface{}) {
+	e := &entry{ID: key, Aggregate: "order-" + key, Topic: "orders", State: statePrepared,
+		Operation: operation, Bucket: "orders", Key: key, Sequence: o.sequence(), Recorded: time.Now()}
+	if versioned, ok := o.store.(interfaces.Versioned); ok {
+		_, e.Revision, _ = versioned.ReadRevision("orders", key)
+	} else {
+		e.Digest = digest(value)
+	}
+	assert.Nil(t, o.save(e, o.store.Create))
+}
+
+func TestOutbox_Crash(t *testing.T) {
+	for name, s := range map[string]interfaces.Store{"fs": newFs(t), "plain": plain{newFs(t)}} {
+		t.Run(name, func(t *testing.T) {
+			o, _ := newOutbox(t, s)
+			assert.Nil(t, s.Create("orders", "3", "order 3"))
+			assert.Nil(t, s.Create("orders", "4", "order 4"))
+			//the writes of 1 and 3 completed, the ones of 2 and 4 did not
+			prepare(t, o, operationCreate, "1", "order 1")
+			prepare(t, o, operationCreate, "2", "order 2")
+			prepare(t, o, operationUpdate, "3", "order 3 paid")
+			prepare(t, o, operationUpdate, "4", "order 4 paid")
+			assert.Nil(t, s.Create("orders", "1", "order 1"))
+			assert.Nil(t, s.Update("orders", "3", "order 3 paid"))
+
+			published, err := o.Relay(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 0, published)
+			assert.Equal(t, 4, count(t, s, "outbox"))
+
+			time.Sleep(60 * time.Millisecond)
+			published, err = o.Relay(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 2, published)
+			assert.Equal(t, 0, count(t, s, "outbox"))
+		})
+	}
+}
+
+func TestOutbox_StartStop(t *testing.T) {
+	o, b := newOutbox(t, kvtest.New(t))
+	assert.NotNil(t, o.Stop())
+	received := make(chan []byte, 1)
+	assert.Nil(t, b.Subscribe("orders", func(data []byte) { received <- data }))
+	assert.Nil(t, o.Start())
+	assert.NotNil(t, o.Start())
+	defer o.Stop()
+
+	assert.Nil(t, o.CreateWithEvents("orders", "1", "order 1",
+		Event{Aggregate: "order-1", Topic: "orders", Data: []byte("created")}))
+	select {
+	case data := <-received:
+		assert.Equal(t, "created", string(data))
+	case <-time.After(time.Second):
+		t.Fatal("event not relayed")
+	}
+}
+
+func TestInbox(t *testing.T) {
+	s := newFs(t)
+	_, err := NewInbox(nil, "inbox")
+	assert.NotNil(t, err)
+	inbox, err := NewInbox(s, "inbox")
+	assert.Nil(t, err)
+
+	calls := 0
+	h := broker.Chain(func(context.Context, map[string]string, []byte) error {
+		calls++
+		if calls == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, inbox.Middleware())
+	header := map[string]string{broker.IDHeader: "event-1"}
+	assert.NotNil(t, h(context.Background(), header, nil))
+	assert.False(t, inbox.Seen("event-1"))
+	assert.Nil(t, h(context.Background(), header, nil))
+	assert.Nil(t, h(context.Background(), header, nil))
+	assert.Equal(t, 2, calls)
+	assert.True(t, inbox.Seen("event-1"))
+
+	assert.Nil(t, h(context.Background(), nil, nil))
+	assert.Equal(t, 3, calls)
+	assert.Nil(t, inbox.Forget("event-1"))
+	assert.False(t, inbox.Seen("event-1"))
+}
@@ -0,0 +1,464 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/advancedlogic/box/interfaces"
+)
+
+const (
+	OperatorEq       = "eq"
+	OperatorNe       = "ne"
+	OperatorGt       = "gt"
+	OperatorGte      = "gte"
+	OperatorLt       = "lt"
+	OperatorLte      = "lte"
+	OperatorIn       = "in"
+	OperatorContains = "contains"
+	OperatorPrefix   = "prefix"
+	OperatorExists   = "exists"
+
+	errorQueryNil        = "query cannot be nil"
+	errorFieldEmpty      = "field cannot be empty"
+	errorOperator        = "unknown operator: %q"
+	errorExpression      = "expression must have either a field, and, or or not"
+	errorLimitNegative   = "limit and offset cannot be negative"
+	errorInValue         = "value of in must be a slice"
+	errorPrefixValue     = "value of prefix must be a string"
+	errorExistsValue     = "value of exists must be a bool"
+	errorUnexpectedValue = "unexpected document: %s"
+)
+
+//Expression is a condition on a document: a comparison of a field with a value,
+//or the conjunction, disjunction or negation of other expressions.
+//Fields are dotted paths into nested objects, like "address.city".
+type Expression struct {
+	Field    string        `json:"field,omitempty"`
+	Operator string        `json:"operator,omitempty"`
+	Value    interface{}   `json:"value,omitempty"`
+	And      []*Expression `json:"and,omitempty"`
+	Or       []*Expression `json:"or,omitempty"`
+	Not      *Expression   `json:"not,omitempty"`
+}
+
+func compare(field, operator string, value interface{}) *Expression {
+	return &Expression{Field: field, Operator: operator, Value: value}
+}
+
+//Eq matches the documents whose field equals value, missing fields equal nil
+func Eq(field string, value interface{}) *Expression { return compare(field, OperatorEq, value) }
+
+//Ne matches the documents whose field differs from value
+func Ne(field string, value interface{}) *Expression { return compare(field, OperatorNe, value) }
+
+//Gt matches the documents whose field is greater than value
+func Gt(field string, value interface{}) *Expression { return compare(field, OperatorGt, value) }
+
+//Gte matches the documents whose field is greater than or equal to value
+func Gte(field string, value interface{}) *Expression { return compare(field, OperatorGte, value) }
+
+//Lt matches the documents whose field is lower than value
+func Lt(field string, value interface{}) *Expression { return compare(field, OperatorLt, value) }
+
+//Lte matches the documents whose field is lower than or equal to value
+func Lte(field string, value interface{}) *Expression { return compare(field, OperatorLte, value) }
+
+//In matches the documents whose field equals one of values
+func In(field string, values ...interface{}) *Expression { return compare(field, OperatorIn, values) }
+
+//Contains matches the documents whose field is a string containing value
+//or an array with an element equal to value
+func Contains(field string, value interface{}) *Expression {
+	return compare(field, OperatorContains, value)
+}
+
+//Prefix matches the documents whose field is a string starting with value
+func Prefix(field string, value string) *Expression { return compare(field, OperatorPrefix, value) }
+
+//Exists matches the documents having the field, null or not, if exists is true
+//and the ones not having it otherwise
+func Exists(field string, exists bool) *Expression { return compare(field, OperatorExists, exists) }
+
+//And matches the documents matching all the expressions
+func And(expressions ...*Expression) *Expression { return &Expression{And: expressions} }
+
+//Or matches the documents matching at least one of the expressions
+func Or(expressions ...*Expression) *Expression { return &Expression{Or: expressions} }
+
+//Not matches the documents not matching the expression
+func Not(expression *Expression) *Expression { return &Expression{Not: expression} }
+
+//Sort orders the documents by a field
+type Sort struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+//Query selects the documents matching Where, all of them if nil, sorted by Sort,
+//skipping the first Offset and returning at most Limit of them, zero for no limit.
+//Fields projects the documents on the given fields, the whole documents if empty.
+//
+//Queries are plain values that can be serialized to JSON. Stores evaluate them
+//natively when they can, the others scan the bucket with Scan.
+type Query struct {
+	Where  *Expression `json:"where,omitempty"`
+	Sort   []Sort      `json:"sort,omitempty"`
+	Limit  int         `json:"limit,omitempty"`
+	Offset int         `json:"offset,omitempty"`
+	Fields []string    `json:"fields,omitempty"`
+}
+
+//Validate checks the operators and the values of the query
+func (q *Query) Validate() error {
+	if q.Limit < 0 || q.Offset < 0 {
+		return errors.New(errorLimitNegative)
+	}
+	for _, s := range q.Sort {
+		if s.Field == "" {
+			return errors.New(errorFieldEmpty)
+		}
+	}
+	if q.Where == nil {
+		return nil
+	}
+	return q.Where.validate()
+}
+
+func (e *Expression) validate() error {
+	branches := 0
+	if e.Field != "" {
+		branches++
+	}
+	if len(e.And) > 0 {
+		branches++
+	}
+	if len(e.Or) > 0 {
+		branches++
+	}
+	if e.Not != nil {
+		branches++
+	}
+	if branches != 1 {
+		return errors.New(errorExpression)
+	}
+	for _, child := range append(append([]*Expression{}, e.And...), e.Or...) {
+		if child == nil {
+			return errors.New(errorExpression)
+		}
+		if err := child.validate(); err != nil {
+			return err
+		}
+	}
+	if e.Not != nil {
+		return e.Not.validate()
+	}
+	if e.Field == "" {
+		return nil
+	}
+	switch e.Operator {
+	case OperatorEq, OperatorNe, OperatorGt, OperatorGte, OperatorLt, OperatorLte, OperatorContains:
+	case OperatorIn:
+		if e.Value != nil && reflect.TypeOf(e.Value).Kind() != reflect.Slice {
+			return errors.New(errorInValue)
+		}
+	case OperatorPrefix:
+		if _, ok := e.Value.(string); !ok {
+			return errors.New(errorPrefixValue)
+		}
+	case OperatorExists:
+		if _, ok := e.Value.(bool); !ok {
+			return errors.New(errorExistsValue)
+		}
+	default:
+		return fmt.Errorf(errorOperator, e.Operator)
+	}
+	return nil
+}
+
+//Decode decodes a JSON document
+func Decode(data []byte) (map[string]interface{}, error) {
+	document := make(map[string]interface{})
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf(errorUnexpectedValue, err)
+	}
+	return document, nil
+}
+
+//lookup returns the value of a dotted field of a document
+func lookup(document map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = document
+	for _, name := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+//Match reports whether a document matches the query condition
+func (q *Query) Match(document map[string]interface{}) bool {
+	return q.Where == nil || q.Where.match(document)
+}
+
+func (e *Expression) match(document map[string]interface{}) bool {
+	switch {
+	case len(e.And) > 0:
+		for _, child := range e.And {
+			if !child.match(document) {
+				return false
+			}
+		}
+		return true
+	case len(e.Or) > 0:
+		for _, child := range e.Or {
+			if child.match(document) {
+				return true
+			}
+		}
+		return false
+	case e.Not != nil:
+		return !e.Not.match(document)
+	}
+
+	value, found := lookup(document, e.Field)
+	switch e.Operator {
+	case OperatorExists:
+		exists, ok := e.Value.(bool)
+		return ok && found == exists
+	case OperatorEq:
+		return equal(value, e.Value)
+	case OperatorNe:
+		return !equal(value, e.Value)
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte:
+		c, ok := order(value, e.Value)
+		if !ok {
+			return false
+		}
+		switch e.Operator {
+		case OperatorGt:
+			return c > 0
+		case OperatorGte:
+			return c >= 0
+		case OperatorLt:
+			return c < 0
+		}
+		return c <= 0
+	case OperatorIn:
+		values := reflect.ValueOf(e.Value)
+		if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+			return false
+		}
+		for i := 0; i < values.Len(); i++ {
+			if equal(value, values.Index(i).Interface()) {
+				return true
+			}
+		}
+		return false
+	case OperatorContains:
+		switch v := value.(type) {
+		case string:
+			s, ok := e.Value.(string)
+			return ok && strings.Contains(v, s)
+		case []interface{}:
+			for _, element := range v {
+				if equal(element, e.Value) {
+					return true
+				}
+			}
+		}
+		return false
+	case OperatorPrefix:
+		s, ok := value.(string)
+		prefix, valid := e.Value.(string)
+		return ok && valid && strings.HasPrefix(s, prefix)
+	}
+	return false
+}
+
+//number converts the numeric values, json.Number included, to float64 as decoded from JSON
+func number(v interface{}) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+//order compares two numbers or two strings
+func order(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+//rank orders the values of different types: missing and null first,
+//then booleans, numbers, strings and the others
+func rank(v interface{}) int {
+	if _, ok := number(v); ok {
+		return 2
+	}
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	}
+	return 4
+}
+
+func (q *Query) less(a, b map[string]interface{}) bool {
+	for _, s := range q.Sort {
+		x, _ := lookup(a, s.Field)
+		y, _ := lookup(b, s.Field)
+		c := rank(x) - rank(y)
+		if c == 0 {
+			switch v := x.(type) {
+			case bool:
+				if v != y.(bool) {
+					c = 1
+					if !v {
+						c = -1
+					}
+				}
+			default:
+				c, _ = order(x, y)
+			}
+		}
+		if c == 0 {
+			continue
+		}
+		if s.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+//Apply sorts, paginates and projects the matching documents
+func (q *Query) Apply(documents []map[string]interface{}) []map[string]interface{} {
+	if len(q.Sort) > 0 {
+		sort.SliceStable(documents, func(i, j int) bool {
+			return q.less(documents[i], documents[j])
+		})
+	}
+	if q.Offset >= len(documents) {
+		return []map[string]interface{}{}
+	}
+	documents = documents[q.Offset:]
+	if q.Limit > 0 && len(documents) > q.Limit {
+		documents = documents[:q.Limit]
+	}
+	if len(q.Fields) == 0 {
+		return documents
+	}
+	projected := make([]map[string]interface{}, len(documents))
+	for i, document := range documents {
+		projected[i] = q.project(document)
+	}
+	return projected
+}
+
+//project keeps the fields of the query, nested fields keep their path
+func (q *Query) project(document map[string]interface{}) map[string]interface{} {
+	projection := make(map[string]interface{})
+	for _, field := range q.Fields {
+		value, ok := lookup(document, field)
+		if !ok {
+			continue
+		}
+		names := strings.Split(field, ".")
+		current := projection
+		for _, name := range names[:len(names)-1] {
+			next, ok := current[name].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				current[name] = next
+			}
+			current = next
+		}
+		current[names[len(names)-1]] = value
+	}
+	return projection
+}
+
+//Enough reports whether the documents matched so far are enough to answer
+//the query, so that a scan can stop early: only unsorted queries with a limit
+func (q *Query) Enough(matched int) bool {
+	return len(q.Sort) == 0 && q.Limit > 0 && matched >= q.Offset+q.Limit
+}
+
+//Scan evaluates a query listing all the documents of a bucket of a store
+//following the List(bucket, prefix, func([]byte)) convention.
+//Values that are not JSON objects are skipped.
+func Scan(s interfaces.Store, bucket string, q *Query) ([]map[string]interface{}, error) {
+	if q == nil {
+		return nil, errors.New(errorQueryNil)
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	matched := make([]map[string]interface{}, 0)
+	_, err := s.List(bucket, "", func(data []byte) {
+		if q.Enough(len(matched)) {
+			return
+		}
+		document, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if q.Match(document) {
+			matched = append(matched, document)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q.Apply(matched), nil
+}
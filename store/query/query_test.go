@@ -0,0 +1,133 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var documents = []string{
+	`{"name":"alice","age":34,"admin":true,"tags":["go","rust"],"address":{"city":"Rome"}}`,
+	`{"name":"bob","age":27,"admin":false,"tags":["java"],"address":{"city":"Milan"}}`,
+	`{"name":"carol","age":41,"tags":["go"],"address":{"city":"Rome"}}`,
+	`{"name":"dave","age":27,"admin":false}`,
+}
+
+func decode(t *testing.T) []map[string]interface{} {
+	decoded := make([]map[string]interface{}, 0, len(documents))
+	for _, document := range documents {
+		d, err := Decode([]byte(document))
+		assert.NoError(t, err)
+		decoded = append(decoded, d)
+	}
+	return decoded
+}
+
+func run(t *testing.T, q *Query) []string {
+	assert.NoError(t, q.Validate())
+	matched := make([]map[string]interface{}, 0)
+	for _, document := range decode(t) {
+		if q.Match(document) {
+			matched = append(matched, document)
+		}
+	}
+	names := make([]string, 0)
+	for _, document := range q.Apply(matched) {
+		names = append(names, document["name"].(string))
+	}
+	return names
+}
+
+func TestQuery_Operators(t *testing.T) {
+	assert.Equal(t, []string{"alice", "bob", "carol", "dave"}, run(t, &Query{}))
+	assert.Equal(t, []string{"bob", "dave"}, run(t, &Query{Where: Eq("age", 27)}))
+	assert.Equal(t, []string{"alice", "carol"}, run(t, &Query{Where: Ne("age", 27)}))
+	assert.Equal(t, []string{"alice", "carol"}, run(t, &Query{Where: Gt("age", 30)}))
+	assert.Equal(t, []string{"alice", "carol"}, run(t, &Query{Where: Gte("age", 34)}))
+	assert.Equal(t, []string{"bob", "dave"}, run(t, &Query{Where: Lt("age", 33.5)}))
+	assert.Equal(t, []string{"alice", "bob", "dave"}, run(t, &Query{Where: Lte("age", int64(34))}))
+	assert.Equal(t, []string{"bob", "carol"}, run(t, &Query{Where: In("name", "bob", "carol", "eve")}))
+	assert.Equal(t, []string{"alice", "carol"}, run(t, &Query{Where: Contains("tags", "go")}))
+	assert.Equal(t, []string{"carol"}, run(t, &Query{Where: Contains("name", "aro")}))
+	assert.Equal(t, []string{"carol"}, run(t, &Query{Where: Prefix("name", "ca")}))
+	assert.Equal(t, []string{"carol"}, run(t, &Query{Where: Exists("admin", false)}))
+	assert.Equal(t, []string{"alice", "carol"}, run(t, &Query{Where: Eq("address.city", "Rome")}))
+	assert.Equal(t, []string{"dave"}, run(t, &Query{Where: Eq("address", nil)}))
+	assert.Equal(t, []string{"bob", "carol", "dave"}, run(t, &Query{Where: Gt("name", "alz")}), "strings")
+}
+
+func TestQuery_Logical(t *testing.T) {
+	q := &Query{Where: And(Eq("address.city", "Rome"), Or(Eq("admin", true), Gt("age", 40)))}
+	assert.Equal(t, []string{"alice", "carol"}, run(t, q))
+	q = &Query{Where: And(Eq("address.city", "Rome"), Not(Eq("admin", true)))}
+	assert.Equal(t, []string{"carol"}, run(t, q))
+}
+
+func TestQuery_SortLimitOffset(t *testing.T) {
+	q := &Query{Sort: []Sort{{Field: "age"}, {Field: "name", Descending: true}}}
+	assert.Equal(t, []string{"dave", "bob", "alice", "carol"}, run(t, q))
+	q.Offset = 1
+	q.Limit = 2
+	assert.Equal(t, []string{"bob", "alice"}, run(t, q))
+	q.Offset = 10
+	assert.Empty(t, run(t, q))
+	//missing fields first
+	q = &Query{Sort: []Sort{{Field: "admin"}, {Field: "name"}}}
+	assert.Equal(t, []string{"carol", "bob", "dave", "alice"}, run(t, q))
+}
+
+func TestQuery_Projection(t *testing.T) {
+	q := &Query{Where: Eq("name", "alice"), Fields: []string{"name", "address.city", "missing"}}
+	matched := make([]map[string]interface{}, 0)
+	for _, document := range decode(t) {
+		if q.Match(document) {
+			matched = append(matched, document)
+		}
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "alice", "address": map[string]interface{}{"city": "Rome"}},
+	}, q.Apply(matched))
+}
+
+func TestQuery_Validate(t *testing.T) {
+	assert.Error(t, (&Query{Limit: -1}).Validate())
+	assert.Error(t, (&Query{Sort: []Sort{{}}}).Validate())
+	assert.Error(t, (&Query{Where: &Expression{}}).Validate())
+	assert.Error(t, (&Query{Where: &Expression{Field: "a", Operator: "like"}}).Validate())
+	assert.Error(t, (&Query{Where: &Expression{Field: "a", Operator: OperatorIn, Value: 1}}).Validate())
+	assert.Error(t, (&Query{Where: &Expression{Field: "a", Operator: OperatorPrefix, Value: 1}}).Validate())
+	assert.Error(t, (&Query{Where: And(Eq("a", 1), nil)}).Validate())
+	assert.Error(t, (&Query{Where: &Expression{Field: "a", Operator: OperatorEq, Not: Eq("a", 1)}}).Validate())
+
+	//invalid queries match nothing instead of panicking
+	document := map[string]interface{}{"a": "abc"}
+	for _, e := range []*Expression{
+		{Field: "a", Operator: OperatorExists, Value: "true"},
+		{Field: "a", Operator: OperatorIn, Value: 1},
+		{Field: "a", Operator: OperatorPrefix, Value: 1},
+	} {
+		assert.False(t, (&Query{Where: e}).Match(document))
+	}
+}
+
+func TestQuery_JSON(t *testing.T) {
+	q := &Query{
+		Where:  And(Eq("address.city", "Rome"), In("tags", "go")),
+		Sort:   []Sort{{Field: "age", Descending: true}},
+		Limit:  1,
+		Fields: []string{"name"},
+	}
+	data, err := json.Marshal(q)
+	assert.NoError(t, err)
+	decoded := &Query{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.NoError(t, decoded.Validate())
+	assert.Equal(t, run(t, q), run(t, decoded))
+}
+
+func TestQuery_Number(t *testing.T) {
+	document := map[string]interface{}{"age": json.Number("34")}
+	assert.True(t, (&Query{Where: Gt("age", 30)}).Match(document))
+	assert.True(t, (&Query{Where: Eq("age", 34)}).Match(document))
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/advancedlogic/box/broker/codec"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+)
+
+const (
+	errorStoreNil     = "store cannot be nil"
+	errorBucketEmpty  = "bucket cannot be empty"
+	errorKeyNil       = "key function cannot be nil"
+	errorKeyEmpty     = "key cannot be empty"
+	errorCodecNil     = "codec cannot be nil"
+	errorIndexEmpty   = "index name cannot be empty"
+	errorIndexNil     = "index function cannot be nil"
+	errorIndexExists  = "index %s already defined"
+	errorIndexUnknown = "unknown index %s"
+	errorStoredType   = "stored value must be a string or a []byte"
+	errorStoreList    = "store cannot list the values: %w"
+)
+
+type Option[T any] func(*Repository[T]) error
+
+//WithCodec sets the serialization of the items. Default JSON.
+func WithCodec[T any](c codec.Codec) Option[T] {
+	return func(r *Repository[T]) error {
+		if c == nil {
+			return errors.New(errorCodecNil)
+		}
+		r.codec = c
+		return nil
+	}
+}
+
+//WithIndex indexes the items by the value returned by value, the items whose
+//value is empty are not indexed. The entries are kept in the bucket
+//<bucket>.<name> of the store.
+func WithIndex[T any](name string, value func(T) string) Option[T] {
+	return func(r *Repository[T]) error {
+		if name == "" {
+			return errors.New(errorIndexEmpty)
+		}
+		if value == nil {
+			return errors.New(errorIndexNil)
+		}
+		if _, ok := r.indexes[name]; ok {
+			return fmt.Errorf(errorIndexExists, name)
+		}
+		r.indexes[name] = value
+		return nil
+	}
+}
+
+//transactional is implemented by stores running writes atomically, like kv
+type transactional interface {
+	Transaction(func(interfaces.Store) error) error
+}
+
+//Repository maps the items of type T to the keys of a bucket of a Store,
+//serialized by a codec, and maintains indexes on chosen fields alongside them.
+//
+//Stores supporting transactions write an item and its index entries atomically.
+//With the other stores the writes are ordered so that a failure leaves at most
+//an item missing from an index until it is written again, or index entries of
+//older values or of missing items, which Find ignores, deleting the latter.
+//
+//Stores must accept []byte values, be an interfaces.Scanner passing the values
+//to List callbacks, like kv, fs and minio, and report missing keys with
+//store.ErrNotFound or os.ErrNotExist.
+type Repository[T any] struct {
+	store   interfaces.Store
+	bucket  string
+	key     func(T) string
+	codec   codec.Codec
+	indexes map[string]func(T) string
+}
+
+//New returns the repository of the items of a bucket, key returning the key of an item
+func New[T any](s interfaces.Store, bucket string, key func(T) string, options ...Option[T]) (*Repository[T], error) {
+	if s == nil {
+		return nil, errors.New(errorStoreNil)
+	}
+	if bucket == "" {
+		return nil, errors.New(errorBucketEmpty)
+	}
+	if key == nil {
+		return nil, errors.New(errorKeyNil)
+	}
+	//Find and All list the values
+	if err := store.Scanning(s); err != nil {
+		return nil, fmt.Errorf(errorStoreList, err)
+	}
+	r := &Repository[T]{
+		store:   s,
+		bucket:  bucket,
+		key:     key,
+		codec:   codec.JSON{},
+		indexes: make(map[string]func(T) string),
+	}
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+//Store returns the store of the items
+func (r *Repository[T]) Store() interfaces.Store {
+	return r.store
+}
+
+//atomic runs fn in a transaction if the store supports them
+func (r *Repository[T]) atomic(fn func(interfaces.Store) error) error {
+	if t, ok := r.store.(transactional); ok {
+		return t.Transaction(fn)
+	}
+	return fn(r.store)
+}
+
+func (r *Repository[T]) index(name string) string {
+	return r.bucket + "." + name
+}
+
+//entry returns the key of the entry of an index, the value and the key of the item
+//escaped so that the entries of a value share a prefix
+func entry(value string, key string) string {
+	return url.PathEscape(value) + "/" + url.PathEscape(key)
+}
+
+func (r *Repository[T]) decode(stored interface{}) (T, error) {
+	var item T
+	var data []byte
+	switch value := stored.(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return item, errors.New(errorStoredType)
+	}
+	err := r.codec.Unmarshal(data, &item)
+	return item, err
+}
+
+func (r *Repository[T]) get(s interfaces.Store, key string) (T, error) {
+	stored, err := s.Read(r.bucket, key)
+	if err != nil {
+		var item T
+		return item, err
+	}
+	return r.decode(stored)
+}
+
+//Get returns the item of a key, with the error of the store if missing
+func (r *Repository[T]) Get(key string) (T, error) {
+	if key == "" {
+		var item T
+		return item, errors.New(errorKeyEmpty)
+	}
+	return r.get(r.store, key)
+}
+
+//Put writes an item under its key, updating its index entries
+func (r *Repository[T]) Put(item T) error {
+	key := r.key(item)
+	if key == "" {
+		return errors.New(errorKeyEmpty)
+	}
+	data, err := r.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return r.atomic(func(s interfaces.Store) error {
+		var previous *T
+		if len(r.indexes) > 0 {
+			if old, err := r.get(s, key); err == nil {
+				previous = &old
+			}
+		}
+		//the item first, a failure leaves no entry of a missing item
+		if err := s.Update(r.bucket, key, data); err != nil {
+			return err
+		}
+		for name, value := range r.indexes {
+			current := value(item)
+			if current != "" {
+				if err := s.Update(r.index(name), entry(current, key), key); err != nil {
+					return err
+				}
+			}
+			if previous == nil {
+				continue
+			}
+			if old := value(*previous); old != "" && old != current {
+				if err := s.Delete(r.index(name), entry(old, key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+//Delete removes the item of a key and its index entries, with the error of the store if missing
+func (r *Repository[T]) Delete(key string) error {
+	if key == "" {
+		return errors.New(errorKeyEmpty)
+	}
+	return r.atomic(func(s interfaces.Store) error {
+		item, err := r.get(s, key)
+		if err != nil {
+			return err
+		}
+		//the entries first, a failure leaves no entry of a missing item
+		for name, value := range r.indexes {
+			if v := value(item); v != "" {
+				if err := s.Delete(r.index(name), entry(v, key)); err != nil {
+					return err
+				}
+			}
+		}
+		return s.Delete(r.bucket, key)
+	})
+}
+
+//Find returns the items whose index has a value, in the order of their keys
+func (r *Repository[T]) Find(index string, value string) ([]T, error) {
+	extract, ok := r.indexes[index]
+	if !ok {
+		return nil, fmt.Errorf(errorIndexUnknown, index)
+	}
+	prefix := url.PathEscape(value) + "/"
+	keys := make([]string, 0)
+	_, err := r.store.List(r.index(index), prefix, func(data []byte) {
+		keys = append(keys, string(data))
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, 0, len(keys))
+	for _, key := range keys {
+		item, err := r.get(r.store, key)
+		if err != nil {
+			if !store.NotFound(err) {
+				return nil, err
+			}
+			//deleted meanwhile or entry of an item whose write failed
+			if err := r.clean(index, value, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		//entries of older values left by a failed write
+		if extract(item) != value {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+//clean deletes the entry of an index pointing to a missing item, unless written meanwhile
+func (r *Repository[T]) clean(index string, value string, key string) error {
+	return r.atomic(func(s interfaces.Store) error {
+		if _, err := s.Read(r.bucket, key); !store.NotFound(err) {
+			//written meanwhile
+			return err
+		}
+		if _, err := s.Read(r.index(index), entry(value, key)); err != nil {
+			//deleted meanwhile
+			return nil
+		}
+		return s.Delete(r.index(index), entry(value, key))
+	})
+}
+
+//All returns the items whose key starts with prefix, in the order of their keys
+func (r *Repository[T]) All(prefix string) ([]T, error) {
+	items := make([]T, 0)
+	var failure error
+	_, err := r.store.List(r.bucket, prefix, func(data []byte) {
+		if failure != nil {
+			return
+		}
+		item, err := r.decode(data)
+		if err != nil {
+			failure = err
+			return
+		}
+		items = append(items, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, failure
+}
+
+//Reindex writes the index entries of every item, run it after adding an index
+//to a bucket holding items already
+func (r *Repository[T]) Reindex() error {
+	items, err := r.All("")
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		key := r.key(item)
+		err := r.atomic(func(s interfaces.Store) error {
+			for name, value := range r.indexes {
+				if v := value(item); v != "" {
+					if err := s.Update(r.index(name), entry(v, key), key); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/advancedlogic/box/broker/codec"
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/fs"
+	"github.com/advancedlogic/box/store/kv"
+	"github.com/advancedlogic/box/store/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	ID    string `json:"id" msgpack:"id"`
+	Email string `json:"email" msgpack:"email"`
+	Role  string `json:"role" msgpack:"role"`
+}
+
+func newRepository(t *testing.T, s interfaces.Store, options ...Option[user]) *Repository[user] {
+	options = append([]Option[user]{
+		WithIndex("email", func(u user) string { return u.Email }),
+		WithIndex("role", func(u user) string { return u.Role }),
+	}, options...)
+	r, err := New(s, "users", func(u user) string { return u.ID }, options...)
+	assert.NoError(t, err)
+	return r
+}
+
+func TestNew(t *testing.T) {
+	key := func(u user) string { return u.ID }
+	_, err := New(nil, "users", key)
+	assert.Error(t, err)
+	_, err = New(kvtest.New(t), "", key)
+	assert.Error(t, err)
+	_, err = New[user](kvtest.New(t), "users", nil)
+	assert.Error(t, err)
+	_, err = New(kvtest.New(t), "users", key, WithCodec[user](nil))
+	assert.Error(t, err)
+	_, err = New(kvtest.New(t), "users", key, WithIndex[user]("", func(u user) string { return u.Role }))
+	assert.Error(t, err)
+	_, err = New(kvtest.New(t), "users", key,
+		WithIndex("role", func(u user) string { return u.Role }),
+		WithIndex("role", func(u user) string { return u.Role }))
+	assert.Error(t, err)
+	//a store whose List does not pass the values
+	_, err = New(struct{ interfaces.Store }{kvtest.New(t)}, "users", key)
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+}
+
+//failing fails reading the items
+type failing struct {
+	*kv.Kv
+}
+
+func (f failing) Read(bucket string, key string) (interface{}, error) {
+	if bucket == "users" {
+		return nil, errors.New("outage")
+	}
+	return f.Kv.Read(bucket, key)
+}
+
+func testRepository(t *testing.T, r *Repository[user], notFound error) {
+	assert.NoError(t, r.Put(user{ID: "alice", Email: "alice@example.com", Role: "admin"}))
+	assert.NoError(t, r.Put(user{ID: "bob", Email: "bob@example.com", Role: "user"}))
+	assert.NoError(t, r.Put(user{ID: "carol", Email: "carol@example.com", Role: "admin"}))
+	assert.Error(t, r.Put(user{Email: "anonymous@example.com"}))
+
+	alice, err := r.Get("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", alice.Email)
+	_, err = r.Get("dave")
+	assert.True(t, errors.Is(err, notFound))
+
+	admins, err := r.Find("role", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, []user{
+		{ID: "alice", Email: "alice@example.com", Role: "admin"},
+		{ID: "carol", Email: "carol@example.com", Role: "admin"},
+	}, admins)
+	_, err = r.Find("name", "alice")
+	assert.Error(t, err)
+
+	//the entries of the previous values are removed
+	assert.NoError(t, r.Put(user{ID: "alice", Email: "alice@example.org", Role: "user"}))
+	admins, _ = r.Find("role", "admin")
+	assert.Len(t, admins, 1)
+	found, _ := r.Find("email", "alice@example.com")
+	assert.Empty(t, found)
+	found, _ = r.Find("email", "alice@example.org")
+	assert.Len(t, found, 1)
+
+	assert.NoError(t, r.Delete("alice"))
+	assert.True(t, errors.Is(r.Delete("alice"), notFound))
+	users, err := r.Find("role", "user")
+	assert.NoError(t, err)
+	assert.Equal(t, []user{{ID: "bob", Email: "bob@example.com", Role: "user"}}, users)
+
+	all, err := r.All("")
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestRepository_Kv(t *testing.T) {
+	testRepository(t, newRepository(t, kvtest.New(t)), kv.ErrNotFound)
+}
+
+func TestRepository_Fs(t *testing.T) {
+	f, err := fs.New(fs.WithRoot(t.TempDir()))
+	assert.NoError(t, err)
+	testRepository(t, newRepository(t, f), os.ErrNotExist)
+}
+
+func TestRepository_Codec(t *testing.T) {
+	k := kvtest.New(t)
+	r := newRepository(t, k, WithCodec[user](codec.Msgpack{}))
+	assert.NoError(t, r.Put(user{ID: "alice", Email: "alice@example.com", Role: "admin"}))
+	stored, _ := k.Read("users", "alice")
+	assert.NotContains(t, stored, "{")
+	admins, err := r.Find("role", "admin")
+	assert.NoError(t, err)
+	assert.Len(t, admins, 1)
+
+	//pointers are allocated
+	p, err := New(k, "users", func(u *user) string { return u.ID }, WithCodec[*user](codec.Msgpack{}))
+	assert.NoError(t, err)
+	alice, err := p.Get("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", alice.Role)
+}
+
+func TestRepository_StaleEntries(t *testing.T) {
+	k := kvtest.New(t)
+	r := newRepository(t, k)
+	assert.NoError(t, r.Put(user{ID: "alice", Email: "alice@example.com", Role: "admin"}))
+	//an entry left by a failed write and an entry of a missing item
+	assert.NoError(t, k.Create("users.role", entry("user", "alice"), "alice"))
+	assert.NoError(t, k.Create("users.role", entry("user", "bob"), "bob"))
+	users, err := r.Find("role", "user")
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	//the entry of the missing item is deleted
+	_, err = k.Read("users.role", entry("user", "bob"))
+	assert.Error(t, err)
+	users, err = r.Find("role", "user")
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	//the entries are kept when the items cannot be read
+	r = newRepository(t, failing{k})
+	_, err = r.Find("role", "admin")
+	assert.Error(t, err)
+	_, err = k.Read("users.role", entry("admin", "alice"))
+	assert.NoError(t, err)
+}
+
+func TestRepository_Reindex(t *testing.T) {
+	k := kvtest.New(t)
+	r, _ := New(k, "users", func(u user) string { return u.ID })
+	assert.NoError(t, r.Put(user{ID: "alice", Email: "alice@example.com", Role: "admin"}))
+	r = newRepository(t, k)
+	admins, _ := r.Find("role", "admin")
+	assert.Empty(t, admins)
+	assert.NoError(t, r.Reindex())
+	admins, err := r.Find("role", "admin")
+	assert.NoError(t, err)
+	assert.Len(t, admins, 1)
+}
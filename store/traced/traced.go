@@ -0,0 +1,148 @@
+package traced
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/tracer"
+)
+
+//WithStore sets the store to be traced
+func WithStore(s interfaces.Store) store.Option {
+	return func(i interfaces.Store) error {
+		if s != nil {
+			t := i.(*Traced)
+			t.store = s
+			return nil
+		}
+		return errors.New("store cannot be nil")
+	}
+}
+
+func WithTracer(tracer interfaces.Tracer) store.Option {
+	return func(i interfaces.Store) error {
+		if tracer != nil {
+			t := i.(*Traced)
+			t.tracer = tracer
+			return nil
+		}
+		return errors.New("tracer cannot be nil")
+	}
+}
+
+//Store is a traced store, see New
+type Store interface {
+	interfaces.Store
+	WithContext(context.Context) Store
+}
+
+//Traced wraps a Store creating a span for every call.
+//Use WithContext to make the spans children of the span of a request.
+type Traced struct {
+	store  interfaces.Store
+	tracer interfaces.Tracer
+	ctx    context.Context
+}
+
+//New returns a *Traced, a *Versioned if the store traced keeps revisions or a
+//*Historian if it keeps their history, supporting what the store supports
+func New(options ...store.Option) (Store, error) {
+	t := &Traced{
+		ctx: context.Background(),
+	}
+	for _, option := range options {
+		if err := option(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.store == nil {
+		return nil, errors.New("store cannot be nil")
+	}
+	if t.tracer == nil {
+		return nil, errors.New("tracer cannot be nil")
+	}
+	return t.wrap(), nil
+}
+
+//WithContext returns a copy of the store whose spans are children of the span in ctx
+func (t *Traced) WithContext(ctx context.Context) Store {
+	return (&Traced{
+		store:  t.store,
+		tracer: t.tracer,
+		ctx:    ctx,
+	}).wrap()
+}
+
+func (t *Traced) start(operation, bucket, key string) interfaces.Span {
+	_, span := t.tracer.Start(t.ctx, fmt.Sprintf("store %s", operation), tracer.KindClient)
+	span.SetAttribute("store.operation", operation)
+	span.SetAttribute("store.bucket", bucket)
+	if key != "" {
+		span.SetAttribute("store.key", key)
+	}
+	return span
+}
+
+func (t *Traced) Create(bucket string, key string, data interface{}) error {
+	span := t.start("create", bucket, key)
+	defer span.End()
+	err := t.store.Create(bucket, key, data)
+	span.SetError(err)
+	return err
+}
+
+func (t *Traced) Read(bucket string, key string) (interface{}, error) {
+	span := t.start("read", bucket, key)
+	defer span.End()
+	value, err := t.store.Read(bucket, key)
+	span.SetError(err)
+	return value, err
+}
+
+func (t *Traced) Update(bucket string, key string, data interface{}) error {
+	span := t.start("update", bucket, key)
+	defer span.End()
+	err := t.store.Update(bucket, key, data)
+	span.SetError(err)
+	return err
+}
+
+func (t *Traced) Delete(bucket string, key string) error {
+	span := t.start("delete", bucket, key)
+	defer span.End()
+	err := t.store.Delete(bucket, key)
+	span.SetError(err)
+	return err
+}
+
+//Scans reports whether the List of the store traced passes the values to a callback
+func (t *Traced) Scans() bool {
+	return store.Scanning(t.store) == nil
+}
+
+func (t *Traced) List(bucket string, params ...interface{}) (interface{}, error) {
+	span := t.start("list", bucket, "")
+	defer span.End()
+	value, err := t.store.List(bucket, params...)
+	span.SetError(err)
+	return value, err
+}
+
+func (t *Traced) Query(bucket string, params ...interface{}) (interface{}, error) {
+	span := t.start("query", bucket, "")
+	defer span.End()
+	value, err := t.store.Query(bucket, params...)
+	span.SetError(err)
+	return value, err
+}
+
+func (t *Traced) Buckets() (interface{}, error) {
+	span := t.start("buckets", "", "")
+	defer span.End()
+	value, err := t.store.Buckets()
+	span.SetError(err)
+	return value, err
+}
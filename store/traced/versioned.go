@@ -0,0 +1,80 @@
+package traced
+
+import (
+	"github.com/advancedlogic/box/interfaces"
+)
+
+//Versioned is a Traced store whose store keeps revisions, the conditional
+//writes are traced like the other calls
+type Versioned struct {
+	*Traced
+	versioned interfaces.Versioned
+}
+
+//Historian is a Versioned store whose store keeps the history of the revisions
+type Historian struct {
+	*Versioned
+	historian interfaces.Historian
+}
+
+//wrap returns the variant supporting what the store traced supports
+func (t *Traced) wrap() Store {
+	if h, ok := t.store.(interfaces.Historian); ok {
+		return &Historian{Versioned: &Versioned{Traced: t, versioned: h}, historian: h}
+	}
+	if v, ok := t.store.(interfaces.Versioned); ok {
+		return &Versioned{Traced: t, versioned: v}
+	}
+	return t
+}
+
+func (v *Versioned) Insert(bucket string, key string, data interface{}) (string, error) {
+	span := v.start("insert", bucket, key)
+	defer span.End()
+	revision, err := v.versioned.Insert(bucket, key, data)
+	span.SetError(err)
+	return revision, err
+}
+
+func (v *Versioned) ReadRevision(bucket string, key string) (interface{}, string, error) {
+	span := v.start("read", bucket, key)
+	defer span.End()
+	value, revision, err := v.versioned.ReadRevision(bucket, key)
+	span.SetError(err)
+	return value, revision, err
+}
+
+func (v *Versioned) UpdateIf(bucket string, key string, data interface{}, revision string) (string, error) {
+	span := v.start("update", bucket, key)
+	defer span.End()
+	span.SetAttribute("store.revision", revision)
+	updated, err := v.versioned.UpdateIf(bucket, key, data, revision)
+	span.SetError(err)
+	return updated, err
+}
+
+func (v *Versioned) DeleteIf(bucket string, key string, revision string) error {
+	span := v.start("delete", bucket, key)
+	defer span.End()
+	span.SetAttribute("store.revision", revision)
+	err := v.versioned.DeleteIf(bucket, key, revision)
+	span.SetError(err)
+	return err
+}
+
+func (h *Historian) History(bucket string, key string) ([]interfaces.Revision, error) {
+	span := h.start("history", bucket, key)
+	defer span.End()
+	revisions, err := h.historian.History(bucket, key)
+	span.SetError(err)
+	return revisions, err
+}
+
+func (h *Historian) ReadAt(bucket string, key string, revision string) (interface{}, error) {
+	span := h.start("read", bucket, key)
+	defer span.End()
+	span.SetAttribute("store.revision", revision)
+	value, err := h.historian.ReadAt(bucket, key, revision)
+	span.SetError(err)
+	return value, err
+}
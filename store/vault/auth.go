@@ -0,0 +1,201 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+//KubernetesToken is the default path of the token of the service account of a pod
+const KubernetesToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+//login authenticates with an auth method mounted at mount
+type login struct {
+	method string
+	mount  string
+	//path after auth/<mount>/login
+	user string
+	data map[string]interface{}
+	//file read at every login, for the kubernetes service account token
+	jwtFile string
+}
+
+func (l *login) path() string {
+	path := fmt.Sprintf("auth/%s/login", l.mount)
+	if l.user != "" {
+		path += "/" + l.user
+	}
+	return path
+}
+
+func (l *login) payload() (map[string]interface{}, error) {
+	if l.jwtFile == "" {
+		return l.data, nil
+	}
+	jwt, err := ioutil.ReadFile(l.jwtFile)
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]interface{}{"jwt": strings.TrimSpace(string(jwt))}
+	for k, v := range l.data {
+		payload[k] = v
+	}
+	return payload, nil
+}
+
+//WithAppRole authenticates with the AppRole auth method
+func WithAppRole(roleID, secretID string) store.Option {
+	return func(s interfaces.Store) error {
+		if roleID == "" {
+			return errors.New("role id cannot be empty")
+		}
+		vault := s.(*Vault)
+		data := map[string]interface{}{"role_id": roleID}
+		if secretID != "" {
+			data["secret_id"] = secretID
+		}
+		vault.login = &login{method: "approle", mount: "approle", data: data}
+		return nil
+	}
+}
+
+//WithKubernetes authenticates with the Kubernetes auth method as role, with
+//the service account token read from file, KubernetesToken if empty
+func WithKubernetes(role string, file string) store.Option {
+	return func(s interfaces.Store) error {
+		if role == "" {
+			return errors.New("role cannot be empty")
+		}
+		if file == "" {
+			file = KubernetesToken
+		}
+		vault := s.(*Vault)
+		vault.login = &login{
+			method:  "kubernetes",
+			mount:   "kubernetes",
+			data:    map[string]interface{}{"role": role},
+			jwtFile: file,
+		}
+		return nil
+	}
+}
+
+//WithUserpass authenticates with the userpass auth method
+func WithUserpass(username, password string) store.Option {
+	return func(s interfaces.Store) error {
+		if username == "" || password == "" {
+			return errors.New("username or password cannot be empty")
+		}
+		vault := s.(*Vault)
+		vault.login = &login{
+			method: "userpass",
+			mount:  "userpass",
+			user:   username,
+			data:   map[string]interface{}{"password": password},
+		}
+		return nil
+	}
+}
+
+//WithAuthMount sets the path the auth method is mounted at, default the name of
+//the method. It must follow the option of the auth method.
+func WithAuthMount(mount string) store.Option {
+	return func(s interfaces.Store) error {
+		vault := s.(*Vault)
+		if vault.login == nil {
+			return errors.New("auth method must be set before its mount")
+		}
+		if mount = strings.Trim(mount, "/"); mount == "" {
+			return errors.New("mount cannot be empty")
+		}
+		vault.login.mount = mount
+		return nil
+	}
+}
+
+//authenticate logs in and sets the token of the client
+func (v *Vault) authenticate(client *api.Client) (*api.Secret, error) {
+	payload, err := v.login.payload()
+	if err != nil {
+		return nil, err
+	}
+	var secret *api.Secret
+	err = v.try(client, func(client *api.Client) error {
+		request := client.NewRequest("PUT", "/v1/"+v.login.path())
+		//logging in without the token of a previous login
+		request.ClientToken = ""
+		if err := request.SetJSONBody(payload); err != nil {
+			return err
+		}
+		response, err := client.RawRequest(request)
+		if response != nil {
+			defer response.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+		secret, err = api.ParseSecret(response.Body)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s login", v.login.method)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, errors.Errorf("%s login returned no token", v.login.method)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+//renew renews the token of a login in background and logs in again when it
+//cannot be renewed anymore, or before it expires if it is not renewable.
+//It must be called holding the lock.
+func (v *Vault) renew(client *api.Client, secret *api.Secret) {
+	var done <-chan error
+	var expiry <-chan time.Time
+	if secret.Auth.Renewable {
+		renewer, err := client.NewRenewer(&api.RenewerInput{Secret: secret})
+		if err != nil {
+			return
+		}
+		v.renewer = renewer
+		done = renewer.DoneCh()
+		go renewer.Renew()
+	} else if secret.Auth.LeaseDuration > 0 {
+		//at two thirds of the lease, like the renewals
+		expiry = time.After(time.Duration(secret.Auth.LeaseDuration) * time.Second * 2 / 3)
+	} else {
+		return
+	}
+	closed := v.closed
+	go func() {
+		select {
+		case <-closed:
+			return
+		case <-done:
+		case <-expiry:
+		}
+		for {
+			secret, err := v.authenticate(client)
+			if err == nil {
+				v.lock.Lock()
+				defer v.lock.Unlock()
+				if v.closed == closed {
+					v.renew(client, secret)
+				}
+				return
+			}
+			select {
+			case <-closed:
+				return
+			case <-time.After(v.timeout):
+			}
+		}
+	}()
+}
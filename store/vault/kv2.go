@@ -0,0 +1,147 @@
+package vault
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/advancedlogic/box/store"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+//Version describes a version of a secret of a KV version 2 engine
+type Version struct {
+	Created   time.Time
+	Deleted   time.Time
+	Destroyed bool
+}
+
+//Metadata describes the versions of a secret of a KV version 2 engine
+type Metadata struct {
+	CurrentVersion int
+	OldestVersion  int
+	MaxVersions    int
+	Created        time.Time
+	Updated        time.Time
+	Versions       map[int]Version
+}
+
+func (v *Vault) kv2Only(namespace string) error {
+	if !v.isKV2(namespace) {
+		return errors.Wrapf(store.ErrNotSupported, "%s is not a kv version 2 engine", namespace)
+	}
+	return nil
+}
+
+func number(value interface{}) int {
+	switch n := value.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func timestamp(value interface{}) time.Time {
+	s, _ := value.(string)
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+//ReadVersion returns the data of a version of a secret
+func (v *Vault) ReadVersion(namespace string, key string, version int) (map[string]interface{}, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return nil, err
+	}
+	if version <= 0 {
+		return nil, errors.New("version must be greater than zero")
+	}
+	return v.read(namespace, key, map[string][]string{"version": {strconv.Itoa(version)}})
+}
+
+//Metadata returns the versions of a secret
+func (v *Vault) Metadata(namespace string, key string) (*Metadata, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return nil, err
+	}
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().Read(v.path(namespace, "metadata", key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.Wrapf(ErrNotFound, "%s/%s", namespace, key)
+	}
+	metadata := &Metadata{
+		CurrentVersion: number(secret.Data["current_version"]),
+		OldestVersion:  number(secret.Data["oldest_version"]),
+		MaxVersions:    number(secret.Data["max_versions"]),
+		Created:        timestamp(secret.Data["created_time"]),
+		Updated:        timestamp(secret.Data["updated_time"]),
+		Versions:       make(map[int]Version),
+	}
+	versions, _ := secret.Data["versions"].(map[string]interface{})
+	for number, value := range versions {
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		version, _ := value.(map[string]interface{})
+		destroyed, _ := version["destroyed"].(bool)
+		metadata.Versions[n] = Version{
+			Created:   timestamp(version["created_time"]),
+			Deleted:   timestamp(version["deletion_time"]),
+			Destroyed: destroyed,
+		}
+	}
+	return metadata, nil
+}
+
+//versions posts versions of a secret to the endpoint of an action
+func (v *Vault) versions(action string, namespace string, key string, versions []int) error {
+	if err := v.kv2Only(namespace); err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("at least one version must be provided")
+	}
+	return v.do(func(client *api.Client) error {
+		_, err := client.Logical().Write(v.path(namespace, action, key), map[string]interface{}{
+			"versions": versions,
+		})
+		return err
+	})
+}
+
+//DeleteVersions soft deletes versions of a secret, they can be undeleted
+func (v *Vault) DeleteVersions(namespace string, key string, versions ...int) error {
+	return v.versions("delete", namespace, key, versions)
+}
+
+//Undelete restores soft deleted versions of a secret
+func (v *Vault) Undelete(namespace string, key string, versions ...int) error {
+	return v.versions("undelete", namespace, key, versions)
+}
+
+//Destroy removes permanently the data of versions of a secret
+func (v *Vault) Destroy(namespace string, key string, versions ...int) error {
+	return v.versions("destroy", namespace, key, versions)
+}
+
+//Purge removes permanently every version and the metadata of a secret
+func (v *Vault) Purge(namespace string, key string) error {
+	if err := v.kv2Only(namespace); err != nil {
+		return err
+	}
+	return v.do(func(client *api.Client) error {
+		_, err := client.Logical().Delete(v.path(namespace, "metadata", key))
+		return err
+	})
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+//Lease is a dynamic secret, like database or cloud credentials, valid for Duration
+type Lease struct {
+	ID        string
+	Path      string
+	Data      map[string]interface{}
+	Duration  time.Duration
+	Renewable bool
+}
+
+func lease(path string, secret *api.Secret) *Lease {
+	return &Lease{
+		ID:        secret.LeaseID,
+		Path:      path,
+		Data:      secret.Data,
+		Duration:  time.Duration(secret.LeaseDuration) * time.Second,
+		Renewable: secret.Renewable,
+	}
+}
+
+//Lease requests a dynamic secret, reading path or writing data to it if not nil,
+//like database/creds/<role> or aws/sts/<role>
+func (v *Vault) Lease(path string, data map[string]interface{}) (*Lease, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, errors.New("path cannot be empty")
+	}
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		if data == nil {
+			secret, err = client.Logical().Read(path)
+		} else {
+			secret, err = client.Logical().Write(path, data)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.Wrap(ErrNotFound, path)
+	}
+	return lease(path, secret), nil
+}
+
+//Renew extends a lease by increment, or by the default duration of its secret
+//if zero, and returns its new duration
+func (v *Vault) Renew(id string, increment time.Duration) (time.Duration, error) {
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Sys().Renew(id, int(increment.Seconds()))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil {
+		return 0, errors.Errorf("lease %s not renewed", id)
+	}
+	return time.Duration(secret.LeaseDuration) * time.Second, nil
+}
+
+//Revoke revokes a lease, invalidating its secret
+func (v *Vault) Revoke(id string) error {
+	return v.do(func(client *api.Client) error {
+		return client.Sys().Revoke(id)
+	})
+}
@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+//Transit wraps data keys with a named key of the Vault transit secrets engine,
+//the master key never leaving Vault. It implements encrypted.Keyring.
+type Transit struct {
+	vault *Vault
+	mount string
+	key   string
+}
+
+//NewTransit returns the keyring of the key of the transit engine mounted at mount, default transit
+func NewTransit(v *Vault, mount string, key string) (*Transit, error) {
+	if v == nil {
+		return nil, errors.New("vault cannot be nil")
+	}
+	if key == "" {
+		return nil, errors.New("key cannot be empty")
+	}
+	if mount == "" {
+		mount = "transit"
+	}
+	return &Transit{vault: v, mount: strings.Trim(mount, "/"), key: key}, nil
+}
+
+//version returns the version of the key wrapping a ciphertext, vault:v1:base64
+func version(ciphertext string) (int, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, errors.New("ciphertext is malformed")
+	}
+	return strconv.Atoi(parts[1][1:])
+}
+
+func (t *Transit) write(path string, data map[string]interface{}) (*api.Secret, error) {
+	var secret *api.Secret
+	err := t.vault.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().Write(path, data)
+		return err
+	})
+	return secret, err
+}
+
+func (t *Transit) Wrap(key []byte) (string, int, error) {
+	secret, err := t.write(fmt.Sprintf("%s/encrypt/%s", t.mount, t.key), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if secret == nil {
+		return "", 0, errors.New("empty response from transit encrypt")
+	}
+	ciphertext, _ := secret.Data["ciphertext"].(string)
+	v, err := version(ciphertext)
+	if err != nil {
+		return "", 0, err
+	}
+	return ciphertext, v, nil
+}
+
+func (t *Transit) Unwrap(wrapped string) ([]byte, error) {
+	secret, err := t.write(fmt.Sprintf("%s/decrypt/%s", t.mount, t.key), map[string]interface{}{
+		"ciphertext": wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("empty response from transit decrypt")
+	}
+	plaintext, _ := secret.Data["plaintext"].(string)
+	return base64.StdEncoding.DecodeString(plaintext)
+}
+
+//Version returns the latest version of the key
+func (t *Transit) Version() (int, error) {
+	var secret *api.Secret
+	err := t.vault.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().Read(fmt.Sprintf("%s/keys/%s", t.mount, t.key))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil {
+		return 0, errors.Errorf("transit key %s not found", t.key)
+	}
+	latest, ok := secret.Data["latest_version"].(json.Number)
+	if !ok {
+		return 0, errors.New("transit key has no latest version")
+	}
+	v, err := latest.Int64()
+	return int(v), err
+}
+
+//Rotate creates a new version of the key in Vault
+func (t *Transit) Rotate() error {
+	_, err := t.write(fmt.Sprintf("%s/keys/%s/rotate", t.mount, t.key), nil)
+	return err
+}
@@ -1,16 +1,26 @@
 package vault
 
 import (
-	"fmt"
+	"context"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/advancedlogic/box/commons"
 	"github.com/advancedlogic/box/interfaces"
 	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
 )
 
+//ErrNotFound is returned reading a missing secret, or a deleted version
+var ErrNotFound = errors.New("not found")
+
+//Vault is a store of the secrets of a Vault cluster, the buckets being the
+//paths of the KV secrets engines. It keeps one client, failing over to the
+//next server when the current one is unreachable, sealed or in standby.
 type Vault struct {
 	id                  string
 	namespace           string
@@ -18,6 +28,17 @@ type Vault struct {
 	servers             []string
 	timeout             time.Duration
 	skipTLSVerification bool
+	caCert              string
+	login               *login
+	//mounts of the KV version 2 engines, all of them if kv2All
+	kv2    map[string]bool
+	kv2All bool
+
+	lock    sync.Mutex
+	moving  sync.Mutex
+	client  *api.Client
+	renewer *api.Renewer
+	closed  chan struct{}
 }
 
 func WithToken(token string) store.Option {
@@ -31,6 +52,7 @@ func WithToken(token string) store.Option {
 	}
 }
 
+//WithNamespace sets the Vault Enterprise namespace of the requests
 func WithNamespace(namespace string) store.Option {
 	return func(s interfaces.Store) error {
 		if namespace != "" {
@@ -38,15 +60,19 @@ func WithNamespace(namespace string) store.Option {
 			vault.namespace = namespace
 			return nil
 		}
-		return errors.New("token cannot be empty")
+		return errors.New("namespace cannot be empty")
 	}
 }
 
+//WithServers sets the addresses of the servers, the first one is used until it fails
 func WithServers(servers ...string) store.Option {
 	return func(s interfaces.Store) error {
 		if len(servers) > 0 {
 			vault := s.(*Vault)
 			for _, server := range servers {
+				if server == "" {
+					return errors.New("server cannot be empty")
+				}
 				vault.servers = append(vault.servers, server)
 			}
 			return nil
@@ -55,6 +81,7 @@ func WithServers(servers ...string) store.Option {
 	}
 }
 
+//SkipTLSVerification disables the verification of the certificates of the servers. Default false.
 func SkipTLSVerification(skip bool) store.Option {
 	return func(s interfaces.Store) error {
 		vault := s.(*Vault)
@@ -63,14 +90,55 @@ func SkipTLSVerification(skip bool) store.Option {
 	}
 }
 
+//WithCA verifies the certificates of the servers with the CA of the PEM file
+func WithCA(file string) store.Option {
+	return func(s interfaces.Store) error {
+		if file != "" {
+			vault := s.(*Vault)
+			vault.caCert = file
+			return nil
+		}
+		return errors.New("ca file cannot be empty")
+	}
+}
+
+//WithTimeout sets the timeout of the requests. Default 10s.
+func WithTimeout(timeout time.Duration) store.Option {
+	return func(s interfaces.Store) error {
+		if timeout > 0 {
+			vault := s.(*Vault)
+			vault.timeout = timeout
+			return nil
+		}
+		return errors.New("timeout must be greater than zero")
+	}
+}
+
+//WithKV2 sets the mounts of the KV version 2 engines, all of them if none
+func WithKV2(mounts ...string) store.Option {
+	return func(s interfaces.Store) error {
+		vault := s.(*Vault)
+		if len(mounts) == 0 {
+			vault.kv2All = true
+			return nil
+		}
+		for _, mount := range mounts {
+			if mount = strings.Trim(mount, "/"); mount == "" {
+				return errors.New("mount cannot be empty")
+			}
+			vault.kv2[mount] = true
+		}
+		return nil
+	}
+}
+
 func New(options ...store.Option) (*Vault, error) {
 	v := &Vault{
-		id:                  commons.UUID(),
-		namespace:           "default",
-		token:               "",
-		servers:             make([]string, 0),
-		timeout:             10 * time.Second,
-		skipTLSVerification: true,
+		id:      commons.UUID(),
+		token:   "",
+		servers: make([]string, 0),
+		timeout: 10 * time.Second,
+		kv2:     make(map[string]bool),
 	}
 
 	for _, option := range options {
@@ -78,15 +146,26 @@ func New(options ...store.Option) (*Vault, error) {
 			return nil, err
 		}
 	}
+	if len(v.servers) == 0 {
+		return nil, errors.New("at least one server must be provided")
+	}
 
 	return v, nil
 }
 
+//connect returns the client, creating and authenticating it the first time
 func (v *Vault) connect() (*api.Client, error) {
-	config := &api.Config{
-		Address: v.servers[0],
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.client != nil {
+		return v.client, nil
 	}
+
+	config := api.DefaultConfig()
+	config.Address = v.servers[0]
+	config.Timeout = v.timeout
 	if err := config.ConfigureTLS(&api.TLSConfig{
+		CACert:   v.caCert,
 		Insecure: v.skipTLSVerification,
 	}); err != nil {
 		return nil, err
@@ -95,66 +174,283 @@ func (v *Vault) connect() (*api.Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	//the other servers are tried instead of retrying the failed one
+	if len(v.servers) > 1 {
+		client.SetMaxRetries(0)
+	}
+	if v.namespace != "" {
+		client.SetNamespace(v.namespace)
+	}
 	client.SetToken(v.token)
+	if v.login != nil {
+		secret, err := v.authenticate(client)
+		if err != nil {
+			return nil, err
+		}
+		v.closed = make(chan struct{})
+		v.renew(client, secret)
+	}
+	v.client = client
 	return client, nil
 }
 
-func (v *Vault) Create(namespace string, key string, value interface{}) error {
+//failover tells if a request should be tried on the next server:
+//the server is unreachable, sealed, in standby or failing
+func failover(err error) bool {
+	if response, ok := errors.Cause(err).(*api.ResponseError); ok {
+		return response.StatusCode >= 500
+	}
+	return true
+}
+
+//next points the client to the server after the failed one
+func (v *Vault) next(client *api.Client, failed string) {
+	v.moving.Lock()
+	defer v.moving.Unlock()
+	if client.Address() != failed {
+		//already moved by a concurrent request
+		return
+	}
+	current := 0
+	for s, server := range v.servers {
+		if strings.TrimSuffix(server, "/") == strings.TrimSuffix(failed, "/") {
+			current = s
+			break
+		}
+	}
+	client.SetAddress(v.servers[(current+1)%len(v.servers)])
+}
+
+//try calls Vault trying every server once
+func (v *Vault) try(client *api.Client, call func(*api.Client) error) error {
+	var err error
+	for attempt := 0; attempt < len(v.servers); attempt++ {
+		address := client.Address()
+		if err = call(client); err == nil || !failover(err) {
+			return err
+		}
+		v.next(client, address)
+	}
+	return err
+}
+
+//do calls Vault with the client, failing over across the servers
+func (v *Vault) do(call func(*api.Client) error) error {
 	client, err := v.connect()
 	if err != nil {
 		return err
 	}
+	return v.try(client, call)
+}
 
-	_, err = client.Logical().Write(fmt.Sprintf("/%s/%s", namespace, key), value.(map[string]interface{}))
-	if err != nil {
-		return err
+//Check verifies that a server is reachable, initialized and unsealed,
+//failing over across the servers. Standby servers forward the requests.
+func (v *Vault) Check(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	return v.do(func(client *api.Client) error {
+		request := client.NewRequest("GET", "/v1/sys/health")
+		request.Params.Add("standbycode", "200")
+		request.Params.Add("perfstandbycode", "200")
+		response, err := client.RawRequestWithContext(ctx, request)
+		if err != nil {
+			return err
+		}
+		return response.Body.Close()
+	})
+}
+
+//Close stops renewing the token and drops the client, the next call connects again
+func (v *Vault) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.closed != nil {
+		close(v.closed)
+		v.closed = nil
+	}
+	if v.renewer != nil {
+		v.renewer.Stop()
+		v.renewer = nil
+	}
+	v.client = nil
 	return nil
 }
 
-func (v *Vault) Read(namespace string, key string) (interface{}, error) {
-	client, err := v.connect()
-	if err != nil {
-		return nil, err
+//isKV2 tells if the mount of a path is a KV version 2 engine
+func (v *Vault) isKV2(mount string) bool {
+	return v.kv2All || v.kv2[strings.Trim(mount, "/")]
+}
+
+//path returns the path of a key, under the kind of endpoint for KV version 2 engines
+func (v *Vault) path(mount, kind, key string) string {
+	mount = strings.Trim(mount, "/")
+	key = strings.Trim(key, "/")
+	if v.isKV2(mount) {
+		mount = mount + "/" + kind
+	}
+	if key == "" {
+		return mount
 	}
+	return mount + "/" + key
+}
 
-	secret, err := client.Logical().Read(fmt.Sprintf("/%s/%s", namespace, key))
-	if err != nil {
-		return nil, err
+func (v *Vault) Create(namespace string, key string, value interface{}) error {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return errors.New("value must be a map[string]interface{}")
+	}
+	if v.isKV2(namespace) {
+		data = map[string]interface{}{"data": data}
 	}
+	return v.do(func(client *api.Client) error {
+		_, err := client.Logical().Write(v.path(namespace, "data", key), data)
+		return err
+	})
+}
+
+//Read returns the data of a secret, the latest version for KV version 2 engines
+func (v *Vault) Read(namespace string, key string) (interface{}, error) {
+	return v.read(namespace, key, nil)
+}
+
+func (v *Vault) read(namespace string, key string, params map[string][]string) (map[string]interface{}, error) {
+	data, _, err := v.readVersion(namespace, key, params)
+	return data, err
+}
 
-	return secret.Data, nil
+//readVersion returns the data of a secret and its version, 0 for KV version 1 engines
+func (v *Vault) readVersion(namespace string, key string, params map[string][]string) (map[string]interface{}, int, error) {
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().ReadWithData(v.path(namespace, "data", key), params)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil {
+		return nil, 0, errors.Wrapf(ErrNotFound, "%s/%s", namespace, key)
+	}
+	if !v.isKV2(namespace) {
+		return secret.Data, 0, nil
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		//deleted or destroyed version
+		return nil, 0, errors.Wrapf(ErrNotFound, "%s/%s", namespace, key)
+	}
+	metadata, _ := secret.Data["metadata"].(map[string]interface{})
+	return data, number(metadata["version"]), nil
 }
 
 func (v *Vault) Update(namespace string, key string, value interface{}) error {
 	return v.Create(namespace, key, value)
 }
 
+//Delete removes a secret, soft deleting its latest version for KV version 2 engines
 func (v *Vault) Delete(namespace string, key string) error {
-	client, err := v.connect()
-	if err != nil {
+	return v.do(func(client *api.Client) error {
+		_, err := client.Logical().Delete(v.path(namespace, "data", key))
 		return err
-	}
+	})
+}
 
-	_, err = client.Logical().Delete(fmt.Sprintf("/%s/%s", namespace, key))
-	if err != nil {
+//keys returns the keys under a prefix, the ones ending with / being folders
+func (v *Vault) keys(namespace string, prefix string) ([]string, error) {
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().List(v.path(namespace, "metadata", prefix))
 		return err
+	})
+	if err != nil || secret == nil {
+		return nil, err
 	}
-	return nil
+	list, _ := secret.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(list))
+	for _, key := range list {
+		if k, ok := key.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
 }
 
+//List returns the keys of a namespace, or of a folder: List(namespace, prefix string)
+//returns map[string]interface{}{"keys": []string}
 func (v *Vault) List(namespace string, params ...interface{}) (interface{}, error) {
-	client, err := v.connect()
+	prefix := ""
+	if len(params) > 0 {
+		var ok bool
+		if prefix, ok = params[0].(string); !ok {
+			return nil, errors.New("list params must be a prefix")
+		}
+	}
+	keys, err := v.keys(namespace, prefix)
 	if err != nil {
 		return nil, err
 	}
-	secret, err := client.Logical().List(fmt.Sprintf("/%s", namespace))
+	if keys == nil {
+		keys = make([]string, 0)
+	}
+	return map[string]interface{}{"keys": keys}, nil
+}
+
+//Buckets returns the paths of the secrets engines mounted
+func (v *Vault) Buckets() (interface{}, error) {
+	var mounts map[string]*api.MountOutput
+	err := v.do(func(client *api.Client) error {
+		var err error
+		mounts, err = client.Sys().ListMounts()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return secret.Data, nil
+	names := make([]string, 0, len(mounts))
+	for path := range mounts {
+		names = append(names, strings.TrimSuffix(path, "/"))
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
+//Query evaluates a *query.Query on the secrets of a namespace, reading them all
 func (v *Vault) Query(namespace string, params ...interface{}) (interface{}, error) {
-	return nil, nil
+	if len(params) == 0 {
+		return nil, errors.New("query params must be a *query.Query")
+	}
+	q, ok := params[0].(*query.Query)
+	if !ok {
+		return nil, errors.New("query params must be a *query.Query")
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	keys, err := v.keys(namespace, "")
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]map[string]interface{}, 0)
+	for _, key := range keys {
+		if q.Enough(len(matched)) {
+			break
+		}
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		data, err := v.read(namespace, key, nil)
+		if errors.Cause(err) == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(data) {
+			matched = append(matched, data)
+		}
+	}
+	return q.Apply(matched), nil
 }
@@ -0,0 +1,582 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/advancedlogic/box/store/query"
+	"github.com/stretchr/testify/assert"
+)
+
+type secretVersion struct {
+	data      map[string]interface{}
+	created   time.Time
+	deleted   bool
+	destroyed bool
+}
+
+//fake is an in-memory Vault serving a KV version 1 engine at kv/, a KV version 2
+//engine at secret/, the approle, userpass and kubernetes auth methods and tokens
+type fake struct {
+	lock       sync.Mutex
+	tokens     map[string]bool
+	lease      int
+	renewals   int
+	namespaces []string
+	kv         map[string]map[string]interface{}
+	secrets    map[string][]*secretVersion
+	leases     map[string]bool
+	sealed     bool
+	//fixed issues tokens that cannot be renewed
+	fixed bool
+}
+
+func newFake() *fake {
+	return &fake{
+		tokens:  map[string]bool{"root": true},
+		lease:   3600,
+		kv:      make(map[string]map[string]interface{}),
+		secrets: make(map[string][]*secretVersion),
+		leases:  make(map[string]bool),
+	}
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (f *fake) login(w http.ResponseWriter, ok bool) {
+	if !ok {
+		reply(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid credentials"}})
+		return
+	}
+	token := fmt.Sprintf("token-%d", len(f.tokens))
+	f.tokens[token] = true
+	reply(w, http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+		"client_token": token, "renewable": !f.fixed, "lease_duration": f.lease,
+	}})
+}
+
+func (f *fake) list(w http.ResponseWriter, prefix string, keys []string) {
+	matched := make([]string, 0)
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i+1]
+		}
+		if !seen[rest] {
+			seen[rest] = true
+			matched = append(matched, rest)
+		}
+	}
+	if len(matched) == 0 {
+		reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	sort.Strings(matched)
+	reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": matched}})
+}
+
+func (f *fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	var body map[string]interface{}
+	if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+		json.Unmarshal(data, &body)
+	}
+	f.namespaces = append(f.namespaces, r.Header.Get("X-Vault-Namespace"))
+
+	switch {
+	case path == "auth/approle/login":
+		f.login(w, body["role_id"] == "role" && body["secret_id"] == "secret")
+		return
+	case path == "auth/userpass/login/alice":
+		f.login(w, body["password"] == "password")
+		return
+	case path == "auth/k8s/login":
+		f.login(w, body["role"] == "app" && body["jwt"] == "jwt")
+		return
+	}
+	if !f.tokens[r.Header.Get("X-Vault-Token")] {
+		reply(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	list := r.URL.Query().Get("list") == "true"
+
+	switch {
+	case path == "auth/token/renew-self":
+		f.renewals++
+		reply(w, http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": r.Header.Get("X-Vault-Token"), "renewable": true, "lease_duration": f.lease,
+		}})
+	case path == "sys/health":
+		if f.sealed {
+			reply(w, http.StatusServiceUnavailable, map[string]interface{}{"initialized": true, "sealed": true})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"initialized": true, "sealed": false})
+	case path == "sys/mounts":
+		reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"kv/": map[string]interface{}{"type": "kv"}, "secret/": map[string]interface{}{"type": "kv"},
+		}})
+	case path == "database/creds/readonly":
+		id := fmt.Sprintf("database/creds/readonly/%d", len(f.leases))
+		f.leases[id] = true
+		reply(w, http.StatusOK, map[string]interface{}{
+			"lease_id": id, "lease_duration": f.lease, "renewable": true,
+			"data": map[string]interface{}{"username": id, "password": "password"},
+		})
+	case path == "sys/leases/renew":
+		id, _ := body["lease_id"].(string)
+		if !f.leases[id] {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid lease"}})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"lease_id": id, "lease_duration": f.lease, "renewable": true})
+	case strings.HasPrefix(path, "sys/leases/revoke/"):
+		f.leases[strings.TrimPrefix(path, "sys/leases/revoke/")] = false
+		reply(w, http.StatusNoContent, nil)
+	case strings.HasPrefix(path, "kv"):
+		key := strings.TrimPrefix(strings.TrimPrefix(path, "kv"), "/")
+		if list {
+			keys := make([]string, 0)
+			for k := range f.kv {
+				keys = append(keys, k)
+			}
+			f.list(w, key, keys)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+			f.kv[key] = body
+			reply(w, http.StatusNoContent, nil)
+		case http.MethodGet:
+			if data, ok := f.kv[key]; ok {
+				reply(w, http.StatusOK, map[string]interface{}{"data": data})
+				return
+			}
+			reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		case http.MethodDelete:
+			delete(f.kv, key)
+			reply(w, http.StatusNoContent, nil)
+		}
+	case strings.HasPrefix(path, "secret/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "secret/"), "/", 2)
+		action, key := parts[0], ""
+		if len(parts) > 1 {
+			key = parts[1]
+		}
+		f.kv2(w, r, action, key, body, list)
+	default:
+		reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (f *fake) kv2(w http.ResponseWriter, r *http.Request, action, key string, body map[string]interface{}, list bool) {
+	versions := f.secrets[key]
+	selected := func() []*secretVersion {
+		selected := make([]*secretVersion, 0)
+		numbers, _ := body["versions"].([]interface{})
+		for _, number := range numbers {
+			n := int(number.(float64))
+			if n >= 1 && n <= len(versions) {
+				selected = append(selected, versions[n-1])
+			}
+		}
+		return selected
+	}
+	switch {
+	case action == "metadata" && list:
+		keys := make([]string, 0)
+		for k := range f.secrets {
+			keys = append(keys, k)
+		}
+		f.list(w, key, keys)
+	case action == "data" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		data, _ := body["data"].(map[string]interface{})
+		if options, ok := body["options"].(map[string]interface{}); ok {
+			if cas, ok := options["cas"].(float64); ok && int(cas) != len(versions) {
+				reply(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{
+					"check-and-set parameter did not match the current version",
+				}})
+				return
+			}
+		}
+		f.secrets[key] = append(versions, &secretVersion{data: data, created: time.Now().UTC()})
+		reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": len(f.secrets[key])}})
+	case action == "data" && r.Method == http.MethodGet:
+		if len(versions) == 0 {
+			reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		n := len(versions)
+		if requested := r.URL.Query().Get("version"); requested != "" {
+			n, _ = strconv.Atoi(requested)
+		}
+		if n < 1 || n > len(versions) {
+			reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		v := versions[n-1]
+		if v.deleted || v.destroyed {
+			//like Vault, the metadata of a deleted version is returned with a 404
+			reply(w, http.StatusNotFound, map[string]interface{}{"data": map[string]interface{}{
+				"data": nil, "metadata": map[string]interface{}{"version": n, "destroyed": v.destroyed},
+			}})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data": v.data, "metadata": map[string]interface{}{"version": n},
+		}})
+	case action == "data" && r.Method == http.MethodDelete:
+		if len(versions) > 0 {
+			versions[len(versions)-1].deleted = true
+		}
+		reply(w, http.StatusNoContent, nil)
+	case action == "delete" || action == "undelete" || action == "destroy":
+		for _, v := range selected() {
+			switch action {
+			case "delete":
+				v.deleted = true
+			case "undelete":
+				v.deleted = false
+			case "destroy":
+				v.destroyed, v.data = true, nil
+			}
+		}
+		reply(w, http.StatusNoContent, nil)
+	case action == "metadata" && r.Method == http.MethodGet:
+		if len(versions) == 0 {
+			reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		described := map[string]interface{}{}
+		for n, v := range versions {
+			deleted := ""
+			if v.deleted {
+				deleted = v.created.Format(time.RFC3339Nano)
+			}
+			described[strconv.Itoa(n+1)] = map[string]interface{}{
+				"created_time": v.created.Format(time.RFC3339Nano), "deletion_time": deleted, "destroyed": v.destroyed,
+			}
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"current_version": len(versions), "oldest_version": 1, "max_versions": 0,
+			"created_time": versions[0].created.Format(time.RFC3339Nano),
+			"updated_time": versions[len(versions)-1].created.Format(time.RFC3339Nano),
+			"versions":     described,
+		}})
+	case action == "metadata" && r.Method == http.MethodDelete:
+		delete(f.secrets, key)
+		reply(w, http.StatusNoContent, nil)
+	default:
+		reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func newServer(t *testing.T, f *fake) *httptest.Server {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newVault(t *testing.T, f *fake) *Vault {
+	server := newServer(t, f)
+	v, err := New(WithServers(server.URL), WithToken("root"), WithKV2("secret"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { v.Close() })
+	return v
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.Error(t, err)
+	_, err = New(WithServers(""))
+	assert.Error(t, err)
+	_, err = New(WithServers("http://localhost:8200"), WithAuthMount("approle"))
+	assert.Error(t, err)
+	_, err = New(WithServers("http://localhost:8200"), WithUserpass("alice", ""))
+	assert.Error(t, err)
+	_, err = New(WithServers("http://localhost:8200"), WithTimeout(0))
+	assert.Error(t, err)
+	v, err := New(WithServers("http://localhost:8200"))
+	assert.NoError(t, err)
+	assert.False(t, v.skipTLSVerification)
+}
+
+func TestVault_Check(t *testing.T) {
+	f := newFake()
+	v := newVault(t, f)
+	assert.NoError(t, v.Check(context.Background()))
+	f.lock.Lock()
+	f.sealed = true
+	f.lock.Unlock()
+	assert.Error(t, v.Check(nil))
+
+	//a sealed server fails over to the next one
+	healthy := newServer(t, newFake())
+	v, err := New(WithServers(newServer(t, f).URL, healthy.URL), WithToken("root"))
+	assert.NoError(t, err)
+	defer v.Close()
+	assert.NoError(t, v.Check(context.Background()))
+}
+
+func TestVault_KV1(t *testing.T) {
+	f := newFake()
+	v := newVault(t, f)
+	assert.NoError(t, v.Create("kv", "db", map[string]interface{}{"password": "secret"}))
+	data, err := v.Read("kv", "db")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", data.(map[string]interface{})["password"])
+	assert.Error(t, v.Create("kv", "db", "secret"))
+
+	keys, err := v.List("kv")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db"}, keys.(map[string]interface{})["keys"])
+	assert.NoError(t, v.Delete("kv", "db"))
+	_, err = v.Read("kv", "db")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	keys, err = v.List("kv")
+	assert.NoError(t, err)
+	assert.Empty(t, keys.(map[string]interface{})["keys"])
+
+	buckets, err := v.Buckets()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kv", "secret"}, buckets)
+	_, err = v.Metadata("kv", "db")
+	assert.Error(t, err)
+}
+
+func TestVault_KV2(t *testing.T) {
+	f := newFake()
+	v := newVault(t, f)
+	assert.NoError(t, v.Create("secret", "app/db", map[string]interface{}{"password": "one"}))
+	assert.NoError(t, v.Update("secret", "app/db", map[string]interface{}{"password": "two"}))
+	data, err := v.Read("secret", "app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", data.(map[string]interface{})["password"])
+	data, err = v.ReadVersion("secret", "app/db", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", data.(map[string]interface{})["password"])
+
+	keys, err := v.List("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app/"}, keys.(map[string]interface{})["keys"])
+	keys, err = v.List("secret", "app/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db"}, keys.(map[string]interface{})["keys"])
+
+	//soft delete and undelete
+	assert.NoError(t, v.Delete("secret", "app/db"))
+	_, err = v.Read("secret", "app/db")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	metadata, err := v.Metadata("secret", "app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, metadata.CurrentVersion)
+	assert.Len(t, metadata.Versions, 2)
+	assert.False(t, metadata.Versions[2].Deleted.IsZero())
+	assert.NoError(t, v.Undelete("secret", "app/db", 2))
+	data, err = v.Read("secret", "app/db")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", data.(map[string]interface{})["password"])
+
+	assert.NoError(t, v.Destroy("secret", "app/db", 1))
+	_, err = v.ReadVersion("secret", "app/db", 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	metadata, _ = v.Metadata("secret", "app/db")
+	assert.True(t, metadata.Versions[1].Destroyed)
+	assert.Error(t, v.Undelete("secret", "app/db"))
+
+	assert.NoError(t, v.Purge("secret", "app/db"))
+	_, err = v.Metadata("secret", "app/db")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestVault_Versioned(t *testing.T) {
+	v := newVault(t, newFake())
+	var _ interfaces.Historian = v
+	revision, err := v.Insert("secret", "db", map[string]interface{}{"password": "one"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", revision)
+	_, err = v.Insert("secret", "db", map[string]interface{}{"password": "two"})
+	assert.True(t, errors.Is(err, store.ErrExists))
+
+	updated, err := v.UpdateIf("secret", "db", map[string]interface{}{"password": "two"}, revision)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", updated)
+	_, err = v.UpdateIf("secret", "db", map[string]interface{}{"password": "three"}, revision)
+	assert.True(t, errors.Is(err, store.ErrConflict))
+	data, current, err := v.ReadRevision("secret", "db")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", data.(map[string]interface{})["password"])
+	assert.Equal(t, "2", current)
+
+	assert.True(t, errors.Is(v.DeleteIf("secret", "db", revision), store.ErrConflict))
+	assert.NoError(t, v.DeleteIf("secret", "db", current))
+	_, _, err = v.ReadRevision("secret", "db")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	//deleted secrets can be inserted again
+	revision, err = v.Insert("secret", "db", map[string]interface{}{"password": "four"})
+	assert.NoError(t, err)
+	assert.Equal(t, "3", revision)
+
+	history, err := v.History("secret", "db")
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, "3", history[0].Revision)
+	assert.True(t, history[1].Deleted)
+	assert.False(t, history[2].Deleted)
+	data, err = v.ReadAt("secret", "db", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", data.(map[string]interface{})["password"])
+
+	_, err = v.Insert("kv", "db", map[string]interface{}{"password": "one"})
+	assert.True(t, errors.Is(err, store.ErrNotSupported))
+}
+
+func TestVault_Query(t *testing.T) {
+	f := newFake()
+	v := newVault(t, f)
+	assert.NoError(t, v.Create("secret", "alice", map[string]interface{}{"name": "alice", "age": 30}))
+	assert.NoError(t, v.Create("secret", "bob", map[string]interface{}{"name": "bob", "age": 20}))
+	assert.NoError(t, v.Create("secret", "carol", map[string]interface{}{"name": "carol", "age": 40}))
+	assert.NoError(t, v.Delete("secret", "carol"))
+	result, err := v.Query("secret", &query.Query{Where: query.Gt("age", 10), Sort: []query.Sort{{Field: "name"}}})
+	assert.NoError(t, err)
+	matched := result.([]map[string]interface{})
+	assert.Len(t, matched, 2)
+	assert.Equal(t, "alice", matched[0]["name"])
+}
+
+func TestVault_Failover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	f := newFake()
+	up := newServer(t, f)
+	v, err := New(WithServers(down.URL, up.URL), WithToken("root"), WithNamespace("team"))
+	assert.NoError(t, err)
+	defer v.Close()
+	assert.NoError(t, v.Create("kv", "db", map[string]interface{}{"password": "secret"}))
+	_, err = v.Read("kv", "db")
+	assert.NoError(t, err)
+
+	client, _ := v.connect()
+	assert.Equal(t, up.URL, client.Address())
+	assert.Contains(t, f.namespaces, "team")
+
+	//errors of the request are not failed over
+	v, _ = New(WithServers(up.URL, down.URL), WithToken("invalid"))
+	defer v.Close()
+	_, err = v.Read("kv", "db")
+	assert.Error(t, err)
+	client, _ = v.connect()
+	assert.Equal(t, up.URL, client.Address())
+}
+
+func TestVault_Auth(t *testing.T) {
+	f := newFake()
+	server := newServer(t, f)
+	jwt := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(jwt, []byte("jwt\n"), 0600))
+
+	for name, auth := range map[string][]store.Option{
+		"approle":    {WithAppRole("role", "secret")},
+		"userpass":   {WithUserpass("alice", "password")},
+		"kubernetes": {WithKubernetes("app", jwt), WithAuthMount("k8s")},
+	} {
+		options := []store.Option{WithServers(server.URL), WithKV2()}
+		options = append(options, auth...)
+		v, err := New(options...)
+		assert.NoError(t, err, name)
+		assert.NoError(t, v.Create("secret", name, map[string]interface{}{"auth": name}), name)
+		v.Close()
+	}
+
+	v, _ := New(WithServers(server.URL), WithUserpass("alice", "wrong"))
+	_, err := v.Read("kv", "db")
+	assert.Error(t, err)
+}
+
+func TestVault_Renewal(t *testing.T) {
+	f := newFake()
+	f.lease = 2
+	server := newServer(t, f)
+	v, err := New(WithServers(server.URL), WithAppRole("role", "secret"))
+	assert.NoError(t, err)
+	defer v.Close()
+	_, err = v.List("kv")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return f.renewals > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestVault_Relogin(t *testing.T) {
+	f := newFake()
+	f.lease = 1
+	f.fixed = true
+	server := newServer(t, f)
+	v, err := New(WithServers(server.URL), WithAppRole("role", "secret"))
+	assert.NoError(t, err)
+	defer v.Close()
+	_, err = v.List("kv")
+	assert.NoError(t, err)
+	//root and the tokens of the first login and of the one before it expires
+	assert.Eventually(t, func() bool {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return len(f.tokens) > 2
+	}, 3*time.Second, 50*time.Millisecond)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	assert.Equal(t, 0, f.renewals)
+}
+
+func TestVault_Lease(t *testing.T) {
+	f := newFake()
+	v := newVault(t, f)
+	lease, err := v.Lease("database/creds/readonly", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "database/creds/readonly", lease.Path)
+	assert.Equal(t, time.Hour, lease.Duration)
+	assert.True(t, lease.Renewable)
+	assert.Equal(t, lease.ID, lease.Data["username"])
+
+	duration, err := v.Renew(lease.ID, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, duration)
+	assert.NoError(t, v.Revoke(lease.ID))
+	assert.False(t, f.leases[lease.ID])
+	_, err = v.Renew(lease.ID, 0)
+	assert.Error(t, err)
+
+	_, err = v.Lease("database/creds/missing", nil)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = v.Lease("", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package vault
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/store"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+//The revisions of the secrets are their versions, kept by KV version 2 engines
+//only: the writes use check-and-set, the KV version 1 engines fail with
+//store.ErrNotSupported.
+
+//parse returns the version of a revision
+func parse(namespace string, key string, revision string) (int, error) {
+	version, err := strconv.Atoi(revision)
+	if err != nil || version <= 0 {
+		return 0, errors.Wrapf(store.ErrConflict, "%s/%s: invalid revision %q", namespace, key, revision)
+	}
+	return version, nil
+}
+
+//cas writes the data of a secret if its current version is version, 0 if
+//missing, failing with store.ErrConflict otherwise, and returns the new version
+func (v *Vault) cas(namespace string, key string, value interface{}, version int) (string, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return "", errors.New("value must be a map[string]interface{}")
+	}
+	var secret *api.Secret
+	err := v.do(func(client *api.Client) error {
+		var err error
+		secret, err = client.Logical().Write(v.path(namespace, "data", key), map[string]interface{}{
+			"options": map[string]interface{}{"cas": version},
+			"data":    data,
+		})
+		return err
+	})
+	if response, ok := errors.Cause(err).(*api.ResponseError); ok && response.StatusCode == 400 &&
+		strings.Contains(strings.Join(response.Errors, " "), "check-and-set") {
+		return "", errors.Wrapf(store.ErrConflict, "%s/%s", namespace, key)
+	}
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", errors.Errorf("%s/%s: no version returned", namespace, key)
+	}
+	return strconv.Itoa(number(secret.Data["version"])), nil
+}
+
+//current returns the current version of a secret, 0 if missing, and if it is deleted
+func (v *Vault) current(namespace string, key string) (int, bool, error) {
+	metadata, err := v.Metadata(namespace, key)
+	if errors.Cause(err) == ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	version, ok := metadata.Versions[metadata.CurrentVersion]
+	deleted := !ok || version.Destroyed || !version.Deleted.IsZero()
+	return metadata.CurrentVersion, deleted, nil
+}
+
+//Insert writes a secret failing with store.ErrExists if it exists, and returns its
+//version. A secret whose current version is deleted can be written again.
+func (v *Vault) Insert(namespace string, key string, value interface{}) (string, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return "", err
+	}
+	version, deleted, err := v.current(namespace, key)
+	if err != nil {
+		return "", err
+	}
+	if version > 0 && !deleted {
+		return "", errors.Wrapf(store.ErrExists, "%s/%s", namespace, key)
+	}
+	inserted, err := v.cas(namespace, key, value, version)
+	if errors.Cause(err) == store.ErrConflict {
+		//written meanwhile
+		return "", errors.Wrapf(store.ErrExists, "%s/%s", namespace, key)
+	}
+	return inserted, err
+}
+
+//ReadRevision returns the data of the latest version of a secret and its version
+func (v *Vault) ReadRevision(namespace string, key string) (interface{}, string, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return nil, "", err
+	}
+	data, version, err := v.readVersion(namespace, key, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, strconv.Itoa(version), nil
+}
+
+//UpdateIf writes a secret if its current version is revision, failing with
+//store.ErrConflict otherwise, and returns the new version
+func (v *Vault) UpdateIf(namespace string, key string, value interface{}, revision string) (string, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return "", err
+	}
+	version, err := parse(namespace, key, revision)
+	if err != nil {
+		return "", err
+	}
+	return v.cas(namespace, key, value, version)
+}
+
+//DeleteIf soft deletes the version revision of a secret if it is the current
+//one, failing with store.ErrConflict otherwise. A version written meanwhile is
+//kept, only the version matched is deleted.
+func (v *Vault) DeleteIf(namespace string, key string, revision string) error {
+	if err := v.kv2Only(namespace); err != nil {
+		return err
+	}
+	version, err := parse(namespace, key, revision)
+	if err != nil {
+		return err
+	}
+	current, deleted, err := v.current(namespace, key)
+	if err != nil {
+		return err
+	}
+	if current != version || deleted {
+		return errors.Wrapf(store.ErrConflict, "%s/%s", namespace, key)
+	}
+	return v.DeleteVersions(namespace, key, version)
+}
+
+//History returns the versions of a secret, the latest first
+func (v *Vault) History(namespace string, key string) ([]interfaces.Revision, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return nil, err
+	}
+	metadata, err := v.Metadata(namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, 0, len(metadata.Versions))
+	for n := range metadata.Versions {
+		numbers = append(numbers, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	revisions := make([]interfaces.Revision, 0, len(numbers))
+	for _, n := range numbers {
+		version := metadata.Versions[n]
+		revisions = append(revisions, interfaces.Revision{
+			Revision: strconv.Itoa(n),
+			Modified: version.Created,
+			Deleted:  version.Destroyed || !version.Deleted.IsZero(),
+		})
+	}
+	return revisions, nil
+}
+
+//ReadAt returns the data of a version of a secret
+func (v *Vault) ReadAt(namespace string, key string, revision string) (interface{}, error) {
+	if err := v.kv2Only(namespace); err != nil {
+		return nil, err
+	}
+	version, err := parse(namespace, key, revision)
+	if err != nil {
+		return nil, err
+	}
+	return v.ReadVersion(namespace, key, version)
+}
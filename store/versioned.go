@@ -0,0 +1,50 @@
+package store
+
+import (
+	"errors"
+	"os"
+
+	"github.com/advancedlogic/box/interfaces"
+)
+
+//ErrNotFound is returned reading a missing key, by the stores not returning os.ErrNotExist
+var ErrNotFound = errors.New("not found")
+
+//ErrExists is returned inserting a key that exists
+var ErrExists = errors.New("already exists")
+
+//ErrConflict is returned writing an item whose revision does not match,
+//changed or deleted since it was read
+var ErrConflict = errors.New("revision conflict")
+
+//ErrNotSupported is returned when a store cannot keep revisions or history
+var ErrNotSupported = errors.New("not supported by the store")
+
+//Versioning returns the Versioned view of a store, ErrNotSupported if it has none
+func Versioning(s interfaces.Store) (interfaces.Versioned, error) {
+	if v, ok := s.(interfaces.Versioned); ok {
+		return v, nil
+	}
+	return nil, ErrNotSupported
+}
+
+//History returns the Historian view of a store, ErrNotSupported if it has none
+func History(s interfaces.Store) (interfaces.Historian, error) {
+	if h, ok := s.(interfaces.Historian); ok {
+		return h, nil
+	}
+	return nil, ErrNotSupported
+}
+
+//Scanning returns ErrNotSupported if the List of a store does not pass the values to a callback
+func Scanning(s interfaces.Store) error {
+	if scanner, ok := s.(interfaces.Scanner); ok && scanner.Scans() {
+		return nil
+	}
+	return ErrNotSupported
+}
+
+//NotFound reports whether err is the error of a store reading a missing key
+func NotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist)
+}
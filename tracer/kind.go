@@ -0,0 +1,10 @@
+package tracer
+
+//Span kinds accepted by interfaces.Tracer.Start
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+	KindProducer = "producer"
+	KindConsumer = "consumer"
+)
@@ -0,0 +1,5 @@
+package tracer
+
+import "github.com/advancedlogic/box/interfaces"
+
+type Option func(interfaces.Tracer) error
@@ -0,0 +1,55 @@
+package otel
+
+import (
+	"sync"
+)
+
+//MemoryExporter keeps the ended spans in memory, useful for tests
+type MemoryExporter struct {
+	lock  sync.RWMutex
+	spans []*SpanData
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{
+		spans: make([]*SpanData, 0),
+	}
+}
+
+func (m *MemoryExporter) Export(spans []*SpanData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+//Spans returns the exported spans in order of completion
+func (m *MemoryExporter) Spans() []*SpanData {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	spans := make([]*SpanData, len(m.spans))
+	copy(spans, m.spans)
+	return spans
+}
+
+//Reset forgets the exported spans
+func (m *MemoryExporter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = make([]*SpanData, 0)
+}
+
+func (m *MemoryExporter) Close() error {
+	return nil
+}
+
+//NoopExporter discards the ended spans, the default exporter
+type NoopExporter struct{}
+
+func (NoopExporter) Export([]*SpanData) error {
+	return nil
+}
+
+func (NoopExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
+)
+
+const (
+	errorServiceEmpty = "service name cannot be empty"
+	errorExporterNil  = "exporter cannot be nil"
+	errorRatio        = "sample ratio must be between 0 and 1"
+	errorLoggerNil    = "logger cannot be nil"
+)
+
+type spanKey struct{}
+
+//Exporter receives the ended spans
+type Exporter interface {
+	Export([]*SpanData) error
+	Close() error
+}
+
+//WithServiceName sets the service name attached to every span
+func WithServiceName(service string) tracer.Option {
+	return func(i interfaces.Tracer) error {
+		if service != "" {
+			o := i.(*Otel)
+			o.service = service
+			return nil
+		}
+		return errors.New(errorServiceEmpty)
+	}
+}
+
+//WithExporter sets where the ended spans are sent. Default a NoopExporter discarding them.
+func WithExporter(exporter Exporter) tracer.Option {
+	return func(i interfaces.Tracer) error {
+		if exporter != nil {
+			o := i.(*Otel)
+			o.exporter = exporter
+			return nil
+		}
+		return errors.New(errorExporterNil)
+	}
+}
+
+//WithSampleRatio sets the ratio of new traces that are sampled. Default 1.
+//Traces started elsewhere keep the decision of the caller.
+func WithSampleRatio(ratio float64) tracer.Option {
+	return func(i interfaces.Tracer) error {
+		if ratio >= 0 && ratio <= 1 {
+			o := i.(*Otel)
+			o.ratio = ratio
+			return nil
+		}
+		return errors.New(errorRatio)
+	}
+}
+
+//WithLogger sets where the errors of the exporter are logged
+func WithLogger(logger interfaces.Logger) tracer.Option {
+	return func(i interfaces.Tracer) error {
+		if logger != nil {
+			o := i.(*Otel)
+			o.Logger = logger
+			return nil
+		}
+		return errors.New(errorLoggerNil)
+	}
+}
+
+//Otel is a tracer following the OpenTelemetry model
+//and propagating the context with W3C trace context headers
+type Otel struct {
+	interfaces.Logger
+
+	service  string
+	ratio    float64
+	exporter Exporter
+
+	lock   sync.Mutex
+	random *rand.Rand
+}
+
+func New(options ...tracer.Option) (*Otel, error) {
+	o := &Otel{
+		service: "default",
+		ratio:   1,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, option := range options {
+		if err := option(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.exporter == nil {
+		o.exporter = NoopExporter{}
+	}
+	if exporter, ok := o.exporter.(interface{ SetLogger(interfaces.Logger) }); ok && o.Logger != nil {
+		exporter.SetLogger(o.Logger)
+	}
+	return o, nil
+}
+
+func (o *Otel) Instance() interface{} {
+	return o.exporter
+}
+
+func (o *Otel) sample() bool {
+	if o.ratio >= 1 {
+		return true
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.random.Float64() < o.ratio
+}
+
+//SpanContextFromContext returns the span context carried by ctx, local or remote
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	switch v := ctx.Value(spanKey{}).(type) {
+	case *Span:
+		return v.context, true
+	case SpanContext:
+		return v, true
+	}
+	return SpanContext{}, false
+}
+
+//Start creates a new span, child of the span in ctx if any
+func (o *Otel) Start(ctx context.Context, name, kind string) (context.Context, interfaces.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if kind == "" {
+		kind = tracer.KindInternal
+	}
+	sc := SpanContext{SpanID: randomID(8)}
+	parentSpanID := ""
+	if parent, ok := SpanContextFromContext(ctx); ok && parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+		parentSpanID = parent.SpanID
+	} else {
+		sc.TraceID = randomID(16)
+		sc.Sampled = o.sample()
+	}
+	span := &Span{
+		tracer:  o,
+		context: sc,
+		data: SpanData{
+			Service:      o.service,
+			Name:         name,
+			Kind:         kind,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parentSpanID,
+			Start:        time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+//Inject writes the traceparent and tracestate of the span in ctx into the carrier
+func (o *Otel) Inject(ctx context.Context, carrier map[string]string) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok || !sc.IsValid() {
+		return
+	}
+	carrier[traceparentHeader] = sc.Traceparent()
+	if sc.TraceState != "" {
+		carrier[tracestateHeader] = sc.TraceState
+	}
+}
+
+//Extract returns a context carrying the remote span found in the carrier.
+//Keys are matched case insensitively.
+func (o *Otel) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var traceparent, tracestate string
+	for key, value := range carrier {
+		switch strings.ToLower(key) {
+		case traceparentHeader:
+			traceparent = value
+		case tracestateHeader:
+			tracestate = value
+		}
+	}
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	sc.TraceState = tracestate
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+func (o *Otel) export(data *SpanData) {
+	if err := o.exporter.Export([]*SpanData{data}); err != nil && o.Logger != nil {
+		o.Logger.Error(err.Error())
+	}
+}
+
+//Close flushes and closes the exporter
+func (o *Otel) Close() error {
+	return o.exporter.Close()
+}
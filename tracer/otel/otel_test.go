@@ -0,0 +1,168 @@
+package otel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceparent(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestOtel_Propagation(t *testing.T) {
+	exporter := NewMemoryExporter()
+	o, err := New(WithServiceName("test"), WithExporter(exporter))
+	assert.Nil(t, err)
+
+	ctx, client := o.Start(context.Background(), "client", tracer.KindClient)
+	carrier := make(map[string]string)
+	o.Inject(ctx, carrier)
+	assert.Contains(t, carrier, "traceparent")
+
+	ctx = o.Extract(context.Background(), map[string]string{"Traceparent": carrier["traceparent"]})
+	_, server := o.Start(ctx, "server", tracer.KindServer)
+	server.SetError(errors.New("boom"))
+	server.End()
+	client.End()
+	client.End()
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, client.TraceID(), spans[0].TraceID)
+	assert.Equal(t, client.SpanID(), spans[0].ParentSpanID)
+	assert.Equal(t, "boom", spans[0].Error)
+	assert.Equal(t, "test", spans[1].Service)
+	assert.Empty(t, spans[1].ParentSpanID)
+}
+
+func TestOtel_NotSampled(t *testing.T) {
+	exporter := NewMemoryExporter()
+	o, err := New(WithExporter(exporter), WithSampleRatio(0))
+	assert.Nil(t, err)
+	_, span := o.Start(context.Background(), "span", "")
+	span.End()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var request map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &request))
+		requests <- request
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(server.URL, map[string]string{"Authorization": "secret"})
+	assert.Nil(t, err)
+	o, err := New(WithServiceName("test"), WithExporter(exporter))
+	assert.Nil(t, err)
+	_, span := o.Start(context.Background(), "span", tracer.KindServer)
+	span.SetAttribute("http.status_code", 200)
+	span.End()
+	assert.Nil(t, o.Close())
+
+	request := <-requests
+	resourceSpans := request["resourceSpans"].([]interface{})
+	assert.Len(t, resourceSpans, 1)
+	scopeSpans := resourceSpans[0].(map[string]interface{})["scopeSpans"].([]interface{})
+	spans := scopeSpans[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Equal(t, span.SpanID(), spans[0].(map[string]interface{})["spanId"])
+	assert.Equal(t, float64(2), spans[0].(map[string]interface{})["kind"])
+}
+
+func TestOTLPExporter_FullBatch(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan int, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		received <- 1
+	}))
+	defer server.Close()
+	defer close(release)
+
+	exporter, err := NewOTLPExporter(server.URL, nil)
+	assert.Nil(t, err)
+	defer exporter.Close()
+	exporter.batchSize = 2
+	spans := []*SpanData{{Name: "one"}, {Name: "two"}}
+	done := make(chan struct{})
+	go func() {
+		//a full batch does not wait for the collector
+		assert.Nil(t, exporter.Export(spans))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("export waited for the collector")
+	}
+	release <- struct{}{}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("full batch not sent")
+	}
+}
+
+type recorder struct {
+	interfaces.Logger
+	errors chan string
+}
+
+func (e *recorder) Error(message string) {
+	e.errors <- message
+}
+
+func TestOTLPExporter_Dropped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter, err := NewOTLPExporter(server.URL, nil)
+	assert.Nil(t, err)
+	logger := &recorder{errors: make(chan string, 1)}
+	_, err = New(WithExporter(exporter), WithLogger(logger))
+	assert.Nil(t, err)
+	exporter.batchSize = 2
+	assert.Nil(t, exporter.Export([]*SpanData{{Name: "one"}, {Name: "two"}}))
+	select {
+	case message := <-logger.errors:
+		assert.Contains(t, message, "status 503")
+	case <-time.After(time.Second):
+		t.Fatal("failed flush not logged")
+	}
+	assert.Equal(t, uint64(2), exporter.Dropped())
+	assert.Nil(t, exporter.Close())
+}
@@ -0,0 +1,288 @@
+package otel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
+)
+
+const (
+	errorEndpointEmpty = "endpoint cannot be empty"
+	otlpTracesPath     = "/v1/traces"
+)
+
+var otlpKinds = map[string]int{
+	tracer.KindInternal: 1,
+	tracer.KindServer:   2,
+	tracer.KindClient:   3,
+	tracer.KindProducer: 4,
+	tracer.KindConsumer: 5,
+}
+
+//OTLPExporter sends the spans to an OpenTelemetry collector
+//using the OTLP/HTTP protocol with JSON encoding.
+//Spans are batched and sent when the batch is full or every interval.
+//The spans of a batch the collector refuses are dropped and counted.
+type OTLPExporter struct {
+	logger    interfaces.Logger
+	dropped   uint64
+	endpoint  string
+	headers   map[string]string
+	batchSize int
+	interval  time.Duration
+	client    *http.Client
+
+	lock    sync.Mutex
+	batch   []*SpanData
+	full    chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+	closed  bool
+}
+
+//NewOTLPExporter creates an exporter sending the spans to the given collector
+//(e.g. http://localhost:4318). Headers are added to every request.
+func NewOTLPExporter(endpoint string, headers map[string]string) (*OTLPExporter, error) {
+	if endpoint == "" {
+		return nil, errors.New(errorEndpointEmpty)
+	}
+	if !strings.HasSuffix(endpoint, otlpTracesPath) {
+		endpoint = strings.TrimSuffix(endpoint, "/") + otlpTracesPath
+	}
+	e := &OTLPExporter{
+		endpoint:  endpoint,
+		headers:   headers,
+		batchSize: 512,
+		interval:  5 * time.Second,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		batch:     make([]*SpanData, 0),
+		full:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	e.stopped.Add(1)
+	go e.loop()
+	return e, nil
+}
+
+func (e *OTLPExporter) loop() {
+	defer e.stopped.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			e.flush()
+		case <-e.full:
+			e.flush()
+		}
+	}
+}
+
+func (e *OTLPExporter) flush() {
+	if err := e.Flush(); err != nil {
+		e.lock.Lock()
+		logger := e.logger
+		e.lock.Unlock()
+		if logger != nil {
+			logger.Error(fmt.Sprintf("otlp: %s, %d spans dropped so far", err.Error(), e.Dropped()))
+		}
+	}
+}
+
+//SetLogger sets where the errors of the background flushes are logged.
+//The Otel tracer sets its own logger on the exporter.
+func (e *OTLPExporter) SetLogger(logger interfaces.Logger) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.logger = logger
+}
+
+//Dropped returns the number of spans the collector did not receive
+func (e *OTLPExporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+//Export queues the spans, the background loop sends them if the batch is full.
+//It never waits for the collector, spans are ended on the request path.
+func (e *OTLPExporter) Export(spans []*SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return errors.New("exporter is closed")
+	}
+	e.batch = append(e.batch, spans...)
+	if len(e.batch) >= e.batchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+			//already signaled
+		}
+	}
+	return nil
+}
+
+//Flush sends the queued spans
+func (e *OTLPExporter) Flush() error {
+	e.lock.Lock()
+	batch := e.batch
+	e.batch = make([]*SpanData, 0)
+	e.lock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := e.send(batch); err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(batch)))
+		return err
+	}
+	return nil
+}
+
+func (e *OTLPExporter) send(batch []*SpanData) error {
+	body, err := json.Marshal(encode(batch))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("collector replied with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+//Close stops the exporter sending the remaining spans
+func (e *OTLPExporter) Close() error {
+	e.lock.Lock()
+	if e.closed {
+		e.lock.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.lock.Unlock()
+	close(e.done)
+	e.stopped.Wait()
+	return e.Flush()
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope map[string]string `json:"scope"`
+	Spans []otlpSpan        `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   map[string][]otlpAttribute `json:"resource"`
+	ScopeSpans []otlpScopeSpans           `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func value(v interface{}) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{"stringValue": x}
+	case bool:
+		return otlpValue{"boolValue": x}
+	case int:
+		return otlpValue{"intValue": strconv.FormatInt(int64(x), 10)}
+	case int32:
+		return otlpValue{"intValue": strconv.FormatInt(int64(x), 10)}
+	case int64:
+		return otlpValue{"intValue": strconv.FormatInt(x, 10)}
+	case float32:
+		return otlpValue{"doubleValue": float64(x)}
+	case float64:
+		return otlpValue{"doubleValue": x}
+	default:
+		return otlpValue{"stringValue": fmt.Sprint(x)}
+	}
+}
+
+//encode groups the spans by service in the OTLP JSON format
+func encode(spans []*SpanData) *otlpRequest {
+	services := make(map[string][]otlpSpan)
+	order := make([]string, 0)
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              otlpKinds[span.Kind],
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		for key, v := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: value(v)})
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		if _, ok := services[span.Service]; !ok {
+			order = append(order, span.Service)
+		}
+		services[span.Service] = append(services[span.Service], s)
+	}
+
+	request := &otlpRequest{}
+	for _, service := range order {
+		request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+			Resource: map[string][]otlpAttribute{
+				"attributes": {{Key: "service.name", Value: value(service)}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: map[string]string{"name": "github.com/advancedlogic/box"},
+				Spans: services[service],
+			}},
+		})
+	}
+	return request
+}
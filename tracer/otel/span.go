@@ -0,0 +1,160 @@
+package otel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+	traceparentFormat = "00-%s-%s-%02x"
+	flagSampled       = 0x01
+)
+
+//SpanContext identifies a span across process boundaries (W3C trace context)
+type SpanContext struct {
+	TraceID    string
+	SpanID     string
+	Sampled    bool
+	TraceState string
+	Remote     bool
+}
+
+//IsValid returns true if trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return isHex(sc.TraceID, 32) && isHex(sc.SpanID, 16)
+}
+
+//Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf(traceparentFormat, sc.TraceID, sc.SpanID, flags)
+}
+
+//ParseTraceparent parses a W3C traceparent header
+func ParseTraceparent(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	if !isHex(parts[3], 2) {
+		return SpanContext{}, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	sc := SpanContext{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		Sampled: flags[0]&flagSampled == flagSampled,
+		Remote:  true,
+	}
+	if !sc.IsValid() || strings.Trim(sc.TraceID, "0") == "" || strings.Trim(sc.SpanID, "0") == "" {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//SpanData is the immutable snapshot of an ended span handed to the exporters
+type SpanData struct {
+	Service      string
+	Name         string
+	Kind         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+//Span implements interfaces.Span
+type Span struct {
+	tracer *Otel
+
+	lock    sync.Mutex
+	context SpanContext
+	data    SpanData
+	ended   bool
+}
+
+//TraceID returns the hex trace id
+func (s *Span) TraceID() string {
+	return s.context.TraceID
+}
+
+//SpanID returns the hex span id
+func (s *Span) SpanID() string {
+	return s.context.SpanID
+}
+
+//Context returns the span context
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+//SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+//SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+//End completes the span and hands it to the exporter if sampled
+func (s *Span) End() {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.lock.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(&data)
+	}
+}
@@ -1,14 +1,17 @@
 package rest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/advancedlogic/box/interfaces"
+	"github.com/advancedlogic/box/tracer"
 	"github.com/advancedlogic/box/transport"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -19,16 +22,24 @@ import (
 
 type Rest struct {
 	interfaces.Logger
-	port           int
-	healthEndpoint string
-	readTimeout    time.Duration
-	writeTimeout   time.Duration
-	cert           string
-	key            string
-	server         *http.Server
-	www            string
-	router         *gin.Engine
-	cors           bool
+	port              int
+	healthEndpoint    string
+	livenessEndpoint  string
+	readinessEndpoint string
+	logLevelEndpoint  string
+	logLevelAuth      []gin.HandlerFunc
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	cert              string
+	key               string
+	server            *http.Server
+	www               string
+	router            *gin.Engine
+	cors              bool
+	tracer            interfaces.Tracer
+	health            interfaces.Health
+	metrics           interfaces.Metrics
+	ginMetrics        bool
 }
 
 func WithLogger(logger interfaces.Logger) transport.Option {
@@ -42,6 +53,43 @@ func WithLogger(logger interfaces.Logger) transport.Option {
 	}
 }
 
+//WithTracer starts a server span for every request, continuing the trace
+//received with the W3C traceparent header. The span is available to the
+//handlers through the request context.
+func WithTracer(tracer interfaces.Tracer) transport.Option {
+	return func(t interfaces.Transport) error {
+		if tracer != nil {
+			rest := t.(*Rest)
+			rest.tracer = tracer
+			return nil
+		}
+		return errors.New("tracer cannot be nil")
+	}
+}
+
+//WithMetrics records the requests in the given metrics,
+//replacing the default gin prometheus middleware.
+//If the metrics implement http.Handler they are exposed on their Path().
+func WithMetrics(metrics interfaces.Metrics) transport.Option {
+	return func(t interfaces.Transport) error {
+		if metrics != nil {
+			rest := t.(*Rest)
+			rest.metrics = metrics
+			return nil
+		}
+		return errors.New("metrics cannot be nil")
+	}
+}
+
+//DisableMetrics removes the default gin prometheus middleware
+func DisableMetrics() transport.Option {
+	return func(t interfaces.Transport) error {
+		rest := t.(*Rest)
+		rest.ginMetrics = false
+		return nil
+	}
+}
+
 func EnableCORS() transport.Option {
 	return func(t interfaces.Transport) error {
 		rest := t.(*Rest)
@@ -72,6 +120,63 @@ func WithHealthCheckEndpoint(healthEndpoint string) transport.Option {
 	}
 }
 
+//WithLogLevelEndpoint exposes an admin endpoint to inspect and change the log levels at runtime.
+//It is available only if the logger implements interfaces.LevelController.
+//The middlewares, like an authentication, run before its handlers: without
+//any, anyone reaching the server can change the levels, so serve it only
+//on an internal listener.
+func WithLogLevelEndpoint(logLevelEndpoint string, middlewares ...gin.HandlerFunc) transport.Option {
+	return func(t interfaces.Transport) error {
+		if logLevelEndpoint != "" {
+			for _, middleware := range middlewares {
+				if middleware == nil {
+					return errors.New("middleware cannot be nil")
+				}
+			}
+			rest := t.(*Rest)
+			rest.logLevelEndpoint = logLevelEndpoint
+			rest.logLevelAuth = middlewares
+			return nil
+		}
+		return errors.New("log level endpoint cannot be empty")
+	}
+}
+
+//WithHealth exposes the liveness and readiness checks of the µs.
+//The health check endpoint answers as the readiness one.
+func WithHealth(health interfaces.Health) transport.Option {
+	return func(t interfaces.Transport) error {
+		if health != nil {
+			rest := t.(*Rest)
+			rest.health = health
+			return nil
+		}
+		return errors.New("health cannot be nil")
+	}
+}
+
+func WithLivenessEndpoint(livenessEndpoint string) transport.Option {
+	return func(t interfaces.Transport) error {
+		if livenessEndpoint != "" {
+			rest := t.(*Rest)
+			rest.livenessEndpoint = livenessEndpoint
+			return nil
+		}
+		return errors.New("liveness endpoint cannot be empty")
+	}
+}
+
+func WithReadinessEndpoint(readinessEndpoint string) transport.Option {
+	return func(t interfaces.Transport) error {
+		if readinessEndpoint != "" {
+			rest := t.(*Rest)
+			rest.readinessEndpoint = readinessEndpoint
+			return nil
+		}
+		return errors.New("readiness endpoint cannot be empty")
+	}
+}
+
 func WithReadTimeout(timeout time.Duration) transport.Option {
 	return func(i interfaces.Transport) error {
 		if timeout != 0 {
@@ -173,7 +278,7 @@ func WithStatic(path, folder string) transport.Option {
 }
 
 func (r *Rest) scanPort(ip string, port int, timeout time.Duration) error {
-	target := fmt.Sprintf("%s:%d", ip, port)
+	target := net.JoinHostPort(ip, strconv.Itoa(port))
 	conn, err := net.DialTimeout("tcp", target, timeout)
 
 	if err != nil {
@@ -205,12 +310,17 @@ func (r *Rest) findAlternativePort() error {
 
 func New(options ...transport.Option) (*Rest, error) {
 	rest := &Rest{
-		port:           8080,
-		healthEndpoint: "/healthcheck",
-		readTimeout:    5 * time.Second,
-		writeTimeout:   5 * time.Second,
-		router:         gin.New(),
+		port:              8080,
+		healthEndpoint:    "/healthcheck",
+		livenessEndpoint:  "/livez",
+		readinessEndpoint: "/readyz",
+		readTimeout:       5 * time.Second,
+		writeTimeout:      5 * time.Second,
+		router:            gin.New(),
+		ginMetrics:        true,
 	}
+	//before any route, gin middlewares apply only to the routes added after them
+	rest.router.Use(rest.trace, rest.measure)
 
 	for _, option := range options {
 		if err := option(rest); err != nil {
@@ -229,9 +339,24 @@ func (r *Rest) Listen() error {
 	router := r.router
 	logger := r.Logger.Instance().(*logrus.Logger)
 	router.Use(ginlogrus.Logger(logger), gin.Recovery())
-	router.GET(r.healthEndpoint, func(c *gin.Context) {
-		c.String(200, "transport service is good")
-	})
+	if r.health != nil {
+		router.GET(r.healthEndpoint, r.probe(r.health.Ready))
+		router.GET(r.livenessEndpoint, r.probe(r.health.Live))
+		router.GET(r.readinessEndpoint, r.probe(r.health.Ready))
+	} else {
+		router.GET(r.healthEndpoint, func(c *gin.Context) {
+			c.String(200, "transport service is good")
+		})
+		good := func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		}
+		router.GET(r.livenessEndpoint, good)
+		router.GET(r.readinessEndpoint, good)
+	}
+
+	if controller, ok := r.Logger.(interfaces.LevelController); ok && r.logLevelEndpoint != "" {
+		r.logLevels(router, controller)
+	}
 
 	if r.cors {
 		config := cors.DefaultConfig()
@@ -239,8 +364,14 @@ func (r *Rest) Listen() error {
 		router.Use(cors.New(config))
 	}
 
-	p := ginprometheus.NewPrometheus("gin")
-	p.Use(router)
+	if r.metrics != nil {
+		if exporter, ok := r.metrics.(metricsExporter); ok {
+			router.GET(exporter.Path(), gin.WrapH(exporter))
+		}
+	} else if r.ginMetrics {
+		p := ginprometheus.NewPrometheus("gin")
+		p.Use(router)
+	}
 
 	if err := r.findAlternativePort(); err != nil {
 		r.Fatal(err.Error())
@@ -270,6 +401,129 @@ func (r *Rest) Listen() error {
 	return nil
 }
 
+func (r *Rest) probe(check func(context.Context) (bool, interface{})) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, report := check(c.Request.Context())
+		if ok {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, report)
+	}
+}
+
+//metricsExporter is implemented by metrics exposed over http
+type metricsExporter interface {
+	http.Handler
+	Path() string
+}
+
+func (r *Rest) measure(c *gin.Context) {
+	if r.metrics == nil {
+		c.Next()
+		return
+	}
+	start := time.Now()
+	c.Next()
+
+	path := c.FullPath()
+	if path == "" {
+		path = "unknown"
+	}
+	r.metrics.Counter("http_requests_total", 1, map[string]string{
+		"method": c.Request.Method,
+		"path":   path,
+		"status": strconv.Itoa(c.Writer.Status()),
+	})
+	r.metrics.Histogram("http_request_duration_seconds", time.Since(start).Seconds(), map[string]string{
+		"method": c.Request.Method,
+		"path":   path,
+	})
+}
+
+func (r *Rest) trace(c *gin.Context) {
+	if r.tracer == nil {
+		c.Next()
+		return
+	}
+	carrier := make(map[string]string)
+	for key := range c.Request.Header {
+		carrier[key] = c.Request.Header.Get(key)
+	}
+	ctx := r.tracer.Extract(c.Request.Context(), carrier)
+	path := c.FullPath()
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+	ctx, span := r.tracer.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, path), tracer.KindServer)
+	defer span.End()
+	span.SetAttribute("http.method", c.Request.Method)
+	span.SetAttribute("http.route", path)
+	span.SetAttribute("http.target", c.Request.URL.RequestURI())
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttribute("http.status_code", status)
+	if len(c.Errors) > 0 {
+		span.SetError(c.Errors.Last())
+	} else if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+}
+
+type logLevel struct {
+	Component string `json:"component"`
+	Level     string `json:"level" binding:"required"`
+	TTL       string `json:"ttl"`
+}
+
+func (r *Rest) logLevels(engine *gin.Engine, controller interfaces.LevelController) {
+	router := engine.Group("", r.logLevelAuth...)
+	router.GET(r.logLevelEndpoint, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"level":      controller.Level(""),
+			"components": controller.Levels(),
+		})
+	})
+	router.PUT(r.logLevelEndpoint, func(c *gin.Context) {
+		var request logLevel
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ttl time.Duration
+		if request.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(request.TTL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := controller.ChangeLevel(request.Component, request.Level, ttl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r.Warn(fmt.Sprintf("log level of '%s' changed to %s", request.Component, request.Level))
+		c.JSON(http.StatusOK, gin.H{
+			"component": request.Component,
+			"level":     controller.Level(request.Component),
+		})
+	})
+	router.DELETE(r.logLevelEndpoint, func(c *gin.Context) {
+		component := c.Query("component")
+		if err := controller.ResetLevel(component); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"component": component,
+			"level":     controller.Level(component),
+		})
+	})
+}
+
 func (r *Rest) Stop() error {
 	return nil
 }
@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/advancedlogic/box/logger/logrus"
+	"github.com/advancedlogic/box/tracer/otel"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func serve(r *Rest, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	r.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRest_LogLevelAuth(t *testing.T) {
+	logger, err := logrus.New(logrus.WithLevel("info"))
+	assert.NoError(t, err)
+	_, err = New(WithLogLevelEndpoint("/loglevel", nil))
+	assert.Error(t, err)
+	r, err := New(WithLogger(logger), WithLogLevelEndpoint("/loglevel", func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "Bearer admin" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	}))
+	assert.NoError(t, err)
+	r.logLevels(r.router, logger)
+
+	body := `{"level":"debug"}`
+	assert.Equal(t, http.StatusUnauthorized, serve(r, http.MethodPut, "/loglevel", body, nil).Code)
+	assert.Equal(t, "info", logger.Level(""))
+	response := serve(r, http.MethodPut, "/loglevel", body, map[string]string{
+		"Authorization": "Bearer admin", "Content-Type": "application/json",
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "debug", logger.Level(""))
+}
+
+func TestRest_Trace(t *testing.T) {
+	exporter := otel.NewMemoryExporter()
+	tracer, err := otel.New(otel.WithExporter(exporter))
+	assert.NoError(t, err)
+	//the routes added before the tracer are traced too
+	r, err := New(WithGet("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "alice")
+	}), WithTracer(tracer))
+	assert.NoError(t, err)
+
+	response := serve(r, http.MethodGet, "/users/alice", "", map[string]string{
+		"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+	spans := exporter.Spans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /users/:id", spans[0].Name)
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].TraceID)
+	}
+}
+
+//metrics counts the samples by name and labels
+type metrics struct {
+	lock    sync.Mutex
+	samples map[string]float64
+}
+
+func (m *metrics) Instance() interface{} {
+	return m
+}
+
+func (m *metrics) add(name string, value float64, labels map[string]string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.samples[fmt.Sprintf("%s%v", name, labels)] += value
+	return nil
+}
+
+func (m *metrics) Counter(name string, value float64, labels map[string]string) error {
+	return m.add(name, value, labels)
+}
+
+func (m *metrics) Gauge(name string, value float64, labels map[string]string) error {
+	return m.add(name, value, labels)
+}
+
+func (m *metrics) Histogram(name string, value float64, labels map[string]string) error {
+	return m.add(name, 1, labels)
+}
+
+func TestRest_Measure(t *testing.T) {
+	m := &metrics{samples: make(map[string]float64)}
+	r, err := New(WithGet("/users/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "alice")
+	}), WithMetrics(m))
+	assert.NoError(t, err)
+
+	serve(r, http.MethodGet, "/users/alice", "", nil)
+	serve(r, http.MethodGet, "/users/bob", "", nil)
+	assert.Equal(t, float64(2), m.samples["http_requests_total"+fmt.Sprint(map[string]string{
+		"method": "GET", "path": "/users/:id", "status": "200",
+	})])
+	assert.Equal(t, float64(2), m.samples["http_request_duration_seconds"+fmt.Sprint(map[string]string{
+		"method": "GET", "path": "/users/:id",
+	})])
+}